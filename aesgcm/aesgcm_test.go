@@ -0,0 +1,49 @@
+package aesgcm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	sealed, err := Seal(key, []byte("ada@example.com"), []byte("email"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	again, err := Seal(key, []byte("ada@example.com"), []byte("email"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if sealed == again {
+		t.Errorf("sealing twice gave the same ciphertext")
+	}
+
+	plain, err := Open(key, sealed, []byte("email"))
+	if err != nil || string(plain) != "ada@example.com" {
+		t.Fatalf("Open = %q, %v", plain, err)
+	}
+	if _, err := Open(bytes.Repeat([]byte{2}, 32), sealed, []byte("email")); err == nil {
+		t.Errorf("Open with the wrong key succeeded")
+	}
+	if _, err := Open(key, sealed, []byte("name")); err == nil {
+		t.Errorf("Open with the wrong aad succeeded")
+	}
+	if _, err := Open(key, "AAAA", []byte("email")); err == nil {
+		t.Errorf("Open of a short ciphertext succeeded")
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	for _, size := range []int{16, 24, 32} {
+		if _, err := DecodeKey(base64.StdEncoding.EncodeToString(make([]byte, size))); err != nil {
+			t.Errorf("DecodeKey of %d bytes: %v", size, err)
+		}
+	}
+	for _, encoded := range []string{"", "not base64", base64.StdEncoding.EncodeToString(make([]byte, 31))} {
+		if _, err := DecodeKey(encoded); err == nil {
+			t.Errorf("DecodeKey(%q) succeeded", encoded)
+		}
+	}
+}
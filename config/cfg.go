@@ -3,17 +3,59 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DBUser     string
-	DBPassword string
-	DBName     string
-	DBHost     string
-	DBPort     string
-	JWTSecret  string
+	DBUser               string
+	DBPassword           string
+	DBName               string
+	DBHost               string
+	DBPort               string
+	JWTKeysDir           string
+	JWTSigningKid        string
+	JWTRetiredKids       string
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	PasswordResetTTL     time.Duration
+	NotifierFile         string
+	EmailVerificationTTL time.Duration
+	RequireVerifiedEmail bool
+	MFAEncryptionKey     string
+	MFAIssuer            string
+	MFAChallengeTTL      time.Duration
+	MFARequiredForAdmins bool
+	LoginMaxAttempts     int
+	LoginIPMaxAttempts   int
+	LoginBackoffBase     time.Duration
+	LoginBackoffMax      time.Duration
+	LoginLockoutDuration time.Duration
+	TrustedProxyHops     int
+	RevocationCacheTTL   time.Duration
+	UserRetention        time.Duration
+	UserPurgeInterval    time.Duration
+	UserEventRetention   time.Duration
+	OutboxSink           string
+	OutboxFile           string
+	OutboxWebhookURL     string
+	OutboxPollInterval   time.Duration
+	OutboxPublishTimeout time.Duration
+	OutboxMaxAttempts    int
+	OutboxRetryBase      time.Duration
+	OutboxRetryMax       time.Duration
+	OutboxRetention      time.Duration
+	WebhookEncryptionKey string
+	WebhookTimeout       time.Duration
+	WebhookPollInterval  time.Duration
+	WebhookMaxAttempts   int
+	WebhookRetryBase     time.Duration
+	WebhookRetryMax      time.Duration
+	PIIKeyFile           string
+	PIIEncryptedColumns  string
+	AuditHashKey         string
 }
 
 func LoadConfig() *Config {
@@ -22,11 +64,97 @@ func LoadConfig() *Config {
 	}
 
 	return &Config{
-		DBUser:     os.Getenv("DB_USER"),
-		DBPassword: os.Getenv("DB_PASSWORD"),
-		DBName:     os.Getenv("DB_NAME"),
-		DBHost:     os.Getenv("DB_HOST"),
-		DBPort:     os.Getenv("DB_PORT"),
-		JWTSecret:  os.Getenv("JWT_SECRET"),
+		DBUser:               os.Getenv("DB_USER"),
+		DBPassword:           os.Getenv("DB_PASSWORD"),
+		DBName:               os.Getenv("DB_NAME"),
+		DBHost:               os.Getenv("DB_HOST"),
+		DBPort:               os.Getenv("DB_PORT"),
+		JWTKeysDir:           os.Getenv("JWT_KEYS_DIR"),
+		JWTSigningKid:        os.Getenv("JWT_SIGNING_KID"),
+		JWTRetiredKids:       os.Getenv("JWT_RETIRED_KIDS"),
+		AccessTokenTTL:       getDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:      getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		PasswordResetTTL:     getDuration("PASSWORD_RESET_TTL", time.Hour),
+		NotifierFile:         os.Getenv("NOTIFIER_FILE"),
+		EmailVerificationTTL: getDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		RequireVerifiedEmail: getBool("REQUIRE_VERIFIED_EMAIL", false),
+		MFAEncryptionKey:     os.Getenv("MFA_ENCRYPTION_KEY"),
+		MFAIssuer:            getString("MFA_ISSUER", "User Service"),
+		MFAChallengeTTL:      getDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+		MFARequiredForAdmins: getBool("MFA_REQUIRED_FOR_ADMINS", true),
+		LoginMaxAttempts:     getInt("LOGIN_MAX_ATTEMPTS", 5),
+		LoginIPMaxAttempts:   getInt("LOGIN_IP_MAX_ATTEMPTS", 50),
+		LoginBackoffBase:     getDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginBackoffMax:      getDuration("LOGIN_BACKOFF_MAX", 30*time.Second),
+		LoginLockoutDuration: getDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		TrustedProxyHops:     getInt("TRUSTED_PROXY_HOPS", 0),
+		RevocationCacheTTL:   getDuration("REVOCATION_CACHE_TTL", 30*time.Second),
+		UserRetention:        getDuration("USER_RETENTION", 30*24*time.Hour),
+		UserPurgeInterval:    getDuration("USER_PURGE_INTERVAL", time.Hour),
+		UserEventRetention:   getDuration("USER_EVENT_RETENTION", 7*24*time.Hour),
+		OutboxSink:           os.Getenv("OUTBOX_SINK"),
+		OutboxFile:           os.Getenv("OUTBOX_FILE"),
+		OutboxWebhookURL:     os.Getenv("OUTBOX_WEBHOOK_URL"),
+		OutboxPollInterval:   getDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		OutboxPublishTimeout: getDuration("OUTBOX_PUBLISH_TIMEOUT", 10*time.Second),
+		OutboxMaxAttempts:    getInt("OUTBOX_MAX_ATTEMPTS", 10),
+		OutboxRetryBase:      getDuration("OUTBOX_RETRY_BASE", time.Second),
+		OutboxRetryMax:       getDuration("OUTBOX_RETRY_MAX", time.Hour),
+		OutboxRetention:      getDuration("OUTBOX_RETENTION", 7*24*time.Hour),
+		WebhookEncryptionKey: os.Getenv("WEBHOOK_ENCRYPTION_KEY"),
+		WebhookTimeout:       getDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookPollInterval:  getDuration("WEBHOOK_POLL_INTERVAL", time.Second),
+		WebhookMaxAttempts:   getInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookRetryBase:     getDuration("WEBHOOK_RETRY_BASE", 30*time.Second),
+		WebhookRetryMax:      getDuration("WEBHOOK_RETRY_MAX", 6*time.Hour),
+		PIIKeyFile:           os.Getenv("PII_KEY_FILE"),
+		PIIEncryptedColumns:  getString("PII_ENCRYPTED_COLUMNS", "name,email"),
+		AuditHashKey:         os.Getenv("AUDIT_HASH_KEY"),
 	}
 }
+
+func getString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration in %s, using default %s: %v", key, fallback, err)
+		return fallback
+	}
+	return d
+}
+
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer in %s, using default %d: %v", key, fallback, err)
+		return fallback
+	}
+	return i
+}
+
+func getBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean in %s, using default %t: %v", key, fallback, err)
+		return fallback
+	}
+	return b
+}
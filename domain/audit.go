@@ -0,0 +1,158 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Audited actions.
+const (
+	AuditUserCreate        = "user.create"
+	AuditUserUpdate        = "user.update"
+	AuditUserDelete        = "user.delete"
+	AuditUserErase         = "user.erase"
+	AuditUserExport        = "user.export"
+	AuditUserRestore       = "user.restore"
+	AuditUserStatus        = "user.status"
+	AuditUserMetadata      = "user.metadata"
+	AuditUserPasswordReset = "user.password_reset"
+	AuditUserEmailVerify   = "user.email_verify"
+	AuditLogin             = "auth.login"
+	AuditLoginMFA          = "auth.login_mfa"
+	AuditLogout            = "auth.logout"
+	AuditLogoutAll         = "auth.logout_all"
+	AuditMFADisable        = "auth.mfa_disable"
+	AuditMFAReset          = "auth.mfa_reset"
+	AuditMFARecoveryCodes  = "auth.mfa_recovery_codes"
+)
+
+// Audit event outcomes.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditRedacted replaces values that must not be recorded, e.g. password
+// hashes.
+const AuditRedacted = "[REDACTED]"
+
+// AuditEvent records who did what to which user. ActorID is nil for
+// anonymous callers, TargetUserID when the user is unknown, e.g. after a
+// login with an unknown email. Events form hash chains: Hash covers the
+// event and PrevHash, the hash of the event before it in the same Chain,
+// so changing or removing an event breaks its chain. Chain itself is not
+// covered; moving an event to another chain breaks that chain instead.
+type AuditEvent struct {
+	ID           int64        `db:"id"`
+	Chain        int          `db:"chain"`
+	OccurredAt   time.Time    `db:"occurred_at"`
+	ActorID      *int         `db:"actor_id"`
+	TargetUserID *int         `db:"target_user_id"`
+	Action       string       `db:"action"`
+	Outcome      string       `db:"outcome"`
+	Reason       string       `db:"reason"`
+	Changes      AuditChanges `db:"changes"`
+	IP           string       `db:"ip"`
+	RequestID    string       `db:"request_id"`
+	PrevHash     string       `db:"prev_hash"`
+	Hash         string       `db:"hash"`
+}
+
+// AuditChange is the value of a field before and after a change. Values
+// are JSON values; nil means the field did not exist.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditChanges maps changed fields to their change. It is stored as JSONB.
+type AuditChanges map[string]AuditChange
+
+func (c *AuditChanges) Scan(src interface{}) error {
+	data, ok := src.([]byte)
+	if !ok {
+		return errors.New("audit changes must be JSON")
+	}
+	return json.Unmarshal(data, c)
+}
+
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(c)
+}
+
+// ComputeHash returns the chain hash of the event from its contents and
+// PrevHash. ID and Hash are not covered.
+func (e *AuditEvent) ComputeHash() (string, error) {
+	// Changes go through JSON first, so that the hash is the same for the
+	// values recorded and for the values read back from the database.
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return "", err
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(changes, &normalized); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(struct {
+		PrevHash     string      `json:"prev_hash"`
+		OccurredAt   string      `json:"occurred_at"`
+		ActorID      *int        `json:"actor_id"`
+		TargetUserID *int        `json:"target_user_id"`
+		Action       string      `json:"action"`
+		Outcome      string      `json:"outcome"`
+		Reason       string      `json:"reason"`
+		Changes      interface{} `json:"changes"`
+		IP           string      `json:"ip"`
+		RequestID    string      `json:"request_id"`
+	}{
+		PrevHash:     e.PrevHash,
+		OccurredAt:   e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		ActorID:      e.ActorID,
+		TargetUserID: e.TargetUserID,
+		Action:       e.Action,
+		Outcome:      e.Outcome,
+		Reason:       e.Reason,
+		Changes:      normalized,
+		IP:           e.IP,
+		RequestID:    e.RequestID,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditQuery selects a page of audit events, newest first. Before is
+// decoded from PageToken by the service.
+type AuditQuery struct {
+	ActorID      *int
+	TargetUserID *int
+	Action       string
+	Since        *time.Time
+	Until        *time.Time
+	Limit        int
+	PageToken    string
+	Before       int64
+}
+
+type AuditPage struct {
+	Events        []AuditEvent
+	NextPageToken string
+}
+
+// AuditVerification is the result of checking the audit hash chain.
+// BrokenAt is the id of the first event that does not match the chain, nil
+// if all Checked events do.
+type AuditVerification struct {
+	Checked  int64
+	BrokenAt *int64
+}
@@ -0,0 +1,9 @@
+package domain
+
+// ClientInfo describes where a request came from. RequestID correlates the
+// request across logs and the audit log.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	RequestID string
+}
@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+type EmailVerificationToken struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	Email     string     `db:"email"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
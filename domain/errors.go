@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Error kinds shared by repositories and services. Transports translate them
+// into HTTP statuses and gRPC codes.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrAlreadyExists    = errors.New("already exists")
+	ErrValidation       = errors.New("validation failed")
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrTooManyRequests  = errors.New("too many requests")
+	ErrInternal         = errors.New("internal error")
+)
+
+// Error is a client-facing error of a given kind. Cause holds the underlying
+// error for logging and is never shown to clients. RetryAfter, when set,
+// tells the client how long to wait before trying again.
+type Error struct {
+	Kind       error
+	Message    string
+	Cause      error
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Cause}
+}
+
+func NotFound(format string, args ...interface{}) error {
+	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func AlreadyExists(format string, args ...interface{}) error {
+	return &Error{Kind: ErrAlreadyExists, Message: fmt.Sprintf(format, args...)}
+}
+
+func Validation(format string, args ...interface{}) error {
+	return &Error{Kind: ErrValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+func Unauthenticated(format string, args ...interface{}) error {
+	return &Error{Kind: ErrUnauthenticated, Message: fmt.Sprintf(format, args...)}
+}
+
+func PermissionDenied(format string, args ...interface{}) error {
+	return &Error{Kind: ErrPermissionDenied, Message: fmt.Sprintf(format, args...)}
+}
+
+func TooManyRequests(retryAfter time.Duration, format string, args ...interface{}) error {
+	return &Error{Kind: ErrTooManyRequests, Message: fmt.Sprintf(format, args...), RetryAfter: retryAfter}
+}
+
+func Internal(message string, cause error) error {
+	return &Error{Kind: ErrInternal, Message: message, Cause: cause}
+}
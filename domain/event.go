@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// User event types.
+const (
+	UserEventCreated = "created"
+	UserEventUpdated = "updated"
+	UserEventDeleted = "deleted"
+	// UserEventErased tells consumers to erase their copies of the user's
+	// personal data. The user it carries is already anonymised.
+	UserEventErased = "erased"
+)
+
+// UserEvent is a change to a user together with the state of the user right
+// after it. Revisions grow with every change, in the order the changes were
+// committed, so a watcher can resume after the last revision it has seen.
+type UserEvent struct {
+	Revision   int64
+	Type       string
+	User       User
+	OccurredAt time.Time
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// ErasedUserName replaces the name of a user whose personal data was erased.
+const ErasedUserName = "Erased user"
+
+// UserExport is everything stored about a user, as handed out for a data
+// subject access request. User.Password is never set.
+type UserExport struct {
+	User         User
+	MFAEnabledAt *time.Time
+	Sessions     []Session
+	// AuditEvents lists the events the user performed or was the target of,
+	// oldest first.
+	AuditEvents []AuditEvent
+	ExportedAt  time.Time
+}
@@ -0,0 +1,22 @@
+package domain
+
+import "time"
+
+// Identity describes the authenticated caller of a request.
+type Identity struct {
+	UserID int
+	Role   string
+	// SessionID, TokenID and ExpiresAt identify the access token the caller
+	// presented.
+	SessionID string
+	TokenID   string
+	ExpiresAt time.Time
+	// MFA is set when the caller signed in with a second factor, and
+	// MFARequired when the role of the caller requires that for admin actions.
+	MFA         bool
+	MFARequired bool
+}
+
+func (i *Identity) IsAdmin() bool {
+	return i.Role == RoleAdmin
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// Scopes failed login attempts are tracked in.
+const (
+	ThrottleAccount = "account"
+	ThrottleIP      = "ip"
+)
+
+// LoginThrottle tracks consecutive failed logins for an account or an IP.
+type LoginThrottle struct {
+	Scope        string     `db:"scope"`
+	Key          string     `db:"key"`
+	Failures     int        `db:"failures"`
+	LastFailedAt time.Time  `db:"last_failed_at"`
+	LockedUntil  *time.Time `db:"locked_until"`
+}
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// MaxMetadataSize is the largest a user's metadata may grow, in bytes of JSON.
+const MaxMetadataSize = 64 * 1024
+
+// metadataKeyPattern matches namespaced keys such as "billing/plan". The
+// namespace identifies the integrating team, the name is free within it.
+var metadataKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{0,62}/[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+// IsValidMetadataKey reports whether key is a valid "namespace/name" key.
+func IsValidMetadataKey(key string) bool {
+	return metadataKeyPattern.MatchString(key)
+}
+
+// Metadata holds arbitrary attributes attached to a user by integrations,
+// keyed by "namespace/name". Values are raw JSON.
+type Metadata map[string]json.RawMessage
+
+// Scan reads metadata from a JSONB column.
+func (m *Metadata) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*m = Metadata{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", src)
+	}
+	return json.Unmarshal(data, m)
+}
+
+// Value writes metadata to a JSONB column.
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// MetadataFilter selects users whose metadata has Key, and if Value is set,
+// whose value for Key equals Value.
+type MetadataFilter struct {
+	Key   string
+	Value json.RawMessage
+}
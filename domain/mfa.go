@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// UserMFA holds a user's TOTP enrollment. Secret is encrypted at rest and
+// EnabledAt stays nil until the enrollment is confirmed with a valid code.
+type UserMFA struct {
+	UserID       int        `db:"user_id"`
+	Secret       string     `db:"secret"`
+	LastUsedStep *int64     `db:"last_used_step"`
+	EnabledAt    *time.Time `db:"enabled_at"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+type TOTPEnrollment struct {
+	Secret     string
+	OTPAuthURI string
+}
+
+// LoginResult holds either issued tokens or, for accounts with two-factor
+// authentication, a challenge token to be completed with a TOTP or recovery code.
+type LoginResult struct {
+	Tokens       *TokenPair
+	MFAChallenge string
+}
@@ -0,0 +1,49 @@
+package domain
+
+import "time"
+
+// Outbox message statuses. Pending messages are retried until they are
+// published or run out of attempts and become dead.
+const (
+	OutboxPending   = "pending"
+	OutboxPublished = "published"
+	OutboxDead      = "dead"
+)
+
+// OutboxMessage is an event stored in the same transaction as the change it
+// describes, waiting to be published to other services. Payload is JSON.
+type OutboxMessage struct {
+	ID        int64     `db:"id"`
+	Type      string    `db:"type"`
+	UserID    int       `db:"user_id"`
+	Payload   []byte    `db:"payload"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// UserPayload is a user as published to other services.
+type UserPayload struct {
+	ID              int        `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	Role            string     `json:"role"`
+	Status          string     `json:"status"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	CreatedAt       string     `json:"created_at"`
+	Metadata        Metadata   `json:"metadata"`
+	Profile
+}
+
+func NewUserPayload(user *User) UserPayload {
+	return UserPayload{
+		ID:              user.ID,
+		Name:            user.Name,
+		Email:           user.Email,
+		Role:            user.Role,
+		Status:          user.Status,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		CreatedAt:       user.CreatedAt,
+		Metadata:        user.Metadata,
+		Profile:         user.Profile,
+	}
+}
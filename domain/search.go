@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"strings"
+	"unicode"
+)
+
+// UserSearch describes a page of full-text and fuzzy search results. Terms
+// and Offset are derived by the service from Query and PageToken.
+type UserSearch struct {
+	Query     string
+	Limit     int
+	PageToken string
+	Terms     []string
+	Offset    int
+}
+
+// UserSearchHit is a user matching a search. Highlights maps the names of
+// matched fields to their HTML-escaped value with matches wrapped in <em>.
+type UserSearchHit struct {
+	User       User
+	Rank       float64
+	Highlights map[string]string
+}
+
+type UserSearchPage struct {
+	Hits          []UserSearchHit
+	NextPageToken string
+	TotalCount    int
+}
+
+// SearchTerms splits text into the lowercase words of letters and digits
+// that searches match on.
+func SearchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
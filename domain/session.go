@@ -0,0 +1,15 @@
+package domain
+
+import "time"
+
+// Session is one signed-in device or client of a user. Access and refresh
+// tokens are bound to the session they were issued for.
+type Session struct {
+	ID         string     `db:"id"`
+	UserID     int        `db:"user_id"`
+	UserAgent  string     `db:"user_agent"`
+	IP         string     `db:"ip"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastSeenAt time.Time  `db:"last_seen_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
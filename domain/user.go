@@ -1,9 +1,128 @@
 package domain
 
+import "time"
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Account statuses. Only active users can sign in.
+const (
+	StatusPending   = "pending"
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusDisabled  = "disabled"
+)
+
+// statusTransitions lists the statuses each status can change to.
+var statusTransitions = map[string][]string{
+	StatusPending:   {StatusActive, StatusDisabled},
+	StatusActive:    {StatusSuspended, StatusDisabled},
+	StatusSuspended: {StatusActive, StatusDisabled},
+	StatusDisabled:  {StatusActive},
+}
+
+// IsValidStatus reports whether status is a known account status.
+func IsValidStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether an account may change from one status to another.
+func CanTransition(from, to string) bool {
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange moves a user from one status to another for a reason.
+type StatusChange struct {
+	From   string
+	To     string
+	Reason string
+}
+
 type User struct {
-	ID        int    `db:"id"`
-	Name      string `db:"name"`
-	Email     string `db:"email"`
-	Password  string `db:"password"`
-	CreatedAt string `db:"created_at"`
+	ID              int        `db:"id"`
+	Name            string     `db:"name"`
+	Email           string     `db:"email"`
+	Password        string     `db:"password"`
+	Role            string     `db:"role"`
+	Status          string     `db:"status"`
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+	CreatedAt       string     `db:"created_at"`
+	Metadata        Metadata   `db:"metadata"`
+	Profile
+}
+
+// Profile holds optional personal details of a user. Empty strings and a nil
+// DateOfBirth mean the value is not set.
+type Profile struct {
+	FirstName   string `db:"first_name" json:"first_name"`
+	LastName    string `db:"last_name" json:"last_name"`
+	DisplayName string `db:"display_name" json:"display_name"`
+	// PhoneNumber is in E.164 format, e.g. +14155552671.
+	PhoneNumber string `db:"phone_number" json:"phone_number"`
+	// Locale is a BCP 47 language tag, e.g. en-US.
+	Locale string `db:"locale" json:"locale"`
+	// Timezone is an IANA time zone name, e.g. Europe/Berlin.
+	Timezone  string `db:"timezone" json:"timezone"`
+	AvatarURL string `db:"avatar_url" json:"avatar_url"`
+	// DateOfBirth is formatted as YYYY-MM-DD.
+	DateOfBirth *string `db:"date_of_birth" json:"date_of_birth"`
+}
+
+// UserPatch holds the fields of a partial update. Nil fields are left
+// unchanged; an empty profile field clears it.
+type UserPatch struct {
+	Name        *string
+	Email       *string
+	Password    *string
+	FirstName   *string
+	LastName    *string
+	DisplayName *string
+	PhoneNumber *string
+	Locale      *string
+	Timezone    *string
+	AvatarURL   *string
+	DateOfBirth *string
+}
+
+// Fields users can be sorted by when listing.
+const (
+	UserSortID        = "id"
+	UserSortName      = "name"
+	UserSortEmail     = "email"
+	UserSortCreatedAt = "created_at"
+)
+
+// UserQuery describes a page of users to list. After is decoded from
+// PageToken by the service and used by the repository for keyset pagination.
+type UserQuery struct {
+	Limit         int
+	PageToken     string
+	EmailPrefix   string
+	NamePrefix    string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	SortBy        string
+	Descending    bool
+	Metadata      *MetadataFilter
+	After         *UserCursor
+}
+
+// UserCursor points at the last user of the previous page.
+type UserCursor struct {
+	Value string
+	ID    int
+}
+
+type UserPage struct {
+	Users         []User
+	NextPageToken string
+	TotalCount    int
 }
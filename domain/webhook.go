@@ -0,0 +1,84 @@
+package domain
+
+import "time"
+
+// Event types webhooks can subscribe to. They match the outbox message
+// types of user changes.
+const (
+	WebhookUserCreated = "user." + UserEventCreated
+	WebhookUserUpdated = "user." + UserEventUpdated
+	WebhookUserDeleted = "user." + UserEventDeleted
+	WebhookUserErased  = "user." + UserEventErased
+)
+
+// Webhook delivery statuses. Pending deliveries are retried until they
+// succeed or run out of attempts and fail.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+func IsValidWebhookEventType(eventType string) bool {
+	switch eventType {
+	case WebhookUserCreated, WebhookUserUpdated, WebhookUserDeleted, WebhookUserErased:
+		return true
+	}
+	return false
+}
+
+// Webhook is a partner endpoint subscribed to user changes. Empty EventTypes
+// subscribes to all of them. Secret signs the deliveries; it is stored
+// encrypted and only shown when it is set.
+type Webhook struct {
+	ID         int
+	URL        string
+	EventTypes []string
+	Secret     string
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// WebhookDelivery is one outbox message to be sent to one webhook.
+// LastStatusCode is nil until the webhook has responded.
+type WebhookDelivery struct {
+	ID             int64      `db:"id"`
+	WebhookID      int        `db:"webhook_id"`
+	MessageID      int64      `db:"message_id"`
+	EventType      string     `db:"event_type"`
+	Payload        []byte     `db:"payload"`
+	Status         string     `db:"status"`
+	Attempts       int        `db:"attempts"`
+	LastStatusCode *int       `db:"last_status_code"`
+	LastError      string     `db:"last_error"`
+	NextAttemptAt  time.Time  `db:"next_attempt_at"`
+	CreatedAt      time.Time  `db:"created_at"`
+	DeliveredAt    *time.Time `db:"delivered_at"`
+	Log            []WebhookAttempt
+}
+
+// WebhookAttempt is one try to deliver a webhook. StatusCode is nil when no
+// response was received.
+type WebhookAttempt struct {
+	DeliveryID  int64     `db:"delivery_id"`
+	StatusCode  *int      `db:"status_code"`
+	Error       string    `db:"error"`
+	DurationMS  int64     `db:"duration_ms"`
+	AttemptedAt time.Time `db:"attempted_at"`
+}
+
+// WebhookDeliveryQuery selects a page of deliveries of a webhook, newest
+// first. Before is decoded from PageToken by the service.
+type WebhookDeliveryQuery struct {
+	WebhookID int
+	Status    string
+	Limit     int
+	PageToken string
+	Before    int64
+}
+
+type WebhookDeliveryPage struct {
+	Deliveries    []WebhookDelivery
+	NextPageToken string
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"user-srv/domain"
+)
+
+type AuditChangeResponse struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type AuditEventResponse struct {
+	ID           int64     `json:"id"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	ActorID      *int      `json:"actor_id"`
+	TargetUserID *int      `json:"target_user_id"`
+	Action       string    `json:"action" example:"user.update"`
+	Outcome      string    `json:"outcome" enums:"success,failure"`
+	Reason       string    `json:"reason,omitempty"`
+	// Changed fields with their values before and after. Password hashes are redacted.
+	Changes   map[string]AuditChangeResponse `json:"changes"`
+	IP        string                         `json:"ip,omitempty"`
+	RequestID string                         `json:"request_id,omitempty"`
+	PrevHash  string                         `json:"prev_hash"`
+	Hash      string                         `json:"hash"`
+}
+
+type AuditEventListResponse struct {
+	Events        []AuditEventResponse `json:"events"`
+	NextPageToken string               `json:"next_page_token,omitempty"`
+}
+
+type AuditVerificationResponse struct {
+	Checked int64 `json:"checked"`
+	// ID of the first event that does not match the hash chain; absent if the chain is intact.
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	Valid    bool   `json:"valid"`
+}
+
+// AuditEvents List audit events
+// @Summary List audit events
+// @Description List recorded user mutations and authentication events, newest first (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param actor_id query int false "Only events by this user"
+// @Param user_id query int false "Only events on this user"
+// @Param action query string false "Only events with this action, e.g. user.update or auth.login"
+// @Param since query string false "Only events at or after this RFC 3339 time"
+// @Param until query string false "Only events before this RFC 3339 time"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param page_token query string false "Token from next_page_token of the previous page"
+// @Success 200 {object} AuditEventListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /audit [get]
+func (h *UserHandler) AuditEvents(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := domain.AuditQuery{
+		Action:    params.Get("action"),
+		PageToken: params.Get("page_token"),
+	}
+	if actorID := params.Get("actor_id"); actorID != "" {
+		value, err := strconv.Atoi(actorID)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid actor_id")
+			return
+		}
+		query.ActorID = &value
+	}
+	if userID := params.Get("user_id"); userID != "" {
+		value, err := strconv.Atoi(userID)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid user_id")
+			return
+		}
+		query.TargetUserID = &value
+	}
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		query.Limit = value
+	}
+	var err error
+	if query.Since, err = parseTimeParam(params.Get("since")); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid since, expected RFC 3339 time")
+		return
+	}
+	if query.Until, err = parseTimeParam(params.Get("until")); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid until, expected RFC 3339 time")
+		return
+	}
+
+	page, err := h.service.ListAuditEvents(r.Context(), query)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	response := AuditEventListResponse{
+		Events:        make([]AuditEventResponse, 0, len(page.Events)),
+		NextPageToken: page.NextPageToken,
+	}
+	for i := range page.Events {
+		response.Events = append(response.Events, toAuditEventResponse(&page.Events[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// VerifyAudit Check the audit log for tampering
+// @Summary Verify audit log
+// @Description Recompute the hash chain of the audit log and report the first event that was changed or follows removed events (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} AuditVerificationResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /audit/verify [get]
+func (h *UserHandler) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	result, err := h.service.VerifyAuditLog(r.Context())
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditVerificationResponse{
+		Checked:  result.Checked,
+		BrokenAt: result.BrokenAt,
+		Valid:    result.BrokenAt == nil,
+	})
+}
+
+func toAuditEventResponse(event *domain.AuditEvent) AuditEventResponse {
+	response := AuditEventResponse{
+		ID:           event.ID,
+		OccurredAt:   event.OccurredAt,
+		ActorID:      event.ActorID,
+		TargetUserID: event.TargetUserID,
+		Action:       event.Action,
+		Outcome:      event.Outcome,
+		Reason:       event.Reason,
+		Changes:      make(map[string]AuditChangeResponse, len(event.Changes)),
+		IP:           event.IP,
+		RequestID:    event.RequestID,
+		PrevHash:     event.PrevHash,
+		Hash:         event.Hash,
+	}
+	for field, change := range event.Changes {
+		response.Changes[field] = AuditChangeResponse{Before: change.Before, After: change.After}
+	}
+	return response
+}
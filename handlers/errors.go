@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"user-srv/domain"
+)
+
+// sendServiceError writes err with the HTTP status matching its domain kind.
+// Unclassified errors are logged and hidden behind a generic message.
+func sendServiceError(w http.ResponseWriter, err error) {
+	var domainErr *domain.Error
+	if !errors.As(err, &domainErr) {
+		log.Printf("Unexpected error: %v", err)
+		sendError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	status := httpStatus(domainErr.Kind)
+	if status == http.StatusInternalServerError {
+		log.Printf("Internal error: %s: %v", domainErr.Message, domainErr.Cause)
+	}
+	if domainErr.RetryAfter > 0 {
+		seconds := int(math.Ceil(domainErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	sendError(w, status, domainErr.Message)
+}
+
+func httpStatus(kind error) int {
+	switch kind {
+	case domain.ErrNotFound:
+		return http.StatusNotFound
+	case domain.ErrAlreadyExists:
+		return http.StatusConflict
+	case domain.ErrValidation:
+		return http.StatusUnprocessableEntity
+	case domain.ErrUnauthenticated:
+		return http.StatusUnauthorized
+	case domain.ErrPermissionDenied:
+		return http.StatusForbidden
+	case domain.ErrTooManyRequests:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
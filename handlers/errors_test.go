@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-srv/domain"
+)
+
+func TestSendServiceError(t *testing.T) {
+	tests := []struct {
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{domain.NotFound("user with id %d not found", 1), http.StatusNotFound, "user with id 1 not found"},
+		{domain.AlreadyExists("user already exists"), http.StatusConflict, "user already exists"},
+		{domain.Validation("name cannot be empty"), http.StatusUnprocessableEntity, "name cannot be empty"},
+		{domain.Unauthenticated("invalid token"), http.StatusUnauthorized, "invalid token"},
+		{domain.PermissionDenied("admin role required"), http.StatusForbidden, "admin role required"},
+		{domain.TooManyRequests(time.Minute, "too many attempts"), http.StatusTooManyRequests, "too many attempts"},
+		{errors.New("connection refused"), http.StatusInternalServerError, "internal error"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		sendServiceError(w, tt.err)
+
+		var body ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if w.Code != tt.wantStatus || body.Error != tt.wantMessage {
+			t.Errorf("sendServiceError(%v) = %d %q, want %d %q", tt.err, w.Code, body.Error, tt.wantStatus, tt.wantMessage)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	sendServiceError(w, domain.TooManyRequests(1500*time.Millisecond, "too many attempts"))
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+}
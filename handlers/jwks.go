@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"user-srv/domain"
+)
+
+type JWKSResponse struct {
+	Keys []domain.JWK `json:"keys"`
+}
+
+// JWKS Publish token verification keys
+// @Summary JSON Web Key Set
+// @Description Public keys for verifying access tokens; select the key by the token's kid header
+// @Tags auth
+// @Produce json
+// @Success 200 {object} JWKSResponse
+// @Router /.well-known/jwks.json [get]
+func (h *UserHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(JWKSResponse{Keys: h.service.JWKS()})
+}
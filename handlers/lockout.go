@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type LoginLockoutResponse struct {
+	UserID       int        `json:"user_id"`
+	Failures     int        `json:"failures"`
+	LastFailedAt *time.Time `json:"last_failed_at,omitempty"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+}
+
+// LoginLockout Get failed login state of a user
+// @Summary Get login lockout
+// @Description Show consecutive failed logins and lockout of a user's account (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} LoginLockoutResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/lockout [get]
+func (h *UserHandler) LoginLockout(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	throttle, err := h.service.GetLoginLockout(r.Context(), id)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	response := LoginLockoutResponse{
+		UserID:      id,
+		Failures:    throttle.Failures,
+		LockedUntil: throttle.LockedUntil,
+	}
+	if !throttle.LastFailedAt.IsZero() {
+		response.LastFailedAt = &throttle.LastFailedAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Unlock Clear failed logins of a user
+// @Summary Unlock user
+// @Description Reset failed logins and lift the lockout of a user's account (admin only)
+// @Tags admin
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/lockout [delete]
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.service.UnlockUser(r.Context(), id); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Logout Revoke the current access token
+// @Summary Logout
+// @Description Revoke the access token of the request and, if given, its refresh token
+// @Tags auth
+// @Accept json
+// @Security BearerAuth
+// @Param request body LogoutRequest false "Refresh token to revoke along with the access token"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized access"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /logout [post]
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.Logout(r.Context(), req.RefreshToken); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll Revoke all tokens of the current user
+// @Summary Logout everywhere
+// @Description Revoke every access and refresh token issued to the current user
+// @Tags auth
+// @Security BearerAuth
+// @Success 204 "No Content"
+// @Failure 401 {object} ErrorResponse "Unauthorized access"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /logout-all [post]
+func (h *UserHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.LogoutAll(r.Context()); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"user-srv/domain"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GetMetadata Get the metadata of a user
+// @Summary Get user metadata
+// @Description Get all metadata attached to a user, keyed by namespace/name
+// @Tags metadata
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/metadata [get]
+func (h *UserHandler) GetMetadata(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	metadata, err := h.service.GetMetadata(r.Context(), id)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metadata)
+}
+
+// UpdateMetadata Merge metadata into a user
+// @Summary Update user metadata
+// @Description Add or replace the given metadata keys; keys set to null are removed. Keys have the form namespace/name.
+// @Tags metadata
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param metadata body map[string]interface{} true "Metadata keys to change"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse "Invalid key or metadata too large"
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/metadata [patch]
+func (h *UserHandler) UpdateMetadata(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var changes domain.Metadata
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	metadata, err := h.service.UpdateMetadata(r.Context(), id, changes)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metadata)
+}
+
+// DeleteMetadataKey Remove a metadata key from a user
+// @Summary Delete user metadata key
+// @Description Remove one metadata key from a user. Removing a missing key succeeds.
+// @Tags metadata
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param namespace path string true "Key namespace"
+// @Param name path string true "Key name"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/metadata/{namespace}/{name} [delete]
+func (h *UserHandler) DeleteMetadataKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	key := chi.URLParam(r, "namespace") + "/" + chi.URLParam(r, "name")
+	if err := h.service.DeleteMetadataKey(r.Context(), id, key); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"user-srv/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type CompleteMFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// EnrollTOTP Start TOTP enrollment
+// @Summary Enroll TOTP
+// @Description Generate a new TOTP secret for the current user. It becomes active once confirmed.
+// @Tags mfa
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} TOTPEnrollmentResponse
+// @Failure 401 {object} ErrorResponse "Unauthorized access"
+// @Failure 409 {object} ErrorResponse "Two-factor authentication already enabled"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /users/me/mfa/totp [post]
+func (h *UserHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	identity, ok := services.IdentityFromContext(r.Context())
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	enrollment, err := h.service.EnrollTOTP(r.Context(), identity.UserID)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPEnrollmentResponse{
+		Secret:     enrollment.Secret,
+		OTPAuthURI: enrollment.OTPAuthURI,
+	})
+}
+
+// ConfirmTOTP Confirm TOTP enrollment
+// @Summary Confirm TOTP
+// @Description Enable two-factor authentication with a code from the authenticator app and receive recovery codes
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body ConfirmTOTPRequest true "TOTP code"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized access"
+// @Failure 409 {object} ErrorResponse "Two-factor authentication already enabled"
+// @Failure 422 {object} ErrorResponse "Invalid code"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /users/me/mfa/totp/confirm [post]
+func (h *UserHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	identity, ok := services.IdentityFromContext(r.Context())
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	var req ConfirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	codes, err := h.service.ConfirmTOTP(r.Context(), identity.UserID, req.Code)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodes Replace recovery codes
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes of the current user, used or not, after checking a TOTP code
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body ConfirmTOTPRequest true "TOTP code"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized access"
+// @Failure 422 {object} ErrorResponse "Invalid code or two-factor authentication not enabled"
+// @Failure 429 {object} ErrorResponse "Too many failed attempts"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /users/me/mfa/recovery-codes [post]
+func (h *UserHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	identity, ok := services.IdentityFromContext(r.Context())
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	var req ConfirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(r.Context(), identity.UserID, req.Code)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP Turn two-factor authentication off
+// @Summary Disable TOTP
+// @Description Turn two-factor authentication off for the current user after checking a TOTP code
+// @Tags mfa
+// @Accept json
+// @Security BearerAuth
+// @Param request body ConfirmTOTPRequest true "TOTP code"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized access"
+// @Failure 422 {object} ErrorResponse "Invalid code or two-factor authentication not enabled"
+// @Failure 429 {object} ErrorResponse "Too many failed attempts"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /users/me/mfa/totp/disable [post]
+func (h *UserHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	identity, ok := services.IdentityFromContext(r.Context())
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	var req ConfirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.service.DisableTOTP(r.Context(), identity.UserID, req.Code); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetMFA Reset two-factor authentication of a user
+// @Summary Reset MFA
+// @Description Turn two-factor authentication off for a user who lost their authenticator and recovery codes, and sign them out everywhere (admin only)
+// @Tags admin
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/mfa [delete]
+func (h *UserHandler) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.service.ResetMFA(r.Context(), id); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CompleteMFALogin Finish login with a second factor
+// @Summary Complete MFA login
+// @Description Exchange the MFA token returned by /login and a TOTP or recovery code for tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body CompleteMFALoginRequest true "MFA token and code"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Invalid token or code"
+// @Failure 422 {object} ErrorResponse "Validation error"
+// @Failure 429 {object} ErrorResponse "Too many failed attempts"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /login/mfa [post]
+func (h *UserHandler) CompleteMFALogin(w http.ResponseWriter, r *http.Request) {
+	var req CompleteMFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	tokens, err := h.service.CompleteMFALogin(r.Context(), req.MFAToken, req.Code)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	})
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"user-srv/domain"
+	"user-srv/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ExportedSessionResponse struct {
+	ID         string     `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// UserExportAccountResponse is user.json in a ZIP export.
+type UserExportAccountResponse struct {
+	ExportedAt   time.Time    `json:"exported_at"`
+	User         UserResponse `json:"user"`
+	MFAEnabledAt *time.Time   `json:"mfa_enabled_at,omitempty"`
+}
+
+type UserExportResponse struct {
+	UserExportAccountResponse
+	Sessions    []ExportedSessionResponse `json:"sessions"`
+	AuditEvents []AuditEventResponse      `json:"audit_events"`
+}
+
+// ExportMe Export everything stored about the current user
+// @Summary Export my data
+// @Description Download everything stored about the current user: profile, metadata, sessions and audit events. With format=zip the sections come as user.json, sessions.json and audit_events.json in a ZIP archive.
+// @Tags users
+// @Produce json
+// @Produce application/zip
+// @Security BearerAuth
+// @Param format query string false "Archive format (default json)" Enums(json, zip)
+// @Success 200 {object} UserExportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/me/export [get]
+func (h *UserHandler) ExportMe(w http.ResponseWriter, r *http.Request) {
+	identity, ok := services.IdentityFromContext(r.Context())
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+	h.export(w, r, identity.UserID)
+}
+
+// ExportUser Export everything stored about a user
+// @Summary Export user data
+// @Description Download everything stored about a user, including soft-deleted ones, for a data subject access request (admin only). With format=zip the sections come as user.json, sessions.json and audit_events.json in a ZIP archive.
+// @Tags admin
+// @Produce json
+// @Produce application/zip
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param format query string false "Archive format (default json)" Enums(json, zip)
+// @Success 200 {object} UserExportResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/export [get]
+func (h *UserHandler) ExportUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	h.export(w, r, id)
+}
+
+// EraseUser Erase the personal data of a user
+// @Summary Erase user
+// @Description Anonymise a user and delete their personal data everywhere, signing them out of all sessions. This cannot be undone. The audit log is kept and records the erasure (admin only).
+// @Tags admin
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "No user with this ID, or already erased"
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/erase [post]
+func (h *UserHandler) EraseUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.service.EraseUser(r.Context(), id); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UserHandler) export(w http.ResponseWriter, r *http.Request, id int) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		sendError(w, http.StatusBadRequest, "Invalid format, expected json or zip")
+		return
+	}
+
+	export, err := h.service.ExportUser(r.Context(), id)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+	response := toUserExportResponse(export)
+
+	if format != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, id))
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.zip"`, id))
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", response.UserExportAccountResponse},
+		{"sessions.json", response.Sessions},
+		{"audit_events.json", response.AuditEvents},
+	}
+	for _, file := range files {
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return
+		}
+	}
+	archive.Close()
+}
+
+func toUserExportResponse(export *domain.UserExport) UserExportResponse {
+	response := UserExportResponse{
+		UserExportAccountResponse: UserExportAccountResponse{
+			ExportedAt:   export.ExportedAt,
+			User:         toUserResponse(&export.User),
+			MFAEnabledAt: export.MFAEnabledAt,
+		},
+		Sessions:    make([]ExportedSessionResponse, 0, len(export.Sessions)),
+		AuditEvents: make([]AuditEventResponse, 0, len(export.AuditEvents)),
+	}
+	for _, session := range export.Sessions {
+		response.Sessions = append(response.Sessions, ExportedSessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			RevokedAt:  session.RevokedAt,
+		})
+	}
+	for i := range export.AuditEvents {
+		response.AuditEvents = append(response.AuditEvents, toAuditEventResponse(&export.AuditEvents[i]))
+	}
+	return response
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"user-srv/domain"
+)
+
+func parseUserQuery(r *http.Request) (domain.UserQuery, error) {
+	params := r.URL.Query()
+	query := domain.UserQuery{
+		PageToken:   params.Get("page_token"),
+		EmailPrefix: params.Get("email_prefix"),
+		NamePrefix:  params.Get("name_prefix"),
+		SortBy:      params.Get("sort_by"),
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil {
+			return query, errors.New("Invalid limit")
+		}
+		query.Limit = value
+	}
+
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, errors.New("Invalid order, expected asc or desc")
+	}
+
+	if key := params.Get("metadata_key"); key != "" {
+		query.Metadata = &domain.MetadataFilter{Key: key}
+		if params.Has("metadata_value") {
+			query.Metadata.Value = metadataValueParam(params.Get("metadata_value"))
+		}
+	} else if params.Has("metadata_value") {
+		return query, errors.New("metadata_value requires metadata_key")
+	}
+
+	var err error
+	if query.CreatedAfter, err = parseTimeParam(params.Get("created_after")); err != nil {
+		return query, errors.New("Invalid created_after, expected RFC 3339 time")
+	}
+	if query.CreatedBefore, err = parseTimeParam(params.Get("created_before")); err != nil {
+		return query, errors.New("Invalid created_before, expected RFC 3339 time")
+	}
+
+	return query, nil
+}
+
+// metadataValueParam reads a metadata value given as JSON, falling back to
+// a plain string, so that both plan=pro and count=3 work as expected.
+func metadataValueParam(value string) json.RawMessage {
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	quoted, _ := json.Marshal(value)
+	return quoted
+}
+
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"user-srv/domain"
+)
+
+type UserSearchHitResponse struct {
+	User       UserResponse      `json:"user"`
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type UserSearchResponse struct {
+	Hits          []UserSearchHitResponse `json:"hits"`
+	NextPageToken string                  `json:"next_page_token,omitempty"`
+	TotalCount    int                     `json:"total_count"`
+}
+
+// Search Find users by name or email
+// @Summary Search users
+// @Description Full-text and fuzzy search over name, email and profile names, best matches first (admin only). While searched fields are encrypted, only words starting with the terms match, in id order. Highlights hold HTML-escaped field values with matches wrapped in <em>.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Words or fragments to search for"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param page_token query string false "Token from next_page_token of the previous page"
+// @Success 200 {object} UserSearchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/search [get]
+func (h *UserHandler) Search(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	search := domain.UserSearch{
+		Query:     params.Get("q"),
+		PageToken: params.Get("page_token"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		search.Limit = value
+	}
+
+	page, err := h.service.SearchUsers(r.Context(), search)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	response := UserSearchResponse{
+		Hits:          make([]UserSearchHitResponse, 0, len(page.Hits)),
+		NextPageToken: page.NextPageToken,
+		TotalCount:    page.TotalCount,
+	}
+	for _, hit := range page.Hits {
+		response.Hits = append(response.Hits, UserSearchHitResponse{
+			User:       toUserResponse(&hit.User),
+			Rank:       hit.Rank,
+			Highlights: hit.Highlights,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
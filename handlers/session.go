@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"user-srv/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+type SessionListResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// Sessions List active sessions of the current user
+// @Summary List sessions
+// @Description List the devices and clients the current user is signed in with, most recently used first
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} SessionListResponse
+// @Failure 401 {object} ErrorResponse "Unauthorized access"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /users/me/sessions [get]
+func (h *UserHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	identity, ok := services.IdentityFromContext(r.Context())
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	sessions, err := h.service.ListSessions(r.Context(), identity.UserID)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	response := SessionListResponse{Sessions: make([]SessionResponse, 0, len(sessions))}
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == identity.SessionID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeSession Sign out of one session
+// @Summary Revoke session
+// @Description Sign the current user out of a session; its tokens stop working immediately
+// @Tags auth
+// @Security BearerAuth
+// @Param id path string true "Session ID"
+// @Success 204 "No Content"
+// @Failure 401 {object} ErrorResponse "Unauthorized access"
+// @Failure 404 {object} ErrorResponse "Session not found"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /users/me/sessions/{id} [delete]
+func (h *UserHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	identity, ok := services.IdentityFromContext(r.Context())
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	if err := h.service.RevokeSession(r.Context(), identity.UserID, chi.URLParam(r, "id")); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type SetStatusRequest struct {
+	Status string `json:"status" enums:"pending,active,suspended,disabled"`
+	Reason string `json:"reason"`
+}
+
+// SetStatus Change the account status of a user
+// @Summary Set user status
+// @Description Activate, suspend or disable a user's account (admin only). Suspended and disabled users are signed out immediately.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param request body SetStatusRequest true "New status and the reason for the change"
+// @Success 200 {object} UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse "Unknown status or transition not allowed"
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/status [put]
+func (h *UserHandler) SetStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req SetStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, err := h.service.SetStatus(r.Context(), id, req.Status, req.Reason)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toUserResponse(user))
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import "net/http"
+
+// VerifyEmail Confirm an email address
+// @Summary Verify email
+// @Description Mark the user's email address as verified using the token sent to it
+// @Tags auth
+// @Param token query string true "Verification token"
+// @Success 204 "No Content"
+// @Failure 422 {object} ErrorResponse "Invalid or expired token"
+// @Failure 500 {object} ErrorResponse "Internal error"
+// @Router /verify-email [get]
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.VerifyEmail(r.Context(), r.URL.Query().Get("token")); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"user-srv/domain"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type WebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types" example:"user.created,user.deleted"`
+	// Generated on creation when empty; kept on update when empty.
+	Secret string `json:"secret"`
+	// Defaults to true.
+	Active *bool `json:"active"`
+}
+
+type WebhookResponse struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// Only returned when the secret is set.
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookListResponse struct {
+	Webhooks []WebhookResponse `json:"webhooks"`
+}
+
+type WebhookAttemptResponse struct {
+	StatusCode  *int      `json:"status_code"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID             int64                    `json:"id"`
+	WebhookID      int                      `json:"webhook_id"`
+	MessageID      int64                    `json:"message_id"`
+	EventType      string                   `json:"event_type"`
+	Status         string                   `json:"status" enums:"pending,succeeded,failed"`
+	Attempts       int                      `json:"attempts"`
+	LastStatusCode *int                     `json:"last_status_code"`
+	LastError      string                   `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time                `json:"next_attempt_at"`
+	CreatedAt      time.Time                `json:"created_at"`
+	DeliveredAt    *time.Time               `json:"delivered_at,omitempty"`
+	Log            []WebhookAttemptResponse `json:"log"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Deliveries    []WebhookDeliveryResponse `json:"deliveries"`
+	NextPageToken string                    `json:"next_page_token,omitempty"`
+}
+
+// CreateWebhook Subscribe a URL to user changes
+// @Summary Create webhook
+// @Description Subscribe a URL to user changes (admin only). Deliveries are POSTed as JSON and signed in X-Webhook-Signature with the hex HMAC-SHA256 of "<X-Webhook-Timestamp>.<body>". The secret is only returned here.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body WebhookRequest true "URL, event types (empty for all) and optional secret"
+// @Success 201 {object} WebhookResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks [post]
+func (h *UserHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	webhook, err := h.service.CreateWebhook(r.Context(), req.webhook(0))
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toWebhookResponse(webhook))
+}
+
+// Webhooks List webhooks
+// @Summary List webhooks
+// @Description List all webhook subscriptions (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} WebhookListResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks [get]
+func (h *UserHandler) Webhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.service.ListWebhooks(r.Context())
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	response := WebhookListResponse{Webhooks: make([]WebhookResponse, 0, len(webhooks))}
+	for i := range webhooks {
+		response.Webhooks = append(response.Webhooks, toWebhookResponse(&webhooks[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Webhook Get a webhook
+// @Summary Get webhook
+// @Description Get a webhook subscription by ID (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} WebhookResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [get]
+func (h *UserHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	webhook, err := h.service.GetWebhook(r.Context(), id)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toWebhookResponse(webhook))
+}
+
+// UpdateWebhook Change a webhook
+// @Summary Update webhook
+// @Description Replace the URL, event types and active flag of a webhook (admin only). A non-empty secret rotates the secret and is returned once.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param request body WebhookRequest true "New webhook settings"
+// @Success 200 {object} WebhookResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [put]
+func (h *UserHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	webhook, err := h.service.UpdateWebhook(r.Context(), req.webhook(id))
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toWebhookResponse(webhook))
+}
+
+// DeleteWebhook Remove a webhook
+// @Summary Delete webhook
+// @Description Delete a webhook subscription together with its deliveries (admin only)
+// @Tags admin
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [delete]
+func (h *UserHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	if err := h.service.DeleteWebhook(r.Context(), id); err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveries List deliveries of a webhook
+// @Summary List webhook deliveries
+// @Description List deliveries of a webhook with their attempt log, newest first (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param status query string false "Only deliveries with this status" Enums(pending, succeeded, failed)
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param page_token query string false "Token from next_page_token of the previous page"
+// @Success 200 {object} WebhookDeliveryListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id}/deliveries [get]
+func (h *UserHandler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	params := r.URL.Query()
+	query := domain.WebhookDeliveryQuery{
+		WebhookID: id,
+		Status:    params.Get("status"),
+		PageToken: params.Get("page_token"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		query.Limit = value
+	}
+
+	page, err := h.service.ListWebhookDeliveries(r.Context(), query)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	response := WebhookDeliveryListResponse{
+		Deliveries:    make([]WebhookDeliveryResponse, 0, len(page.Deliveries)),
+		NextPageToken: page.NextPageToken,
+	}
+	for i := range page.Deliveries {
+		response.Deliveries = append(response.Deliveries, toWebhookDeliveryResponse(&page.Deliveries[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ReplayWebhookDelivery Send a failed delivery again
+// @Summary Replay webhook delivery
+// @Description Schedule a failed delivery to be sent again right away, with a fresh set of attempts (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Delivery ID"
+// @Success 200 {object} WebhookDeliveryResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse "No failed delivery with this ID"
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/deliveries/{id}/replay [post]
+func (h *UserHandler) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	delivery, err := h.service.ReplayWebhookDelivery(r.Context(), id)
+	if err != nil {
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toWebhookDeliveryResponse(delivery))
+}
+
+func (req *WebhookRequest) webhook(id int) *domain.Webhook {
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+	return &domain.Webhook{
+		ID:         id,
+		URL:        req.URL,
+		EventTypes: req.EventTypes,
+		Secret:     req.Secret,
+		Active:     active,
+	}
+}
+
+func toWebhookResponse(webhook *domain.Webhook) WebhookResponse {
+	eventTypes := webhook.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	return WebhookResponse{
+		ID:         webhook.ID,
+		URL:        webhook.URL,
+		EventTypes: eventTypes,
+		Active:     webhook.Active,
+		CreatedAt:  webhook.CreatedAt,
+		UpdatedAt:  webhook.UpdatedAt,
+		Secret:     webhook.Secret,
+	}
+}
+
+func toWebhookDeliveryResponse(delivery *domain.WebhookDelivery) WebhookDeliveryResponse {
+	response := WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		WebhookID:      delivery.WebhookID,
+		MessageID:      delivery.MessageID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		CreatedAt:      delivery.CreatedAt,
+		DeliveredAt:    delivery.DeliveredAt,
+		Log:            make([]WebhookAttemptResponse, 0, len(delivery.Log)),
+	}
+	for _, attempt := range delivery.Log {
+		response.Log = append(response.Log, WebhookAttemptResponse{
+			StatusCode:  attempt.StatusCode,
+			Error:       attempt.Error,
+			DurationMS:  attempt.DurationMS,
+			AttemptedAt: attempt.AttemptedAt,
+		})
+	}
+	return response
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
 	"log"
 	"net/http"
+	"os"
+	"user-srv/config"
 	"user-srv/repositories"
 	"user-srv/routes"
 	"user-srv/server"
@@ -15,9 +18,84 @@ func main() {
 	db := services.InitDB()
 	defer db.Close()
 
+	cfg := config.LoadConfig()
+	fieldEncryption, err := repositories.LoadFieldEncryption(cfg.PIIKeyFile, cfg.PIIEncryptedColumns)
+	if err != nil {
+		log.Fatalf("Failed to load field encryption keys: %v", err)
+	}
+
 	sqlxDB := sqlx.NewDb(db, "postgres")
-	userRepo := repositories.NewUserRepository(sqlxDB)
-	userService := services.NewUserService(userRepo)
+	userRepo := repositories.NewUserRepository(sqlxDB, fieldEncryption)
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		reencryptUsers(userRepo)
+		return
+	}
+	// Rows written in plain text or with older keys have no blind index or
+	// tokens matching the configuration, so they could neither be found nor
+	// kept unique. They are brought up to date before serving.
+	if fieldEncryption != nil {
+		reencryptUsers(userRepo)
+	}
+	if err := userRepo.SyncIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to sync user indexes: %v", err)
+	}
+	if err := services.SeedUsers(context.Background(), userRepo); err != nil {
+		log.Fatalf("Seeding failed: %v", err)
+	}
+	refreshTokenRepo := repositories.NewRefreshTokenRepository(sqlxDB)
+	passwordResetRepo := repositories.NewPasswordResetRepository(sqlxDB, fieldEncryption)
+	emailVerificationRepo := repositories.NewEmailVerificationRepository(sqlxDB, fieldEncryption)
+	mfaRepo := repositories.NewMFARepository(sqlxDB)
+	loginThrottleRepo := repositories.NewLoginThrottleRepository(sqlxDB)
+	tokenRevocationRepo := repositories.NewTokenRevocationRepository(sqlxDB)
+	sessionRepo := repositories.NewSessionRepository(sqlxDB)
+	userEventRepo := repositories.NewUserEventRepository(sqlxDB, fieldEncryption)
+	outboxRepo := repositories.NewOutboxRepository(sqlxDB, fieldEncryption)
+	webhookRepo := repositories.NewWebhookRepository(sqlxDB, fieldEncryption)
+	auditRepo := repositories.NewAuditRepository(sqlxDB)
+
+	notifier, err := services.NewLogNotifier(cfg.NotifierFile)
+	if err != nil {
+		log.Fatalf("Failed to initialize notifier: %v", err)
+	}
+	keys, err := services.LoadKeySet(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	auditKey, err := services.LoadAuditKey(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load audit key: %v", err)
+	}
+	userEventListener := services.NewUserEventListener(cfg)
+	go userEventListener.Run(context.Background())
+	outboxSink, err := services.NewOutboxSink(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize outbox sink: %v", err)
+	}
+	outboxSink = services.NewMultiSink(outboxSink, services.NewWebhookSubscriptionSink(webhookRepo))
+	go services.NewOutboxRelay(outboxRepo, outboxSink).Run(context.Background())
+	go services.NewWebhookDeliverer(webhookRepo).Run(context.Background())
+
+	userService := services.NewUserService(
+		userRepo,
+		refreshTokenRepo,
+		passwordResetRepo,
+		emailVerificationRepo,
+		mfaRepo,
+		loginThrottleRepo,
+		tokenRevocationRepo,
+		sessionRepo,
+		userEventRepo,
+		userEventListener,
+		webhookRepo,
+		auditRepo,
+		fieldEncryption,
+		notifier,
+		keys,
+		auditKey,
+	)
+
+	go services.NewUserPurger(userRepo, userEventRepo, outboxRepo, webhookRepo).Run(context.Background())
 
 	go func() {
 		router := routes.SetRoutes(userService)
@@ -27,6 +105,17 @@ func main() {
 	server.StartGRPCServer(userService, ":50051")
 }
 
+// reencryptUsers encrypts the personal data of all users with the primary key
+// and the configured columns, for key rotation or after changing
+// PII_ENCRYPTED_COLUMNS.
+func reencryptUsers(userRepo repositories.UserRepository) {
+	rewritten, err := userRepo.Reencrypt(context.Background(), 500)
+	if err != nil {
+		log.Fatalf("Reencryption failed after %d users: %v", rewritten, err)
+	}
+	log.Printf("Reencrypted %d users", rewritten)
+}
+
 func startHttpServer(router *chi.Mux) {
 	log.Println("Starting server on :8080")
 	if err := http.ListenAndServe(":8080", router); err != nil {
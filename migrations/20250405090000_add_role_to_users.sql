@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(32) NOT NULL DEFAULT 'user'
+        CHECK (role IN ('admin', 'user'));
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN role;
@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE INDEX idx_users_name_pattern ON users (name text_pattern_ops);
+CREATE INDEX idx_users_email_pattern ON users (email text_pattern_ops);
+CREATE INDEX idx_users_name_id ON users (name, id);
+CREATE INDEX idx_users_created_at_id ON users (created_at, id);
+
+-- +goose Down
+DROP INDEX idx_users_created_at_id;
+DROP INDEX idx_users_name_id;
+DROP INDEX idx_users_email_pattern;
+DROP INDEX idx_users_name_pattern;
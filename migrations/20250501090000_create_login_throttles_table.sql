@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE login_throttles
+(
+    scope          VARCHAR(16)  NOT NULL,
+    key            VARCHAR(255) NOT NULL,
+    failures       INTEGER      NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP    NOT NULL,
+    locked_until   TIMESTAMP,
+    PRIMARY KEY (scope, key)
+);
+
+-- +goose Down
+DROP TABLE login_throttles;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE revoked_tokens
+(
+    jti        VARCHAR(64) PRIMARY KEY,
+    user_id    INTEGER   NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+-- Tokens issued to the user before revoked_before are rejected. There is no
+-- foreign key on purpose: the cutoff has to outlive a deleted user.
+CREATE TABLE user_token_revocations
+(
+    user_id        INTEGER PRIMARY KEY,
+    revoked_before TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE user_token_revocations;
+DROP TABLE revoked_tokens;
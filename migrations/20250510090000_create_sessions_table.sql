@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE sessions
+(
+    id           VARCHAR(64) PRIMARY KEY,
+    user_id      INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   TEXT        NOT NULL DEFAULT '',
+    ip           VARCHAR(45) NOT NULL DEFAULT '',
+    created_at   TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at   TIMESTAMP
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+ALTER TABLE refresh_tokens
+    ADD COLUMN session_id VARCHAR(64) REFERENCES sessions (id) ON DELETE CASCADE;
+
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN session_id;
+DROP TABLE sessions;
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN status            VARCHAR(16) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('pending', 'active', 'suspended', 'disabled')),
+    ADD COLUMN status_reason     TEXT        NOT NULL DEFAULT '',
+    ADD COLUMN status_changed_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN status_changed_at,
+    DROP COLUMN status_reason,
+    DROP COLUMN status;
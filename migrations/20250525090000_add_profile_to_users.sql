@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN first_name    VARCHAR(100)  NOT NULL DEFAULT '',
+    ADD COLUMN last_name     VARCHAR(100)  NOT NULL DEFAULT '',
+    ADD COLUMN display_name  VARCHAR(100)  NOT NULL DEFAULT '',
+    ADD COLUMN phone_number  VARCHAR(16)   NOT NULL DEFAULT '',
+    ADD COLUMN locale        VARCHAR(35)   NOT NULL DEFAULT '',
+    ADD COLUMN timezone      VARCHAR(64)   NOT NULL DEFAULT '',
+    ADD COLUMN avatar_url    VARCHAR(2048) NOT NULL DEFAULT '',
+    ADD COLUMN date_of_birth DATE;
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN date_of_birth,
+    DROP COLUMN avatar_url,
+    DROP COLUMN timezone,
+    DROP COLUMN locale,
+    DROP COLUMN phone_number,
+    DROP COLUMN display_name,
+    DROP COLUMN last_name,
+    DROP COLUMN first_name;
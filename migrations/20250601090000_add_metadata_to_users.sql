@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}'
+        CONSTRAINT users_metadata_size CHECK (octet_length(metadata::text) <= 65536);
+
+CREATE INDEX idx_users_metadata ON users USING GIN (metadata);
+
+-- +goose Down
+DROP INDEX idx_users_metadata;
+ALTER TABLE users DROP COLUMN metadata;
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE users
+    ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+        to_tsvector('simple'::regconfig,
+                    name || ' ' || email || ' ' || first_name || ' ' || last_name || ' ' || display_name)
+        ) STORED;
+
+CREATE INDEX idx_users_search_vector ON users USING GIN (search_vector);
+CREATE INDEX idx_users_name_trgm ON users USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
+CREATE INDEX idx_users_display_name_trgm ON users USING GIN (display_name gin_trgm_ops);
+
+-- +goose Down
+DROP INDEX idx_users_display_name_trgm;
+DROP INDEX idx_users_email_trgm;
+DROP INDEX idx_users_name_trgm;
+DROP INDEX idx_users_search_vector;
+ALTER TABLE users DROP COLUMN search_vector;
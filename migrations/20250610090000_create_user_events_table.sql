@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE user_events
+(
+    revision    BIGSERIAL PRIMARY KEY,
+    type        VARCHAR(16) NOT NULL,
+    user_id     INTEGER     NOT NULL,
+    -- The users row right after the change, without password and search_vector.
+    snapshot    JSONB       NOT NULL,
+    occurred_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_events_occurred_at ON user_events (occurred_at);
+
+-- +goose Down
+DROP TABLE user_events;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE outbox
+(
+    id              BIGSERIAL PRIMARY KEY,
+    type            VARCHAR(32) NOT NULL,
+    user_id         INTEGER     NOT NULL,
+    payload         JSONB       NOT NULL,
+    status          VARCHAR(16) NOT NULL DEFAULT 'pending'
+        CONSTRAINT outbox_status CHECK (status IN ('pending', 'published', 'dead')),
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until    TIMESTAMP,
+    created_at      TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at    TIMESTAMP
+);
+
+CREATE INDEX idx_outbox_pending ON outbox (user_id, id) WHERE status = 'pending';
+CREATE INDEX idx_outbox_published_at ON outbox (published_at) WHERE status = 'published';
+
+-- +goose Down
+DROP TABLE outbox;
@@ -0,0 +1,52 @@
+-- +goose Up
+CREATE TABLE webhooks
+(
+    id          SERIAL PRIMARY KEY,
+    url         TEXT      NOT NULL,
+    event_types TEXT[]    NOT NULL DEFAULT '{}',
+    -- AES-GCM encrypted with WEBHOOK_ENCRYPTION_KEY.
+    secret      TEXT      NOT NULL,
+    active      BOOLEAN   NOT NULL DEFAULT TRUE,
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE webhook_deliveries
+(
+    id               BIGSERIAL PRIMARY KEY,
+    webhook_id       INTEGER     NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    message_id       BIGINT      NOT NULL,
+    event_type       VARCHAR(32) NOT NULL,
+    payload          JSONB       NOT NULL,
+    status           VARCHAR(16) NOT NULL DEFAULT 'pending'
+        CONSTRAINT webhook_deliveries_status CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts         INTEGER     NOT NULL DEFAULT 0,
+    last_status_code INTEGER,
+    last_error       TEXT        NOT NULL DEFAULT '',
+    next_attempt_at  TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until     TIMESTAMP,
+    created_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at     TIMESTAMP,
+    -- Outbox messages are relayed at least once; deliver them once per webhook.
+    UNIQUE (webhook_id, message_id)
+);
+
+CREATE INDEX idx_webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, id);
+
+CREATE TABLE webhook_delivery_attempts
+(
+    id           BIGSERIAL PRIMARY KEY,
+    delivery_id  BIGINT    NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    status_code  INTEGER,
+    error        TEXT      NOT NULL DEFAULT '',
+    duration_ms  BIGINT    NOT NULL,
+    attempted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts (delivery_id);
+
+-- +goose Down
+DROP TABLE webhook_delivery_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
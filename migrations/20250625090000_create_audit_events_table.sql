@@ -0,0 +1,45 @@
+-- +goose Up
+-- Events are chained per partition of users, so that appends for different
+-- users do not wait for each other.
+CREATE TABLE audit_events
+(
+    id             BIGSERIAL PRIMARY KEY,
+    chain          SMALLINT     NOT NULL,
+    occurred_at    TIMESTAMP    NOT NULL,
+    actor_id       INTEGER,
+    target_user_id INTEGER,
+    action         VARCHAR(32)  NOT NULL,
+    outcome        VARCHAR(16)  NOT NULL,
+    reason         TEXT         NOT NULL DEFAULT '',
+    changes        JSONB        NOT NULL DEFAULT '{}',
+    ip             VARCHAR(45)  NOT NULL DEFAULT '',
+    request_id     VARCHAR(128) NOT NULL DEFAULT '',
+    prev_hash      VARCHAR(64)  NOT NULL,
+    hash           VARCHAR(64)  NOT NULL UNIQUE
+);
+
+CREATE INDEX idx_audit_events_chain ON audit_events (chain, id);
+CREATE INDEX idx_audit_events_actor_id ON audit_events (actor_id, id);
+CREATE INDEX idx_audit_events_target_user_id ON audit_events (target_user_id, id);
+CREATE INDEX idx_audit_events_occurred_at ON audit_events (occurred_at);
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only();
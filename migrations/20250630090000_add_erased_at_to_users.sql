@@ -0,0 +1,9 @@
+-- +goose Up
+-- Set when the personal data of the user was erased. The row is kept,
+-- anonymised, so that ids in other tables and the audit log stay valid.
+ALTER TABLE users
+    ADD COLUMN erased_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN erased_at;
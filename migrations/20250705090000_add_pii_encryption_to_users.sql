@@ -0,0 +1,83 @@
+-- +goose Up
+-- Encrypted values are longer than the plain ones and lengths are checked by
+-- the service instead. search_vector depends on the columns, so it is built
+-- again afterwards.
+DROP INDEX idx_users_search_vector;
+ALTER TABLE users DROP COLUMN search_vector;
+
+ALTER TABLE users
+    ALTER COLUMN name TYPE TEXT,
+    ALTER COLUMN email TYPE TEXT,
+    ALTER COLUMN first_name TYPE TEXT,
+    ALTER COLUMN last_name TYPE TEXT,
+    ALTER COLUMN display_name TYPE TEXT,
+    ALTER COLUMN phone_number TYPE TEXT;
+
+-- key_id names the key-encryption key that wrapped data_key, the row's own
+-- key for encrypted_columns. Rows without key_id are stored in plain text.
+-- email_index is a keyed hash of the plain email, so that encrypted emails
+-- can be looked up and stay unique.
+ALTER TABLE users
+    ADD COLUMN key_id            TEXT,
+    ADD COLUMN data_key          TEXT,
+    ADD COLUMN encrypted_columns TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN email_index       TEXT;
+
+CREATE UNIQUE INDEX idx_users_email_index ON users (email_index);
+
+-- search_tokens holds keyed hashes of the prefixes of encrypted names and
+-- emails and of the words searched, so that encrypted users can still be
+-- filtered and searched. The indexes on the plain text of encryptable columns
+-- are dropped and created again at startup depending on PII_ENCRYPTED_COLUMNS.
+ALTER TABLE users ADD COLUMN search_tokens TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_users_search_tokens ON users USING GIN (search_tokens);
+
+-- Encrypted columns hold ciphertext, which is not worth indexing, so rows
+-- with any searched column encrypted get no search_vector.
+ALTER TABLE users
+    ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+        CASE
+            WHEN NOT encrypted_columns && ARRAY ['name', 'email', 'first_name', 'last_name', 'display_name']
+                THEN to_tsvector('simple'::regconfig,
+                                 name || ' ' || email || ' ' || first_name || ' ' || last_name || ' ' || display_name)
+            END
+        ) STORED;
+CREATE INDEX idx_users_search_vector ON users USING GIN (search_vector);
+
+-- +goose Down
+-- Only possible once no row is encrypted any more.
+DROP INDEX IF EXISTS idx_users_search_vector;
+ALTER TABLE users DROP COLUMN search_vector;
+
+DROP INDEX idx_users_search_tokens;
+DROP INDEX idx_users_email_index;
+
+ALTER TABLE users
+    DROP COLUMN search_tokens,
+    DROP COLUMN email_index,
+    DROP COLUMN encrypted_columns,
+    DROP COLUMN data_key,
+    DROP COLUMN key_id;
+
+ALTER TABLE users
+    ALTER COLUMN name TYPE VARCHAR(255),
+    ALTER COLUMN email TYPE VARCHAR(255),
+    ALTER COLUMN first_name TYPE VARCHAR(100),
+    ALTER COLUMN last_name TYPE VARCHAR(100),
+    ALTER COLUMN display_name TYPE VARCHAR(100),
+    ALTER COLUMN phone_number TYPE VARCHAR(16);
+
+ALTER TABLE users
+    ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+        to_tsvector('simple'::regconfig,
+                    name || ' ' || email || ' ' || first_name || ' ' || last_name || ' ' || display_name)
+        ) STORED;
+CREATE INDEX idx_users_search_vector ON users USING GIN (search_vector);
+
+CREATE INDEX IF NOT EXISTS idx_users_name_pattern ON users (name text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_pattern ON users (email text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_users_name_id ON users (name, id);
+CREATE INDEX IF NOT EXISTS idx_users_name_trgm ON users USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_display_name_trgm ON users USING GIN (display_name gin_trgm_ops);
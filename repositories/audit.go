@@ -0,0 +1,206 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"strconv"
+	"user-srv/domain"
+)
+
+// auditLock is the advisory lock key serializing the writers of a chain of
+// the audit log, so that every event is chained to the one committed before
+// it. Chains are locked with pg_advisory_xact_lock(auditLock, chain).
+const auditLock = 0x61756474 // "audt"
+
+// auditChains is the number of hash chains of the audit log. Events are
+// spread over them by user, so that appends for different users rarely
+// wait for each other.
+const auditChains = 16
+
+// UserAudit builds the audit event of a change to a user from the user as
+// written. Writes that take one append the event in their transaction, so
+// that the change fails if it cannot be recorded.
+type UserAudit func(user *domain.User) *domain.AuditEvent
+
+type AuditRepository interface {
+	Append(ctx context.Context, event *domain.AuditEvent) error
+	List(ctx context.Context, query domain.AuditQuery) ([]domain.AuditEvent, error)
+	ListByUser(ctx context.Context, userID int) ([]domain.AuditEvent, error)
+	Walk(ctx context.Context, fn func(*domain.AuditEvent) error) error
+}
+
+type auditRepository struct {
+	db *sqlx.DB
+}
+
+func NewAuditRepository(db *sqlx.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+const auditColumns = "id, chain, occurred_at, actor_id, target_user_id, action, outcome, reason, changes, ip, request_id, prev_hash, hash"
+
+// auditWalkBatchSize is the number of events Walk reads at a time.
+const auditWalkBatchSize = 500
+
+// Append stores the event in a transaction of its own, see appendAudit.
+func (r *auditRepository) Append(ctx context.Context, event *domain.AuditEvent) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	if err := appendAudit(ctx, tx, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
+	}
+	return nil
+}
+
+// appendAudit chains the event to the latest one of its chain, sets its
+// Chain, PrevHash, Hash and ID and stores it as part of tx. The chain stays
+// locked until tx ends, so callers append as late as they can.
+func appendAudit(ctx context.Context, tx *sqlx.Tx, event *domain.AuditEvent) error {
+	event.Chain = auditChain(event)
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, auditLock, event.Chain); err != nil {
+		return domain.Internal("failed to lock audit log", err)
+	}
+	query := `SELECT hash FROM audit_events WHERE chain = $1 ORDER BY id DESC LIMIT 1`
+	err := tx.GetContext(ctx, &event.PrevHash, query, event.Chain)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return domain.Internal("failed to read audit log", err)
+	}
+	if event.Hash, err = event.ComputeHash(); err != nil {
+		return domain.Internal("failed to hash audit event", err)
+	}
+
+	query = `
+		INSERT INTO audit_events (chain, occurred_at, actor_id, target_user_id, action, outcome, reason, changes, ip, request_id, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`
+	err = tx.QueryRowxContext(ctx, query, event.Chain, event.OccurredAt, event.ActorID, event.TargetUserID, event.Action,
+		event.Outcome, event.Reason, event.Changes, event.IP, event.RequestID, event.PrevHash, event.Hash).
+		Scan(&event.ID)
+	if err != nil {
+		return domain.Internal("failed to append audit event", err)
+	}
+	return nil
+}
+
+// appendUserAudit appends the event audit builds for the user with userID
+// as part of tx. Soft-deleted users are read too. A nil audit records
+// nothing.
+func appendUserAudit(ctx context.Context, tx *sqlx.Tx, enc *FieldEncryption, audit UserAudit, userID int) error {
+	if audit == nil {
+		return nil
+	}
+	row := &userRow{}
+	if err := tx.GetContext(ctx, row, `SELECT `+userColumns+` FROM users WHERE id = $1`, userID); err != nil {
+		return domain.Internal("failed to get user by id", err)
+	}
+	user, err := enc.openUser(row)
+	if err != nil {
+		return err
+	}
+	return appendAudit(ctx, tx, audit(user))
+}
+
+// auditChain returns the chain of the event: the one of its target user,
+// else of its actor, else the first.
+func auditChain(event *domain.AuditEvent) int {
+	switch {
+	case event.TargetUserID != nil:
+		return *event.TargetUserID % auditChains
+	case event.ActorID != nil:
+		return *event.ActorID % auditChains
+	default:
+		return 0
+	}
+}
+
+// List returns a page of audit events matching the query, newest first.
+func (r *auditRepository) List(ctx context.Context, query domain.AuditQuery) ([]domain.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, condition+" $"+strconv.Itoa(len(args)))
+	}
+	if query.ActorID != nil {
+		add("actor_id =", *query.ActorID)
+	}
+	if query.TargetUserID != nil {
+		add("target_user_id =", *query.TargetUserID)
+	}
+	if query.Action != "" {
+		add("action =", query.Action)
+	}
+	if query.Since != nil {
+		add("occurred_at >=", query.Since.UTC())
+	}
+	if query.Until != nil {
+		add("occurred_at <", query.Until.UTC())
+	}
+	if query.Before > 0 {
+		add("id <", query.Before)
+	}
+	args = append(args, query.Limit)
+	listQuery := `
+		SELECT ` + auditColumns + `
+		FROM audit_events` + whereClause(conditions) + `
+		ORDER BY id DESC
+		LIMIT $` + strconv.Itoa(len(args))
+
+	events := []domain.AuditEvent{}
+	if err := r.db.SelectContext(ctx, &events, listQuery, args...); err != nil {
+		return nil, domain.Internal("failed to list audit events", err)
+	}
+	return events, nil
+}
+
+// ListByUser returns every event the user performed or was the target of,
+// oldest first.
+func (r *auditRepository) ListByUser(ctx context.Context, userID int) ([]domain.AuditEvent, error) {
+	query := `
+		SELECT ` + auditColumns + `
+		FROM audit_events
+		WHERE actor_id = $1 OR target_user_id = $1
+		ORDER BY id`
+	events := []domain.AuditEvent{}
+	if err := r.db.SelectContext(ctx, &events, query, userID); err != nil {
+		return nil, domain.Internal("failed to list audit events", err)
+	}
+	return events, nil
+}
+
+// Walk calls fn for every audit event, oldest first, stopping at the first
+// error.
+func (r *auditRepository) Walk(ctx context.Context, fn func(*domain.AuditEvent) error) error {
+	var after int64
+	for {
+		var events []domain.AuditEvent
+		query := `
+			SELECT ` + auditColumns + `
+			FROM audit_events
+			WHERE id > $1
+			ORDER BY id
+			LIMIT $2`
+		if err := r.db.SelectContext(ctx, &events, query, after, auditWalkBatchSize); err != nil {
+			return domain.Internal("failed to read audit log", err)
+		}
+		for i := range events {
+			if err := fn(&events[i]); err != nil {
+				return err
+			}
+		}
+		if len(events) < auditWalkBatchSize {
+			return nil
+		}
+		after = events[len(events)-1].ID
+	}
+}
@@ -0,0 +1,113 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"user-srv/domain"
+)
+
+type EmailVerificationRepository interface {
+	Create(ctx context.Context, token *domain.EmailVerificationToken) error
+	Verify(ctx context.Context, tokenHash string, activation domain.StatusChange, audit, activationAudit UserAudit) (int, bool, error)
+}
+
+type emailVerificationRepository struct {
+	db  *sqlx.DB
+	enc *FieldEncryption
+}
+
+func NewEmailVerificationRepository(db *sqlx.DB, enc *FieldEncryption) EmailVerificationRepository {
+	return &emailVerificationRepository{db: db, enc: enc}
+}
+
+// Create stores the token. With field encryption, the token keeps the blind
+// index of the email instead of the address itself.
+func (r *emailVerificationRepository) Create(ctx context.Context, token *domain.EmailVerificationToken) error {
+	query := `
+		INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+	email := token.Email
+	if index := r.enc.emailIndex(token.Email); index != nil {
+		email = *index
+	}
+	err := r.db.QueryRowxContext(ctx, query, token.UserID, email, token.TokenHash, token.ExpiresAt).
+		Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return domain.Internal("failed to create email verification token", err)
+	}
+	return nil
+}
+
+// Verify consumes an unused, unexpired token and marks the user's email as
+// verified, provided the user still has the address the token was sent to.
+// If the user's status is activation.From, it is changed as SetStatus does,
+// in the same transaction. The verification is audited with audit and the
+// status change with activationAudit. It returns the id of the user and
+// whether the status changed.
+func (r *emailVerificationRepository) Verify(ctx context.Context, tokenHash string, activation domain.StatusChange, audit, activationAudit UserAudit) (int, bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, false, domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	var token domain.EmailVerificationToken
+	query := `
+		UPDATE email_verification_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id, email`
+	if err := tx.GetContext(ctx, &token, query, tokenHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, domain.NotFound("email verification token not found")
+		}
+		return 0, false, domain.Internal("failed to consume email verification token", err)
+	}
+
+	// The token holds the email or, since encryption was enabled, its blind
+	// index; the user the email in plain text or its blind index.
+	query = `
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND (email = $2 OR email_index = $2 OR email_index = $3) AND ` + notDeleted
+	result, err := tx.ExecContext(ctx, query, token.UserID, token.Email, r.enc.emailIndex(token.Email))
+	if err != nil {
+		return 0, false, domain.Internal("failed to verify email", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, false, domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return 0, false, domain.NotFound("email verification token not found")
+	}
+
+	result, err = tx.ExecContext(ctx, setStatusQuery, token.UserID, activation.From, activation.To, activation.Reason)
+	if err != nil {
+		return 0, false, domain.Internal("failed to set user status", err)
+	}
+	if rowsAffected, err = result.RowsAffected(); err != nil {
+		return 0, false, domain.Internal("failed to check rows affected", err)
+	}
+	activated := rowsAffected > 0
+
+	if err := appendUserAudit(ctx, tx, r.enc, audit, token.UserID); err != nil {
+		return 0, false, err
+	}
+	if activated {
+		if err := appendUserAudit(ctx, tx, r.enc, activationAudit, token.UserID); err != nil {
+			return 0, false, err
+		}
+	}
+	if err := recordUserChange(ctx, tx, r.enc, domain.UserEventUpdated, token.UserID); err != nil {
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, domain.Internal("failed to commit transaction", err)
+	}
+	return token.UserID, activated, nil
+}
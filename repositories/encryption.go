@@ -0,0 +1,463 @@
+package repositories
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"user-srv/aesgcm"
+	"user-srv/domain"
+
+	"github.com/lib/pq"
+)
+
+// EncryptableColumns lists the users columns that can be encrypted. Other
+// columns are either not personal data or not text.
+var EncryptableColumns = []string{"name", "email", "first_name", "last_name", "display_name", "phone_number"}
+
+// SearchColumns lists the users columns whose words are searched.
+var SearchColumns = []string{"name", "email", "first_name", "last_name", "display_name"}
+
+// MaxTokenLength is the number of characters up to which blind tokens are
+// kept for prefixes of encrypted names and emails and of searched words.
+const MaxTokenLength = 32
+
+// FieldEncryption encrypts personal data columns of users with envelope
+// encryption: every row gets its own data key, which encrypts the row's
+// values with AES-GCM and is stored wrapped by a key-encryption key (KEK).
+// Rows record the id of the KEK and the columns that are encrypted, so keys
+// can be rotated and columns added while old rows are still readable.
+//
+// Emails get a blind index, an HMAC of the plain address, so that users can
+// still be looked up by email and emails stay unique. Likewise, rows get
+// blind tokens for the prefixes of encrypted names and emails and, while any
+// of SearchColumns is encrypted, of the words in them, so that users can
+// still be filtered by prefix and searched. Tokens reveal which rows share a
+// prefix, though not the prefix itself.
+//
+// A nil *FieldEncryption stores everything in plain text.
+type FieldEncryption struct {
+	keys      map[string][]byte
+	primaryID string
+	indexKey  []byte
+	columns   []string
+}
+
+// keyFile is the JSON file holding the keys. Primary names the KEK new data
+// keys are wrapped with; the others are only used to read older rows.
+type keyFile struct {
+	Primary  string            `json:"primary"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+// LoadFieldEncryption reads the keys from path and encrypts the given
+// comma-separated columns. Without a path, it returns nil and nothing is
+// encrypted.
+func LoadFieldEncryption(path, columns string) (*FieldEncryption, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %v", err)
+	}
+
+	e := &FieldEncryption{keys: map[string][]byte{}, primaryID: file.Primary}
+	for id, encoded := range file.Keys {
+		key, err := aesgcm.DecodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %v", id, err)
+		}
+		e.keys[id] = key
+	}
+	if _, ok := e.keys[e.primaryID]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the key file", e.primaryID)
+	}
+	if e.indexKey, err = aesgcm.DecodeKey(file.IndexKey); err != nil {
+		return nil, fmt.Errorf("invalid index key: %v", err)
+	}
+
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			continue
+		}
+		if !isEncryptableColumn(column) {
+			return nil, fmt.Errorf("column %q cannot be encrypted", column)
+		}
+		e.columns = append(e.columns, column)
+	}
+	sort.Strings(e.columns)
+	return e, nil
+}
+
+// PrimaryKeyID returns the id of the KEK new rows are encrypted with.
+func (e *FieldEncryption) PrimaryKeyID() string {
+	if e == nil {
+		return ""
+	}
+	return e.primaryID
+}
+
+// Columns returns the encrypted columns, sorted.
+func (e *FieldEncryption) Columns() []string {
+	if e == nil {
+		return nil
+	}
+	return e.columns
+}
+
+// Encrypted reports whether column is encrypted.
+func (e *FieldEncryption) Encrypted(column string) bool {
+	for _, c := range e.Columns() {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// BlindSearch reports whether users are searched through blind tokens
+// because some of SearchColumns are encrypted.
+func (e *FieldEncryption) BlindSearch() bool {
+	for _, column := range SearchColumns {
+		if e.Encrypted(column) {
+			return true
+		}
+	}
+	return false
+}
+
+// emailIndex returns the blind index of email, nil without encryption.
+func (e *FieldEncryption) emailIndex(email string) *string {
+	if e == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, e.indexKey)
+	mac.Write([]byte(email))
+	index := hex.EncodeToString(mac.Sum(nil))
+	return &index
+}
+
+// userRow is a users row as stored, with the encrypted columns still
+// encrypted.
+type userRow struct {
+	domain.User
+	KeyID            sql.NullString `db:"key_id"`
+	DataKey          sql.NullString `db:"data_key"`
+	EncryptedColumns pq.StringArray `db:"encrypted_columns"`
+	EmailIndex       sql.NullString `db:"email_index"`
+	// SearchTokens is only read where rows are checked with current.
+	SearchTokens pq.StringArray `db:"search_tokens"`
+}
+
+// sealedUser holds what is written to the personal data columns of a user.
+type sealedUser struct {
+	values           map[string]string
+	keyID            *string
+	dataKey          *string
+	encryptedColumns pq.StringArray
+	emailIndex       *string
+	searchTokens     pq.StringArray
+}
+
+// seal encrypts the personal data of user with a new data key.
+func (e *FieldEncryption) seal(user *domain.User) (*sealedUser, error) {
+	sealed := &sealedUser{
+		values:           plainValues(user),
+		encryptedColumns: pq.StringArray{},
+		emailIndex:       e.emailIndex(user.Email),
+		searchTokens:     e.searchTokens(user),
+	}
+	if e == nil || len(e.columns) == 0 {
+		return sealed, nil
+	}
+
+	dataKey, wrapped, err := e.newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range e.columns {
+		if sealed.values[column], err = aesgcm.Seal(dataKey, []byte(sealed.values[column]), []byte(column)); err != nil {
+			return nil, domain.Internal("failed to encrypt user", err)
+		}
+	}
+	sealed.keyID, sealed.dataKey = &e.primaryID, &wrapped
+	sealed.encryptedColumns = append(sealed.encryptedColumns, e.columns...)
+	return sealed, nil
+}
+
+// open decrypts the encrypted columns of row and returns the user.
+func (e *FieldEncryption) open(row *userRow) (domain.User, error) {
+	user := row.User
+	if !row.KeyID.Valid || len(row.EncryptedColumns) == 0 {
+		return user, nil
+	}
+	if e == nil {
+		return user, domain.Internal("failed to decrypt user", errors.New("field encryption is not configured"))
+	}
+	dataKey, err := e.unwrapDataKey(row.KeyID.String, row.DataKey.String)
+	if err != nil {
+		return user, domain.Internal("failed to decrypt user", err)
+	}
+
+	fields := map[string]*string{
+		"name":         &user.Name,
+		"email":        &user.Email,
+		"first_name":   &user.FirstName,
+		"last_name":    &user.LastName,
+		"display_name": &user.DisplayName,
+		"phone_number": &user.PhoneNumber,
+	}
+	for _, column := range row.EncryptedColumns {
+		field, ok := fields[column]
+		if !ok {
+			return user, domain.Internal("failed to decrypt user", fmt.Errorf("unknown column %q", column))
+		}
+		plain, err := aesgcm.Open(dataKey, *field, []byte(column))
+		if err != nil {
+			return user, domain.Internal("failed to decrypt user", err)
+		}
+		*field = string(plain)
+	}
+	return user, nil
+}
+
+// newDataKey returns a new data key, and the key wrapped with the primary
+// KEK for storage.
+func (e *FieldEncryption) newDataKey() ([]byte, string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", domain.Internal("failed to generate data key", err)
+	}
+	wrapped, err := aesgcm.Seal(e.keys[e.primaryID], dataKey, []byte(e.primaryID))
+	if err != nil {
+		return nil, "", domain.Internal("failed to wrap data key", err)
+	}
+	return dataKey, wrapped, nil
+}
+
+// unwrapDataKey returns the data key wrapped with the KEK keyID.
+func (e *FieldEncryption) unwrapDataKey(keyID, wrapped string) ([]byte, error) {
+	kek, ok := e.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return aesgcm.Open(kek, wrapped, []byte(keyID))
+}
+
+// openUser is open for a single row.
+func (e *FieldEncryption) openUser(row *userRow) (*domain.User, error) {
+	user, err := e.open(row)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// openAll decrypts rows into users.
+func (e *FieldEncryption) openAll(rows []userRow) ([]domain.User, error) {
+	users := make([]domain.User, len(rows))
+	for i := range rows {
+		user, err := e.open(&rows[i])
+		if err != nil {
+			return nil, err
+		}
+		users[i] = user
+	}
+	return users, nil
+}
+
+// current reports whether row, holding user, is encrypted with the primary
+// key and exactly the configured columns, and has an up-to-date blind index
+// and blind tokens when encryption is enabled.
+func (e *FieldEncryption) current(row *userRow, user *domain.User) bool {
+	if e == nil {
+		return !row.KeyID.Valid
+	}
+	if !row.EmailIndex.Valid || row.EmailIndex.String != *e.emailIndex(user.Email) {
+		return false
+	}
+	tokens := e.searchTokens(user)
+	if len(row.SearchTokens) != len(tokens) {
+		return false
+	}
+	stored := append([]string(nil), row.SearchTokens...)
+	sort.Strings(stored)
+	for i := range stored {
+		if stored[i] != tokens[i] {
+			return false
+		}
+	}
+	if len(e.columns) == 0 {
+		return !row.KeyID.Valid
+	}
+	if row.KeyID.String != e.primaryID || len(row.EncryptedColumns) != len(e.columns) {
+		return false
+	}
+	columns := append([]string(nil), row.EncryptedColumns...)
+	sort.Strings(columns)
+	for i := range columns {
+		if columns[i] != e.columns[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// payloadAAD binds sealed payloads to their use, so that they cannot be
+// passed off as column values.
+var payloadAAD = []byte("payload")
+
+// sealedPayload is an outbox or webhook delivery payload encrypted with its
+// own data key. The user id stays readable so that the payloads of a user can
+// be found when the user is erased.
+type sealedPayload struct {
+	UserID  int    `json:"user_id"`
+	KeyID   string `json:"key_id"`
+	DataKey string `json:"data_key"`
+	Sealed  string `json:"sealed"`
+}
+
+// sealPayload encrypts the JSON payload about the user userID. Payloads are
+// kept in plain text when no column is encrypted.
+func (e *FieldEncryption) sealPayload(userID int, payload []byte) ([]byte, error) {
+	if e == nil || len(e.columns) == 0 {
+		return payload, nil
+	}
+	dataKey, wrapped, err := e.newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := aesgcm.Seal(dataKey, payload, payloadAAD)
+	if err != nil {
+		return nil, domain.Internal("failed to encrypt payload", err)
+	}
+	data, err := json.Marshal(sealedPayload{UserID: userID, KeyID: e.primaryID, DataKey: wrapped, Sealed: sealed})
+	if err != nil {
+		return nil, domain.Internal("failed to encode payload", err)
+	}
+	return data, nil
+}
+
+// openPayload returns the plain JSON of a payload written by sealPayload.
+// Payloads stored in plain text are returned as they are.
+func (e *FieldEncryption) openPayload(payload []byte) ([]byte, error) {
+	var sealed sealedPayload
+	if err := json.Unmarshal(payload, &sealed); err != nil || sealed.Sealed == "" {
+		return payload, nil
+	}
+	if e == nil {
+		return nil, domain.Internal("failed to decrypt payload", errors.New("field encryption is not configured"))
+	}
+	dataKey, err := e.unwrapDataKey(sealed.KeyID, sealed.DataKey)
+	if err != nil {
+		return nil, domain.Internal("failed to decrypt payload", err)
+	}
+	plain, err := aesgcm.Open(dataKey, sealed.Sealed, payloadAAD)
+	if err != nil {
+		return nil, domain.Internal("failed to decrypt payload", err)
+	}
+	return plain, nil
+}
+
+// token returns the blind token of value for kind, a column or "word": an
+// HMAC under the index key, cut to 128 bits.
+func (e *FieldEncryption) token(kind, value string) string {
+	mac := hmac.New(sha256.New, e.indexKey)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// prefixToken returns the token of users whose column starts with prefix.
+func (e *FieldEncryption) prefixToken(column, prefix string) string {
+	return e.token(column, prefix)
+}
+
+// wordToken returns the token of users with a word in SearchColumns that
+// starts with prefix.
+func (e *FieldEncryption) wordToken(prefix string) string {
+	return e.token("word", prefix)
+}
+
+// searchTokens returns the blind tokens of user, sorted.
+func (e *FieldEncryption) searchTokens(user *domain.User) pq.StringArray {
+	tokens := pq.StringArray{}
+	if e == nil {
+		return tokens
+	}
+	values := plainValues(user)
+	seen := map[string]bool{}
+	add := func(token string) {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	for _, column := range []string{"name", "email"} {
+		if e.Encrypted(column) {
+			for _, prefix := range prefixes(values[column]) {
+				add(e.prefixToken(column, prefix))
+			}
+		}
+	}
+	if e.BlindSearch() {
+		for _, column := range SearchColumns {
+			for _, word := range domain.SearchTerms(values[column]) {
+				for _, prefix := range prefixes(word) {
+					add(e.wordToken(prefix))
+				}
+			}
+		}
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// prefixes returns the prefixes of value of up to MaxTokenLength characters.
+func prefixes(value string) []string {
+	runes := []rune(value)
+	if len(runes) > MaxTokenLength {
+		runes = runes[:MaxTokenLength]
+	}
+	result := make([]string, len(runes))
+	for i := range runes {
+		result[i] = string(runes[:i+1])
+	}
+	return result
+}
+
+// plainValues maps the encryptable columns to their values in user.
+func plainValues(user *domain.User) map[string]string {
+	return map[string]string{
+		"name":         user.Name,
+		"email":        user.Email,
+		"first_name":   user.FirstName,
+		"last_name":    user.LastName,
+		"display_name": user.DisplayName,
+		"phone_number": user.PhoneNumber,
+	}
+}
+
+func isEncryptableColumn(column string) bool {
+	for _, c := range EncryptableColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package repositories
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"user-srv/domain"
+)
+
+// loadEncryption writes a key file with keys, filled with the given bytes,
+// and loads it with primary as the primary key.
+func loadEncryption(t *testing.T, primary string, keys map[string]byte, index byte, columns string) *FieldEncryption {
+	t.Helper()
+	file := keyFile{Primary: primary, Keys: map[string]string{}, IndexKey: encodedKey(index)}
+	for id, b := range keys {
+		file.Keys[id] = encodedKey(b)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := LoadFieldEncryption(path, columns)
+	if err != nil {
+		t.Fatalf("LoadFieldEncryption: %v", err)
+	}
+	return enc
+}
+
+func encodedKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// sealedRow returns the row enc writes for user.
+func sealedRow(t *testing.T, enc *FieldEncryption, user *domain.User) *userRow {
+	t.Helper()
+	sealed, err := enc.seal(user)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	row := &userRow{User: *user, EncryptedColumns: sealed.encryptedColumns, SearchTokens: sealed.searchTokens}
+	v := sealed.values
+	row.Name, row.Email, row.FirstName, row.LastName = v["name"], v["email"], v["first_name"], v["last_name"]
+	row.DisplayName, row.PhoneNumber = v["display_name"], v["phone_number"]
+	if sealed.keyID != nil {
+		row.KeyID = sql.NullString{String: *sealed.keyID, Valid: true}
+		row.DataKey = sql.NullString{String: *sealed.dataKey, Valid: true}
+	}
+	if sealed.emailIndex != nil {
+		row.EmailIndex = sql.NullString{String: *sealed.emailIndex, Valid: true}
+	}
+	return row
+}
+
+func testUser() *domain.User {
+	return &domain.User{
+		ID:    7,
+		Name:  "Ada Lovelace",
+		Email: "ada@example.com",
+		Profile: domain.Profile{
+			FirstName:   "Ada",
+			LastName:    "Lovelace",
+			PhoneNumber: "+441234567890",
+		},
+	}
+}
+
+func TestFieldEncryptionSealOpen(t *testing.T) {
+	enc := loadEncryption(t, "k1", map[string]byte{"k1": 1}, 9, "name, email,phone_number")
+	user := testUser()
+	row := sealedRow(t, enc, user)
+
+	if row.Name == user.Name || row.Email == user.Email || row.PhoneNumber == user.PhoneNumber {
+		t.Errorf("encrypted columns are stored in plain text: %q, %q, %q", row.Name, row.Email, row.PhoneNumber)
+	}
+	if row.FirstName != user.FirstName || row.LastName != user.LastName {
+		t.Errorf("columns that are not encrypted changed: %q, %q", row.FirstName, row.LastName)
+	}
+	opened, err := enc.open(row)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if opened.Name != user.Name || opened.Email != user.Email || opened.Profile != user.Profile {
+		t.Errorf("open = %+v, want %+v", opened, *user)
+	}
+	if !enc.current(row, user) {
+		t.Errorf("freshly sealed row is not current")
+	}
+
+	payload := []byte(`{"id":7,"email":"ada@example.com"}`)
+	sealed, err := enc.sealPayload(user.ID, payload)
+	if err != nil {
+		t.Fatalf("sealPayload: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("ada@example.com")) {
+		t.Errorf("sealed payload holds the email: %s", sealed)
+	}
+	if plain, err := enc.openPayload(sealed); err != nil || !bytes.Equal(plain, payload) {
+		t.Errorf("openPayload = %s, %v", plain, err)
+	}
+	if plain, err := enc.openPayload(payload); err != nil || !bytes.Equal(plain, payload) {
+		t.Errorf("openPayload of a plain payload = %s, %v", plain, err)
+	}
+}
+
+func TestFieldEncryptionPlainText(t *testing.T) {
+	var enc *FieldEncryption
+	user := testUser()
+	row := sealedRow(t, enc, user)
+	if row.KeyID.Valid || row.Name != user.Name || row.Email != user.Email {
+		t.Errorf("row is encrypted without encryption: %+v", row)
+	}
+	if !enc.current(row, user) {
+		t.Errorf("plain row is not current without encryption")
+	}
+	encrypted := sealedRow(t, loadEncryption(t, "k1", map[string]byte{"k1": 1}, 9, "name"), user)
+	if _, err := enc.open(encrypted); err == nil {
+		t.Errorf("open of an encrypted row without encryption succeeded")
+	}
+}
+
+func TestFieldEncryptionRotation(t *testing.T) {
+	old := loadEncryption(t, "k1", map[string]byte{"k1": 1}, 9, "name,email")
+	rotated := loadEncryption(t, "k2", map[string]byte{"k1": 1, "k2": 2}, 9, "name,email")
+	user := testUser()
+	row := sealedRow(t, old, user)
+
+	opened, err := rotated.open(row)
+	if err != nil || opened.Email != user.Email {
+		t.Fatalf("open with the retired key = %q, %v", opened.Email, err)
+	}
+	if rotated.current(row, user) {
+		t.Errorf("row wrapped with the retired key is current")
+	}
+	if !rotated.current(sealedRow(t, rotated, user), user) {
+		t.Errorf("row wrapped with the primary key is not current")
+	}
+
+	moreColumns := loadEncryption(t, "k1", map[string]byte{"k1": 1}, 9, "name,email,last_name")
+	if moreColumns.current(row, user) {
+		t.Errorf("row missing an encrypted column is current")
+	}
+	newIndexKey := loadEncryption(t, "k1", map[string]byte{"k1": 1}, 8, "name,email")
+	if newIndexKey.current(row, user) {
+		t.Errorf("row with blind index of another index key is current")
+	}
+	changed := *user
+	changed.DisplayName = "Countess"
+	if old.current(row, &changed) {
+		t.Errorf("row with outdated search tokens is current")
+	}
+}
+
+func TestFieldEncryptionWrongKey(t *testing.T) {
+	enc := loadEncryption(t, "k1", map[string]byte{"k1": 1}, 9, "name,email")
+	row := sealedRow(t, enc, testUser())
+
+	wrongKey := loadEncryption(t, "k1", map[string]byte{"k1": 2}, 9, "name,email")
+	if _, err := wrongKey.open(row); err == nil {
+		t.Errorf("open with the wrong key succeeded")
+	}
+	unknownKey := loadEncryption(t, "k2", map[string]byte{"k2": 1}, 9, "name,email")
+	if _, err := unknownKey.open(row); err == nil {
+		t.Errorf("open with an unknown key id succeeded")
+	}
+
+	sealed, err := enc.sealPayload(7, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("sealPayload: %v", err)
+	}
+	if _, err := wrongKey.openPayload(sealed); err == nil {
+		t.Errorf("openPayload with the wrong key succeeded")
+	}
+}
+
+func TestFieldEncryptionWrongColumn(t *testing.T) {
+	enc := loadEncryption(t, "k1", map[string]byte{"k1": 1}, 9, "name,email")
+	row := sealedRow(t, enc, testUser())
+
+	// Every value is bound to its column, so values cannot be swapped.
+	row.Name, row.Email = row.Email, row.Name
+	if _, err := enc.open(row); err == nil {
+		t.Errorf("open of values swapped between columns succeeded")
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	enc := loadEncryption(t, "k1", map[string]byte{"k1": 1}, 9, "name,email")
+	other := loadEncryption(t, "k1", map[string]byte{"k1": 1}, 8, "name,email")
+
+	if *enc.emailIndex("ada@example.com") != *enc.emailIndex("ada@example.com") {
+		t.Errorf("blind index is not deterministic")
+	}
+	if *enc.emailIndex("ada@example.com") == *enc.emailIndex("bob@example.com") {
+		t.Errorf("different emails have the same blind index")
+	}
+	if *enc.emailIndex("ada@example.com") == *other.emailIndex("ada@example.com") {
+		t.Errorf("blind index does not depend on the index key")
+	}
+	if enc.prefixToken("name", "ada") == enc.prefixToken("email", "ada") || enc.prefixToken("name", "ada") == enc.wordToken("ada") {
+		t.Errorf("tokens of the same prefix are shared between kinds")
+	}
+
+	tokens := enc.searchTokens(testUser())
+	seen := map[string]bool{}
+	for _, token := range tokens {
+		if seen[token] {
+			t.Errorf("duplicate token %s", token)
+		}
+		seen[token] = true
+	}
+	for _, want := range []string{enc.prefixToken("name", "Ada L"), enc.prefixToken("email", "ada@"), enc.wordToken("lovel"), enc.wordToken("example")} {
+		if !seen[want] {
+			t.Errorf("tokens miss %s", want)
+		}
+	}
+	if seen[enc.prefixToken("name", "ada")] || seen[enc.wordToken("ovelace")] {
+		t.Errorf("tokens match more than prefixes")
+	}
+
+	long := testUser()
+	long.Name = string(bytes.Repeat([]byte("a"), 2*MaxTokenLength))
+	if !contains(enc.searchTokens(long), enc.prefixToken("name", long.Name[:MaxTokenLength])) {
+		t.Errorf("tokens miss the longest prefix")
+	}
+	if contains(enc.searchTokens(long), enc.prefixToken("name", long.Name[:MaxTokenLength+1])) {
+		t.Errorf("tokens hold a prefix longer than %d characters", MaxTokenLength)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user-srv/domain"
+)
+
+type LoginThrottleRepository interface {
+	Get(ctx context.Context, scope, key string) (*domain.LoginThrottle, error)
+	RecordFailure(ctx context.Context, scope, key string, now time.Time, window time.Duration) (int, error)
+	Lock(ctx context.Context, scope, key string, until time.Time) error
+	Reset(ctx context.Context, scope, key string) error
+}
+
+type loginThrottleRepository struct {
+	db *sqlx.DB
+}
+
+func NewLoginThrottleRepository(db *sqlx.DB) LoginThrottleRepository {
+	return &loginThrottleRepository{db: db}
+}
+
+func (r *loginThrottleRepository) Get(ctx context.Context, scope, key string) (*domain.LoginThrottle, error) {
+	throttle := &domain.LoginThrottle{}
+	query := `
+		SELECT scope, key, failures, last_failed_at, locked_until
+		FROM login_throttles
+		WHERE scope = $1 AND key = $2`
+	err := r.db.GetContext(ctx, throttle, query, scope, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("no failed logins for %s %s", scope, key)
+		}
+		return nil, domain.Internal("failed to get login throttle", err)
+	}
+	return throttle, nil
+}
+
+// RecordFailure counts a failed login and returns the number of consecutive
+// failures. Failures older than window no longer count.
+func (r *loginThrottleRepository) RecordFailure(ctx context.Context, scope, key string, now time.Time, window time.Duration) (int, error) {
+	var failures int
+	query := `
+		INSERT INTO login_throttles (scope, key, failures, last_failed_at)
+		VALUES ($1, $2, 1, $3)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+		        WHEN login_throttles.last_failed_at < $4 THEN 1
+		        ELSE login_throttles.failures + 1
+		    END,
+		    last_failed_at = EXCLUDED.last_failed_at
+		RETURNING failures`
+	if err := r.db.GetContext(ctx, &failures, query, scope, key, now, now.Add(-window)); err != nil {
+		return 0, domain.Internal("failed to record failed login", err)
+	}
+	return failures, nil
+}
+
+func (r *loginThrottleRepository) Lock(ctx context.Context, scope, key string, until time.Time) error {
+	query := `
+		UPDATE login_throttles
+		SET locked_until = GREATEST(COALESCE(locked_until, $3), $3)
+		WHERE scope = $1 AND key = $2`
+	if _, err := r.db.ExecContext(ctx, query, scope, key, until); err != nil {
+		return domain.Internal("failed to lock login", err)
+	}
+	return nil
+}
+
+func (r *loginThrottleRepository) Reset(ctx context.Context, scope, key string) error {
+	query := `DELETE FROM login_throttles WHERE scope = $1 AND key = $2`
+	if _, err := r.db.ExecContext(ctx, query, scope, key); err != nil {
+		return domain.Internal("failed to reset login throttle", err)
+	}
+	return nil
+}
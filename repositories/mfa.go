@@ -0,0 +1,210 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"user-srv/domain"
+)
+
+type MFARepository interface {
+	Get(ctx context.Context, userID int) (*domain.UserMFA, error)
+	SavePending(ctx context.Context, userID int, secret string) error
+	Enable(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error
+	UseStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodeHashes []string) error
+	Delete(ctx context.Context, userID int) error
+}
+
+type mfaRepository struct {
+	db *sqlx.DB
+}
+
+func NewMFARepository(db *sqlx.DB) MFARepository {
+	return &mfaRepository{db: db}
+}
+
+func (r *mfaRepository) Get(ctx context.Context, userID int) (*domain.UserMFA, error) {
+	mfa := &domain.UserMFA{}
+	query := `
+		SELECT user_id, secret, last_used_step, enabled_at, created_at
+		FROM user_mfa
+		WHERE user_id = $1`
+	err := r.db.GetContext(ctx, mfa, query, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("two-factor authentication is not set up for user %d", userID)
+		}
+		return nil, domain.Internal("failed to get two-factor settings", err)
+	}
+	return mfa, nil
+}
+
+// SavePending stores a new, unconfirmed secret, replacing any previous
+// unconfirmed one. It fails if two-factor authentication is already enabled.
+func (r *mfaRepository) SavePending(ctx context.Context, userID int, secret string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = NULL, created_at = CURRENT_TIMESTAMP
+		WHERE user_mfa.enabled_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return domain.Internal("failed to save two-factor secret", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return domain.AlreadyExists("two-factor authentication is already enabled")
+	}
+	return nil
+}
+
+// Enable confirms a pending enrollment and replaces the user's recovery codes.
+func (r *mfaRepository) Enable(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_mfa
+		SET enabled_at = CURRENT_TIMESTAMP, last_used_step = $2
+		WHERE user_id = $1 AND enabled_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return domain.Internal("failed to enable two-factor authentication", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return domain.NotFound("no pending two-factor enrollment for user %d", userID)
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of a user with enabled
+// two-factor authentication, used or not.
+func (r *mfaRepository) ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	query := `SELECT enabled_at IS NOT NULL FROM user_mfa WHERE user_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &enabled, query, userID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return domain.Internal("failed to get two-factor settings", err)
+	}
+	if !enabled {
+		return domain.NotFound("two-factor authentication is not enabled for user %d", userID)
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
+	}
+	return nil
+}
+
+// Delete turns two-factor authentication off for the user, enabled or
+// pending, and removes their recovery codes.
+func (r *mfaRepository) Delete(ctx context.Context, userID int) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return domain.Internal("failed to delete recovery codes", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+	if err != nil {
+		return domain.Internal("failed to delete two-factor settings", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return domain.NotFound("two-factor authentication is not set up for user %d", userID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userID int, recoveryCodeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return domain.Internal("failed to delete recovery codes", err)
+	}
+	for _, hash := range recoveryCodeHashes {
+		query := `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, query, userID, hash); err != nil {
+			return domain.Internal("failed to store recovery code", err)
+		}
+	}
+	return nil
+}
+
+// UseStep records a TOTP time step as used. It fails for steps at or before
+// the last used one, so each code can only be used once.
+func (r *mfaRepository) UseStep(ctx context.Context, userID int, step int64) error {
+	query := `
+		UPDATE user_mfa
+		SET last_used_step = $2
+		WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)`
+	result, err := r.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return domain.Internal("failed to record two-factor code", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return domain.NotFound("two-factor code already used")
+	}
+	return nil
+}
+
+func (r *mfaRepository) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return domain.Internal("failed to use recovery code", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return domain.NotFound("recovery code not found")
+	}
+	return nil
+}
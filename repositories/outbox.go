@@ -0,0 +1,142 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user-srv/domain"
+)
+
+type OutboxRepository interface {
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]domain.OutboxMessage, error)
+	MarkPublished(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, retryIn time.Duration, reason string) error
+	MarkDead(ctx context.Context, id int64, reason string) error
+	Purge(ctx context.Context, publishedBefore time.Time) (int64, error)
+}
+
+type outboxRepository struct {
+	db  *sqlx.DB
+	enc *FieldEncryption
+}
+
+func NewOutboxRepository(db *sqlx.DB, enc *FieldEncryption) OutboxRepository {
+	return &outboxRepository{db: db, enc: enc}
+}
+
+// Claim leases up to limit pending messages that are due, oldest first. A
+// message is only due once every earlier pending message of the same user
+// has been published or has died, which keeps delivery ordered per user
+// across relays. Messages whose lease runs out, e.g. because their relay
+// crashed, are claimed again. Payloads are returned decrypted.
+func (r *outboxRepository) Claim(ctx context.Context, limit int, lease time.Duration) ([]domain.OutboxMessage, error) {
+	query := `
+		UPDATE outbox
+		SET locked_until = CURRENT_TIMESTAMP + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT o.id
+			FROM outbox o
+			WHERE o.status = 'pending'
+			  AND o.next_attempt_at <= CURRENT_TIMESTAMP
+			  AND (o.locked_until IS NULL OR o.locked_until < CURRENT_TIMESTAMP)
+			  AND NOT EXISTS (
+			      SELECT 1 FROM outbox p
+			      WHERE p.user_id = o.user_id AND p.status = 'pending' AND p.id < o.id)
+			ORDER BY o.id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, type, user_id, payload, attempts, created_at`
+	var messages []domain.OutboxMessage
+	if err := r.db.SelectContext(ctx, &messages, query, limit, lease.Milliseconds()); err != nil {
+		return nil, domain.Internal("failed to claim outbox messages", err)
+	}
+	for i := range messages {
+		payload, err := r.enc.openPayload(messages[i].Payload)
+		if err != nil {
+			return nil, err
+		}
+		messages[i].Payload = payload
+	}
+	return messages, nil
+}
+
+func (r *outboxRepository) MarkPublished(ctx context.Context, id int64) error {
+	query := `
+		UPDATE outbox
+		SET status = 'published', attempts = attempts + 1, published_at = CURRENT_TIMESTAMP, locked_until = NULL
+		WHERE id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return domain.Internal("failed to mark outbox message as published", err)
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt and schedules the next one retryIn
+// from now.
+func (r *outboxRepository) MarkFailed(ctx context.Context, id int64, retryIn time.Duration, reason string) error {
+	query := `
+		UPDATE outbox
+		SET attempts = attempts + 1, last_error = $2,
+		    next_attempt_at = CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond', locked_until = NULL
+		WHERE id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id, reason, retryIn.Milliseconds()); err != nil {
+		return domain.Internal("failed to mark outbox message as failed", err)
+	}
+	return nil
+}
+
+// MarkDead records the last failed attempt and gives up on the message.
+func (r *outboxRepository) MarkDead(ctx context.Context, id int64, reason string) error {
+	query := `
+		UPDATE outbox
+		SET status = 'dead', attempts = attempts + 1, last_error = $2, locked_until = NULL
+		WHERE id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id, reason); err != nil {
+		return domain.Internal("failed to mark outbox message as dead", err)
+	}
+	return nil
+}
+
+// Purge deletes messages published before publishedBefore and returns how
+// many were removed. Dead messages are kept for inspection.
+func (r *outboxRepository) Purge(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	query := `DELETE FROM outbox WHERE status = 'published' AND published_at < $1`
+	result, err := r.db.ExecContext(ctx, query, publishedBefore)
+	if err != nil {
+		return 0, domain.Internal("failed to purge outbox", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, domain.Internal("failed to check rows affected", err)
+	}
+	return purged, nil
+}
+
+// enqueueUserMessage stores a "user.<eventType>" message with the current
+// state of the user in the outbox as part of tx. Subscribers need the user
+// itself, so the user is decrypted with enc and the payload sealed again as a
+// whole; it is deleted after OUTBOX_RETENTION once published.
+func enqueueUserMessage(ctx context.Context, tx *sqlx.Tx, enc *FieldEncryption, eventType string, userID int) error {
+	row := &userRow{}
+	if err := tx.GetContext(ctx, row, `SELECT `+userColumns+` FROM users WHERE id = $1`, userID); err != nil {
+		return domain.Internal("failed to get user for outbox", err)
+	}
+	user, err := enc.openUser(row)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(domain.NewUserPayload(user))
+	if err != nil {
+		return domain.Internal("failed to encode outbox payload", err)
+	}
+	if payload, err = enc.sealPayload(userID, payload); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO outbox (type, user_id, payload) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, query, "user."+eventType, userID, payload); err != nil {
+		return domain.Internal("failed to enqueue outbox message", err)
+	}
+	return nil
+}
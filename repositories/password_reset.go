@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"user-srv/domain"
+)
+
+type PasswordResetRepository interface {
+	Create(ctx context.Context, token *domain.PasswordResetToken) error
+	Reset(ctx context.Context, tokenHash, passwordHash string, audit UserAudit) (int, error)
+}
+
+type passwordResetRepository struct {
+	db  *sqlx.DB
+	enc *FieldEncryption
+}
+
+func NewPasswordResetRepository(db *sqlx.DB, enc *FieldEncryption) PasswordResetRepository {
+	return &passwordResetRepository{db: db, enc: enc}
+}
+
+func (r *passwordResetRepository) Create(ctx context.Context, token *domain.PasswordResetToken) error {
+	query := `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+	err := r.db.QueryRowxContext(ctx, query, token.UserID, token.TokenHash, token.ExpiresAt).
+		Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return domain.Internal("failed to create password reset token", err)
+	}
+	return nil
+}
+
+// Reset consumes an unused, unexpired token and, in the same transaction,
+// sets the new password, invalidates the user's other reset tokens, revokes
+// all of their refresh tokens and records the change and its audit event
+// like every other user write. It returns the id of the user.
+func (r *passwordResetRepository) Reset(ctx context.Context, tokenHash, passwordHash string, audit UserAudit) (int, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id`
+	if err := tx.GetContext(ctx, &userID, query, tokenHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.NotFound("password reset token not found")
+		}
+		return 0, domain.Internal("failed to consume password reset token", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE users SET password = $1 WHERE id = $2 AND `+notDeleted, passwordHash, userID)
+	if err != nil {
+		return 0, domain.Internal("failed to update password", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return 0, domain.NotFound("password reset token not found")
+	}
+
+	query = `
+		UPDATE password_reset_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND used_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return 0, domain.Internal("failed to invalidate password reset tokens", err)
+	}
+
+	query = `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return 0, domain.Internal("failed to revoke refresh tokens", err)
+	}
+	if err := appendUserAudit(ctx, tx, r.enc, audit, userID); err != nil {
+		return 0, err
+	}
+	if err := recordUserChange(ctx, tx, r.enc, domain.UserEventUpdated, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, domain.Internal("failed to commit transaction", err)
+	}
+	return userID, nil
+}
@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"user-srv/domain"
+)
+
+// ErrRefreshTokenUsed is returned by Rotate when the token has already been
+// exchanged or revoked, which means it is being replayed.
+var ErrRefreshTokenUsed = errors.New("refresh token already used")
+
+// ErrSessionRevoked is returned by Rotate when the session of the token has
+// been revoked.
+var ErrSessionRevoked = errors.New("session revoked")
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *domain.RefreshToken) error
+	GetByHash(ctx context.Context, hash string) (*domain.RefreshToken, error)
+	Rotate(ctx context.Context, usedID int, next *domain.RefreshToken) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeUser(ctx context.Context, userID int) error
+}
+
+type refreshTokenRepository struct {
+	db *sqlx.DB
+}
+
+func NewRefreshTokenRepository(db *sqlx.DB) RefreshTokenRepository {
+	return &refreshTokenRepository{db: db}
+}
+
+func (r *refreshTokenRepository) Create(ctx context.Context, token *domain.RefreshToken) error {
+	return insertRefreshToken(ctx, r.db, token)
+}
+
+func (r *refreshTokenRepository) GetByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	token := &domain.RefreshToken{}
+	query := `
+		SELECT id, user_id, token_hash, family_id, session_id, mfa, expires_at, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1`
+	err := r.db.GetContext(ctx, token, query, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("refresh token not found")
+		}
+		return nil, domain.Internal("failed to get refresh token", err)
+	}
+	return token, nil
+}
+
+// Rotate marks the token with usedID as used and stores its successor in one
+// transaction. If the token was already used or revoked, nothing is written
+// and ErrRefreshTokenUsed is returned.
+func (r *refreshTokenRepository) Rotate(ctx context.Context, usedID int, next *domain.RefreshToken) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, usedID)
+	if err != nil {
+		return domain.Internal("failed to mark refresh token as used", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRefreshTokenUsed
+	}
+
+	// Holding the session row until commit orders the rotation with a
+	// concurrent sessions.Revoke: either the revocation is seen here, or it
+	// waits and then revokes the new token as well.
+	if next.SessionID != nil {
+		var active bool
+		query = `SELECT revoked_at IS NULL FROM sessions WHERE id = $1 FOR SHARE`
+		if err := tx.GetContext(ctx, &active, query, *next.SessionID); err != nil {
+			return domain.Internal("failed to check session", err)
+		}
+		if !active {
+			return ErrSessionRevoked
+		}
+	}
+
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
+	}
+	return nil
+}
+
+func (r *refreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = $1 AND revoked_at IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, familyID); err != nil {
+		return domain.Internal("failed to revoke refresh token family", err)
+	}
+	return nil
+}
+
+func (r *refreshTokenRepository) RevokeUser(ctx context.Context, userID int) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, userID); err != nil {
+		return domain.Internal("failed to revoke refresh tokens", err)
+	}
+	return nil
+}
+
+func insertRefreshToken(ctx context.Context, q sqlx.QueryerContext, token *domain.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, session_id, mfa, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	err := q.QueryRowxContext(ctx, query, token.UserID, token.TokenHash, token.FamilyID, token.SessionID, token.MFA, token.ExpiresAt).
+		Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return domain.Internal("failed to create refresh token", err)
+	}
+	return nil
+}
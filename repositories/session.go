@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user-srv/domain"
+)
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *domain.Session) error
+	Get(ctx context.Context, id string) (*domain.Session, error)
+	ListActive(ctx context.Context, userID int) ([]domain.Session, error)
+	ListByUser(ctx context.Context, userID int) ([]domain.Session, error)
+	Touch(ctx context.Context, id string, seenAt time.Time) error
+	Revoke(ctx context.Context, id string) error
+	RevokeUser(ctx context.Context, userID int) error
+}
+
+type sessionRepository struct {
+	db *sqlx.DB
+}
+
+func NewSessionRepository(db *sqlx.DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+func (r *sessionRepository) Create(ctx context.Context, session *domain.Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, user_agent, ip)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at, last_seen_at`
+	err := r.db.QueryRowxContext(ctx, query, session.ID, session.UserID, session.UserAgent, session.IP).
+		Scan(&session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		return domain.Internal("failed to create session", err)
+	}
+	return nil
+}
+
+func (r *sessionRepository) Get(ctx context.Context, id string) (*domain.Session, error) {
+	session := &domain.Session{}
+	query := `
+		SELECT id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at
+		FROM sessions
+		WHERE id = $1`
+	err := r.db.GetContext(ctx, session, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("session %s not found", id)
+		}
+		return nil, domain.Internal("failed to get session", err)
+	}
+	return session, nil
+}
+
+// ListActive returns the sessions of the user that were not revoked, most
+// recently used first.
+func (r *sessionRepository) ListActive(ctx context.Context, userID int) ([]domain.Session, error) {
+	sessions := []domain.Session{}
+	query := `
+		SELECT id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY last_seen_at DESC, id`
+	if err := r.db.SelectContext(ctx, &sessions, query, userID); err != nil {
+		return nil, domain.Internal("failed to list sessions", err)
+	}
+	return sessions, nil
+}
+
+// ListByUser returns all sessions of the user, revoked ones included,
+// newest first.
+func (r *sessionRepository) ListByUser(ctx context.Context, userID int) ([]domain.Session, error) {
+	sessions := []domain.Session{}
+	query := `
+		SELECT id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at
+		FROM sessions
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id`
+	if err := r.db.SelectContext(ctx, &sessions, query, userID); err != nil {
+		return nil, domain.Internal("failed to list sessions", err)
+	}
+	return sessions, nil
+}
+
+func (r *sessionRepository) Touch(ctx context.Context, id string, seenAt time.Time) error {
+	query := `UPDATE sessions SET last_seen_at = $2 WHERE id = $1 AND revoked_at IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, id, seenAt); err != nil {
+		return domain.Internal("failed to update session", err)
+	}
+	return nil
+}
+
+// Revoke ends the session and revokes its refresh tokens in one transaction.
+func (r *sessionRepository) Revoke(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE sessions
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return domain.Internal("failed to revoke session", err)
+	}
+
+	query = `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE session_id = $1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return domain.Internal("failed to revoke session refresh tokens", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
+	}
+	return nil
+}
+
+func (r *sessionRepository) RevokeUser(ctx context.Context, userID int) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, userID); err != nil {
+		return domain.Internal("failed to revoke sessions", err)
+	}
+	return nil
+}
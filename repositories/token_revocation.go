@@ -0,0 +1,79 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"time"
+	"user-srv/domain"
+)
+
+type TokenRevocationRepository interface {
+	RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID int, before time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	GetUserRevokedBefore(ctx context.Context, userID int) (*time.Time, error)
+}
+
+type tokenRevocationRepository struct {
+	db *sqlx.DB
+}
+
+func NewTokenRevocationRepository(db *sqlx.DB) TokenRevocationRepository {
+	return &tokenRevocationRepository{db: db}
+}
+
+// RevokeToken stores the jti until the token expires anyway. Entries of
+// tokens that have expired since are dropped on the way.
+func (r *tokenRevocationRepository) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING`
+	if _, err := r.db.ExecContext(ctx, query, jti, userID, expiresAt); err != nil {
+		return domain.Internal("failed to revoke token", err)
+	}
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return domain.Internal("failed to delete expired token revocations", err)
+	}
+	return nil
+}
+
+// RevokeUserTokens rejects every token issued to the user before the given
+// time. An earlier cutoff never replaces a later one.
+func (r *tokenRevocationRepository) RevokeUserTokens(ctx context.Context, userID int, before time.Time) error {
+	query := `
+		INSERT INTO user_token_revocations (user_id, revoked_before)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = GREATEST(user_token_revocations.revoked_before, EXCLUDED.revoked_before)`
+	if _, err := r.db.ExecContext(ctx, query, userID, before); err != nil {
+		return domain.Internal("failed to revoke user tokens", err)
+	}
+	return nil
+}
+
+func (r *tokenRevocationRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+	if err := r.db.GetContext(ctx, &revoked, query, jti); err != nil {
+		return false, domain.Internal("failed to check token revocation", err)
+	}
+	return revoked, nil
+}
+
+// GetUserRevokedBefore returns the user's token cutoff, or nil if none of
+// the user's tokens were ever revoked in bulk.
+func (r *tokenRevocationRepository) GetUserRevokedBefore(ctx context.Context, userID int) (*time.Time, error) {
+	var before time.Time
+	query := `SELECT revoked_before FROM user_token_revocations WHERE user_id = $1`
+	if err := r.db.GetContext(ctx, &before, query, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, domain.Internal("failed to get user token revocation", err)
+	}
+	return &before, nil
+}
@@ -3,113 +3,935 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
 	"user-srv/domain"
 )
 
 type UserRepository interface {
-	Create(ctx context.Context, user *domain.User) error
+	Create(ctx context.Context, user *domain.User, audit UserAudit) error
 	GetByID(ctx context.Context, id int) (*domain.User, error)
 	GetByEmail(ctx context.Context, email string) (*domain.User, error)
-	GetAll(ctx context.Context) ([]domain.User, error)
-	Update(ctx context.Context, user *domain.User) error
-	Delete(ctx context.Context, id int) error
+	GetIncludingDeleted(ctx context.Context, id int) (*domain.User, error)
+	List(ctx context.Context, query domain.UserQuery) ([]domain.User, int, error)
+	Update(ctx context.Context, user *domain.User, audit UserAudit) error
+	Patch(ctx context.Context, id int, patch domain.UserPatch, audit UserAudit) (*domain.User, error)
+	Delete(ctx context.Context, id int, audit UserAudit) error
+	Restore(ctx context.Context, id int, audit UserAudit) (*domain.User, error)
+	Erase(ctx context.Context, id int, audit UserAudit) (*domain.User, error)
+	SetStatus(ctx context.Context, id int, from, to, reason string, audit UserAudit) (*domain.User, error)
+	GetStatus(ctx context.Context, id int) (string, error)
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	Stream(ctx context.Context, query domain.UserQuery, fn func(*domain.User) error) error
+	Search(ctx context.Context, search domain.UserSearch) ([]domain.UserSearchHit, int, error)
+	GetMetadata(ctx context.Context, id int) (domain.Metadata, error)
+	UpdateMetadata(ctx context.Context, id int, set domain.Metadata, remove []string, audit UserAudit) (domain.Metadata, error)
+	Reencrypt(ctx context.Context, batchSize int) (int, error)
+	SyncIndexes(ctx context.Context) error
 }
 
+// userColumns lists the columns scanned into userRow.
+const userColumns = "id, name, email, password, role, status, email_verified_at, created_at, metadata, " +
+	profileColumns + ", " + encryptionColumns
+
+// profileColumns lists the columns scanned into domain.Profile.
+const profileColumns = "first_name, last_name, display_name, phone_number, locale, timezone, avatar_url, " +
+	"to_char(date_of_birth, 'YYYY-MM-DD') AS date_of_birth"
+
+// encryptionColumns lists the columns describing how the personal data of a
+// row is encrypted.
+const encryptionColumns = "key_id, data_key, encrypted_columns, email_index"
+
+// notDeleted restricts a query to users that were not soft-deleted. Every
+// read and write below applies it unless stated otherwise.
+const notDeleted = "deleted_at IS NULL"
+
 type userRepository struct {
-	db *sqlx.DB
+	db  *sqlx.DB
+	enc *FieldEncryption
 }
 
-func NewUserRepository(db *sqlx.DB) UserRepository {
-	return &userRepository{db: db}
+// NewUserRepository returns a repository that encrypts personal data with
+// enc, or stores it in plain text when enc is nil.
+func NewUserRepository(db *sqlx.DB, enc *FieldEncryption) UserRepository {
+	return &userRepository{db: db, enc: enc}
 }
 
-func (r *userRepository) Create(ctx context.Context, user *domain.User) error {
+func (r *userRepository) Create(ctx context.Context, user *domain.User, audit UserAudit) error {
 	query := `
-		INSERT INTO users (name, email, password) 
-		VALUES ($1, $2, $3) 
+		INSERT INTO users (name, email, password, role, status,
+		                   first_name, last_name, display_name, phone_number, locale, timezone, avatar_url, date_of_birth,
+		                   key_id, data_key, encrypted_columns, email_index, search_tokens, email_verified_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19) 
 		RETURNING id, created_at`
-	err := r.db.QueryRowxContext(ctx, query, user.Name, user.Email, user.Password).Scan(&user.ID, &user.CreatedAt)
+	p := user.Profile
+	sealed, err := r.enc.seal(user)
 	if err != nil {
-		return fmt.Errorf("failed to create user: %v", err)
+		return err
 	}
-	return nil
+	v := sealed.values
+	return r.writeUser(ctx, domain.UserEventCreated, audit, func(tx *sqlx.Tx) (int, error) {
+		if err := r.checkEmailAvailable(ctx, tx, user.Email, 0); err != nil {
+			return 0, err
+		}
+		err := tx.QueryRowxContext(ctx, query, v["name"], v["email"], user.Password, user.Role, user.Status,
+			v["first_name"], v["last_name"], v["display_name"], v["phone_number"], p.Locale, p.Timezone, p.AvatarURL,
+			p.DateOfBirth, sealed.keyID, sealed.dataKey, sealed.encryptedColumns, sealed.emailIndex, sealed.searchTokens, user.EmailVerifiedAt).
+			Scan(&user.ID, &user.CreatedAt)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return 0, domain.AlreadyExists("user with email %s already exists", user.Email)
+			}
+			return 0, domain.Internal("failed to create user", err)
+		}
+		return user.ID, nil
+	})
 }
 
 func (r *userRepository) GetByID(ctx context.Context, id int) (*domain.User, error) {
-	user := &domain.User{}
+	row := &userRow{}
 	query := `
-		SELECT id, name, email, password, created_at 
+		SELECT ` + userColumns + ` 
 		FROM users 
-		WHERE id = $1`
-	err := r.db.GetContext(ctx, user, query, id)
+		WHERE id = $1 AND ` + notDeleted
+	err := r.db.GetContext(ctx, row, query, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user with id %d not found", id)
+			return nil, domain.NotFound("user with id %d not found", id)
 		}
-		return nil, fmt.Errorf("failed to get user by id: %v", err)
+		return nil, domain.Internal("failed to get user by id", err)
 	}
-	return user, nil
+	return r.enc.openUser(row)
 }
 
+// GetByEmail finds the user by the blind index of email or, for rows whose
+// email is stored in plain text, by email itself.
 func (r *userRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
-	user := &domain.User{}
+	row := &userRow{}
 	query := `
-		SELECT id, name, email, password, created_at 
+		SELECT ` + userColumns + ` 
 		FROM users 
-		WHERE email = $1`
-	err := r.db.GetContext(ctx, user, query, email)
+		WHERE (email = $1 OR email_index = $2) AND ` + notDeleted
+	err := r.db.GetContext(ctx, row, query, email, r.enc.emailIndex(email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("user with email %s not found", email)
+		}
+		return nil, domain.Internal("failed to get user by email", err)
+	}
+	return r.enc.openUser(row)
+}
+
+// GetIncludingDeleted returns the user even if it was soft-deleted.
+func (r *userRepository) GetIncludingDeleted(ctx context.Context, id int) (*domain.User, error) {
+	row := &userRow{}
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	err := r.db.GetContext(ctx, row, query, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("user with email %s not found", email)
+			return nil, domain.NotFound("user with id %d not found", id)
 		}
-		return nil, fmt.Errorf("failed to get user by email: %v", err)
+		return nil, domain.Internal("failed to get user by id", err)
+	}
+	return r.enc.openUser(row)
+}
+
+// sortColumns maps sortable fields to the cast applied to cursor values.
+var sortColumns = map[string]string{
+	domain.UserSortID:        "::integer",
+	domain.UserSortName:      "",
+	domain.UserSortEmail:     "",
+	domain.UserSortCreatedAt: "::timestamp",
+}
+
+// List returns one page of users matching the query together with the total
+// number of matching users. Pagination is keyset-based on (sort column, id).
+func (r *userRepository) List(ctx context.Context, query domain.UserQuery) ([]domain.User, int, error) {
+	conditions, args := r.userFilters(query)
+
+	var total int
+	if err := r.db.GetContext(ctx, &total, "SELECT COUNT(*) FROM users"+whereClause(conditions), args...); err != nil {
+		return nil, 0, domain.Internal("failed to count users", err)
+	}
+
+	users, err := r.listPage(ctx, query, conditions, args)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// Stream calls fn for every user matching the query in id order. Users are
+// read in batches of query.Limit, each batch continuing after the last id of
+// the previous one, and the next batch is only read once fn has returned for
+// the current one. Stream stops at the first error returned by fn or when
+// ctx is done.
+func (r *userRepository) Stream(ctx context.Context, query domain.UserQuery, fn func(*domain.User) error) error {
+	conditions, args := r.userFilters(query)
+	query.SortBy, query.Descending = domain.UserSortID, false
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		users, err := r.listPage(ctx, query, conditions, args)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		for i := range users {
+			if err := fn(&users[i]); err != nil {
+				return err
+			}
+		}
+		if len(users) < query.Limit {
+			return nil
+		}
+		last := users[len(users)-1].ID
+		query.After = &domain.UserCursor{Value: strconv.Itoa(last), ID: last}
+	}
+}
+
+// userFilters returns the conditions and their arguments selecting the users
+// that match the filters of query. Prefixes of encrypted columns are matched
+// through their blind tokens.
+func (r *userRepository) userFilters(query domain.UserQuery) ([]string, []interface{}) {
+	conditions := []string{notDeleted}
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	prefixes := []struct{ column, prefix string }{{"email", query.EmailPrefix}, {"name", query.NamePrefix}}
+	for _, p := range prefixes {
+		switch {
+		case p.prefix == "":
+		case r.enc.Encrypted(p.column):
+			addCondition("search_tokens @> ARRAY[$%d::text]", r.enc.prefixToken(p.column, p.prefix))
+		default:
+			addCondition(p.column+" LIKE $%d", escapeLike(p.prefix)+"%")
+		}
+	}
+	if query.CreatedAfter != nil {
+		addCondition("created_at >= $%d", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		addCondition("created_at < $%d", *query.CreatedBefore)
+	}
+	if filter := query.Metadata; filter != nil {
+		// Both operators are served by the GIN index on metadata.
+		if filter.Value == nil {
+			addCondition("metadata ? $%d", filter.Key)
+		} else {
+			addCondition("metadata @> $%d::jsonb", domain.Metadata{filter.Key: filter.Value})
+		}
+	}
+	return conditions, args
+}
+
+// listPage returns up to query.Limit users matching conditions that come
+// after query.After in the sort order of query.
+func (r *userRepository) listPage(ctx context.Context, query domain.UserQuery, conditions []string, args []interface{}) ([]domain.User, error) {
+	// Copy so that appending keyset conditions never touches the caller's slices.
+	conditions = append([]string(nil), conditions...)
+	args = append([]interface{}(nil), args...)
+
+	column := query.SortBy
+	cast, ok := sortColumns[column]
+	if !ok {
+		column, cast = domain.UserSortID, sortColumns[domain.UserSortID]
+	}
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if query.After != nil {
+		args = append(args, query.After.Value, query.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d%s, $%d)",
+			column, comparison, len(args)-1, cast, len(args)))
+	}
+
+	args = append(args, query.Limit)
+	listQuery := fmt.Sprintf(`
+		SELECT %s 
+		FROM users%s
+		ORDER BY %s %s, id %s
+		LIMIT $%d`, userColumns, whereClause(conditions), column, direction, direction, len(args))
+
+	var rows []userRow
+	if err := r.db.SelectContext(ctx, &rows, listQuery, args...); err != nil {
+		return nil, domain.Internal("failed to list users", err)
+	}
+	return r.enc.openAll(rows)
+}
+
+// searchRow is a user matching a search with its rank and the total number
+// of matches.
+type searchRow struct {
+	userRow
+	Rank       float64 `db:"rank"`
+	TotalCount int     `db:"total_count"`
+}
+
+// Search ranks users by full-text match of the search terms as prefixes,
+// plus trigram word similarity of the whole query to name, email and
+// display name. Substring matches are found through the trigram indexes.
+// While searched columns are encrypted, see searchBlind.
+func (r *userRepository) Search(ctx context.Context, search domain.UserSearch) ([]domain.UserSearchHit, int, error) {
+	if r.enc.BlindSearch() {
+		return r.searchBlind(ctx, search)
+	}
+	prefixes := make([]string, len(search.Terms))
+	for i, term := range search.Terms {
+		prefixes[i] = term + ":*"
+	}
+	tsQuery := strings.Join(prefixes, " & ")
+	pattern := "%" + escapeLike(search.Query) + "%"
+
+	query := `
+		SELECT ` + userColumns + `,
+		       ts_rank(search_vector, to_tsquery('simple', $1)) +
+		       GREATEST(word_similarity($3, name), word_similarity($3, email), word_similarity($3, display_name)) AS rank,
+		       COUNT(*) OVER () AS total_count
+		FROM users
+		WHERE ` + notDeleted + `
+		  AND (search_vector @@ to_tsquery('simple', $1)
+		       OR name ILIKE $2 OR email ILIKE $2 OR display_name ILIKE $2
+		       OR $3 <% name OR $3 <% display_name)
+		ORDER BY rank DESC, id
+		LIMIT $4 OFFSET $5`
+	var rows []searchRow
+	if err := r.db.SelectContext(ctx, &rows, query, tsQuery, pattern, search.Query, search.Limit, search.Offset); err != nil {
+		return nil, 0, domain.Internal("failed to search users", err)
+	}
+	return r.searchHits(rows)
+}
+
+// searchBlind finds users with a word starting with each of the search
+// terms through their blind tokens. Without the text there is nothing to
+// rank by, so users come in id order and substrings are not found.
+func (r *userRepository) searchBlind(ctx context.Context, search domain.UserSearch) ([]domain.UserSearchHit, int, error) {
+	tokens := make(pq.StringArray, len(search.Terms))
+	for i, term := range search.Terms {
+		tokens[i] = r.enc.wordToken(term)
+	}
+	query := `
+		SELECT ` + userColumns + `, 0 AS rank, COUNT(*) OVER () AS total_count
+		FROM users
+		WHERE ` + notDeleted + ` AND search_tokens @> $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3`
+	var rows []searchRow
+	if err := r.db.SelectContext(ctx, &rows, query, tokens, search.Limit, search.Offset); err != nil {
+		return nil, 0, domain.Internal("failed to search users", err)
+	}
+	return r.searchHits(rows)
+}
+
+// searchHits decrypts the users of rows.
+func (r *userRepository) searchHits(rows []searchRow) ([]domain.UserSearchHit, int, error) {
+	hits := make([]domain.UserSearchHit, len(rows))
+	total := 0
+	for i := range rows {
+		user, err := r.enc.open(&rows[i].userRow)
+		if err != nil {
+			return nil, 0, err
+		}
+		hits[i] = domain.UserSearchHit{User: user, Rank: rows[i].Rank}
+		total = rows[i].TotalCount
+	}
+	return hits, total, nil
+}
+
+// Update replaces the name, email, password and profile of the user.
+func (r *userRepository) Update(ctx context.Context, user *domain.User, audit UserAudit) error {
+	return r.writeUser(ctx, domain.UserEventUpdated, audit, func(tx *sqlx.Tx) (int, error) {
+		current, err := r.getForUpdate(ctx, tx, user.ID)
+		if err != nil {
+			return 0, err
+		}
+		updated := *current
+		updated.Name, updated.Email, updated.Password = user.Name, user.Email, user.Password
+		updated.Profile = user.Profile
+		if err := r.updateUser(ctx, tx, current, &updated); err != nil {
+			return 0, err
+		}
+		*user = updated
+		return user.ID, nil
+	})
+}
+
+// Patch writes only the fields set in patch and returns the updated user.
+// An empty patch writes nothing and records no audit event.
+func (r *userRepository) Patch(ctx context.Context, id int, patch domain.UserPatch, audit UserAudit) (*domain.User, error) {
+	if patch == (domain.UserPatch{}) {
+		return r.GetByID(ctx, id)
+	}
+	var user *domain.User
+	err := r.writeUser(ctx, domain.UserEventUpdated, audit, func(tx *sqlx.Tx) (int, error) {
+		current, err := r.getForUpdate(ctx, tx, id)
+		if err != nil {
+			return 0, err
+		}
+		updated := *current
+		fields := []struct {
+			value  *string
+			target *string
+		}{
+			{patch.Name, &updated.Name},
+			{patch.Email, &updated.Email},
+			{patch.Password, &updated.Password},
+			{patch.FirstName, &updated.FirstName},
+			{patch.LastName, &updated.LastName},
+			{patch.DisplayName, &updated.DisplayName},
+			{patch.PhoneNumber, &updated.PhoneNumber},
+			{patch.Locale, &updated.Locale},
+			{patch.Timezone, &updated.Timezone},
+			{patch.AvatarURL, &updated.AvatarURL},
+		}
+		for _, field := range fields {
+			if field.value != nil {
+				*field.target = *field.value
+			}
+		}
+		if patch.DateOfBirth != nil {
+			if *patch.DateOfBirth == "" {
+				updated.DateOfBirth = nil
+			} else {
+				dateOfBirth := *patch.DateOfBirth
+				updated.DateOfBirth = &dateOfBirth
+			}
+		}
+		if err := r.updateUser(ctx, tx, current, &updated); err != nil {
+			return 0, err
+		}
+		user = &updated
+		return id, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return user, nil
 }
 
-func (r *userRepository) GetAll(ctx context.Context) ([]domain.User, error) {
-	var users []domain.User
+// getForUpdate reads the user and locks its row until tx ends.
+func (r *userRepository) getForUpdate(ctx context.Context, tx *sqlx.Tx, id int) (*domain.User, error) {
+	row := &userRow{}
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND ` + notDeleted + ` FOR UPDATE`
+	if err := tx.GetContext(ctx, row, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("user with id %d not found", id)
+		}
+		return nil, domain.Internal("failed to get user by id", err)
+	}
+	return r.enc.openUser(row)
+}
+
+// updateUser writes the name, email, password and profile of updated over
+// the row of current, as part of tx. The personal data is encrypted with a
+// new data key, and a new email has to be verified again.
+func (r *userRepository) updateUser(ctx context.Context, tx *sqlx.Tx, current, updated *domain.User) error {
+	if updated.Email != current.Email {
+		updated.EmailVerifiedAt = nil
+		if err := r.checkEmailAvailable(ctx, tx, updated.Email, updated.ID); err != nil {
+			return err
+		}
+	}
+	sealed, err := r.enc.seal(updated)
+	if err != nil {
+		return err
+	}
+	v, p := sealed.values, updated.Profile
+
 	query := `
-		SELECT id, name, email, password, created_at 
-		FROM users`
-	err := r.db.SelectContext(ctx, &users, query)
+		UPDATE users 
+		SET name = $2, email = $3, password = $4, email_verified_at = $5,
+		    first_name = $6, last_name = $7, display_name = $8, phone_number = $9,
+		    locale = $10, timezone = $11, avatar_url = $12, date_of_birth = $13,
+		    key_id = $14, data_key = $15, encrypted_columns = $16, email_index = $17, search_tokens = $18
+		WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, updated.ID, v["name"], v["email"], updated.Password, updated.EmailVerifiedAt,
+		v["first_name"], v["last_name"], v["display_name"], v["phone_number"], p.Locale, p.Timezone, p.AvatarURL,
+		p.DateOfBirth, sealed.keyID, sealed.dataKey, sealed.encryptedColumns, sealed.emailIndex, sealed.searchTokens)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get all users: %v", err)
+		if isUniqueViolation(err) {
+			return domain.AlreadyExists("user with email %s already exists", updated.Email)
+		}
+		return domain.Internal("failed to update user", err)
+	}
+	return nil
+}
+
+// checkEmailAvailable fails if a user other than id has email in plain
+// text. Only email_index keeps encrypted emails unique, and rows written
+// before encryption was enabled have none until they are reencrypted.
+func (r *userRepository) checkEmailAvailable(ctx context.Context, tx *sqlx.Tx, email string, id int) error {
+	if r.enc == nil {
+		return nil
+	}
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1 AND id <> $2)`
+	if err := tx.GetContext(ctx, &taken, query, email, id); err != nil {
+		return domain.Internal("failed to check email", err)
+	}
+	if taken {
+		return domain.AlreadyExists("user with email %s already exists", email)
 	}
-	return users, nil
+	return nil
+}
+
+// Delete soft-deletes the user. The row is kept until Purge removes it, so
+// the user can be restored in the meantime.
+func (r *userRepository) Delete(ctx context.Context, id int, audit UserAudit) error {
+	query := `UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND ` + notDeleted
+	return r.writeUser(ctx, domain.UserEventDeleted, audit, func(tx *sqlx.Tx) (int, error) {
+		result, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return 0, domain.Internal("failed to delete user", err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, domain.Internal("failed to check rows affected", err)
+		}
+		if rowsAffected == 0 {
+			return 0, domain.NotFound("user with id %d not found", id)
+		}
+		return id, nil
+	})
 }
 
-func (r *userRepository) Update(ctx context.Context, user *domain.User) error {
+// setStatusQuery changes the status of user $1 from $2 to $3 for reason $4.
+const setStatusQuery = `
+		UPDATE users 
+		SET status = $3, status_reason = $4, status_changed_at = CURRENT_TIMESTAMP 
+		WHERE id = $1 AND status = $2 AND ` + notDeleted
+
+// SetStatus changes the status of the user if it is still from. Otherwise
+// the user was changed concurrently and NotFound is returned.
+func (r *userRepository) SetStatus(ctx context.Context, id int, from, to, reason string, audit UserAudit) (*domain.User, error) {
+	row := &userRow{}
+	query := setStatusQuery + ` 
+		RETURNING ` + userColumns
+	err := r.writeUser(ctx, domain.UserEventUpdated, audit, func(tx *sqlx.Tx) (int, error) {
+		if err := tx.GetContext(ctx, row, query, id, from, to, reason); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, domain.NotFound("user with id %d and status %s not found", id, from)
+			}
+			return 0, domain.Internal("failed to set user status", err)
+		}
+		return id, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.enc.openUser(row)
+}
+
+// GetStatus returns the status of the user without reading the rest of it.
+func (r *userRepository) GetStatus(ctx context.Context, id int) (string, error) {
+	var status string
+	query := `SELECT status FROM users WHERE id = $1 AND ` + notDeleted
+	if err := r.db.GetContext(ctx, &status, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", domain.NotFound("user with id %d not found", id)
+		}
+		return "", domain.Internal("failed to get user status", err)
+	}
+	return status, nil
+}
+
+// Restore undoes a soft delete and returns the restored user. Watchers see
+// it as an update that carries the whole user again.
+func (r *userRepository) Restore(ctx context.Context, id int, audit UserAudit) (*domain.User, error) {
+	row := &userRow{}
+	query := `
+		UPDATE users 
+		SET deleted_at = NULL 
+		WHERE id = $1 AND deleted_at IS NOT NULL AND erased_at IS NULL
+		RETURNING ` + userColumns
+	err := r.writeUser(ctx, domain.UserEventUpdated, audit, func(tx *sqlx.Tx) (int, error) {
+		if err := tx.GetContext(ctx, row, query, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, domain.NotFound("deleted user with id %d not found", id)
+			}
+			return 0, domain.Internal("failed to restore user", err)
+		}
+		return id, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.enc.openUser(row)
+}
+
+// Erase anonymises the user, soft-deleted or not, and removes the personal
+// data kept about them elsewhere, all in one transaction. Credentials,
+// sessions and MFA are deleted; the user snapshots in user events, the
+// outbox and webhook deliveries are replaced with the anonymised user. The
+// row itself is kept, soft-deleted, so that references to the id stay
+// valid. Watchers and subscribers see an erased event.
+func (r *userRepository) Erase(ctx context.Context, id int, audit UserAudit) (*domain.User, error) {
+	row := &userRow{}
+	var user *domain.User
+	query := `
+		UPDATE users
+		SET name = $2, email = 'erased-' || id || '@erased.invalid', password = '', email_verified_at = NULL,
+		    key_id = NULL, data_key = NULL, encrypted_columns = '{}', email_index = NULL, search_tokens = '{}',
+		    status = $3, status_reason = '', status_changed_at = CURRENT_TIMESTAMP, metadata = '{}',
+		    first_name = '', last_name = '', display_name = '', phone_number = '', locale = '', timezone = '',
+		    avatar_url = '', date_of_birth = NULL,
+		    deleted_at = COALESCE(deleted_at, CURRENT_TIMESTAMP), erased_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND erased_at IS NULL
+		RETURNING ` + userColumns
+	err := r.writeUser(ctx, domain.UserEventErased, audit, func(tx *sqlx.Tx) (int, error) {
+		if err := tx.GetContext(ctx, row, query, id, domain.ErasedUserName, domain.StatusDisabled); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, domain.NotFound("user with id %d not found", id)
+			}
+			return 0, domain.Internal("failed to erase user", err)
+		}
+		erased, err := r.enc.openUser(row)
+		if err != nil {
+			return 0, err
+		}
+		user = erased
+		if err := eraseUserData(ctx, tx, r.enc, user); err != nil {
+			return 0, err
+		}
+		return id, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// eraseUserData removes or anonymises what other tables keep about the
+// already anonymised user, as part of tx. Outbox and webhook payloads are
+// replaced in plain text, as they no longer hold personal data.
+func eraseUserData(ctx context.Context, tx *sqlx.Tx, enc *FieldEncryption, user *domain.User) error {
+	deletes := []string{
+		`DELETE FROM refresh_tokens WHERE user_id = $1`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1`,
+		`DELETE FROM email_verification_tokens WHERE user_id = $1`,
+		`DELETE FROM mfa_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM user_mfa WHERE user_id = $1`,
+	}
+	for _, query := range deletes {
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+			return domain.Internal("failed to erase user data", err)
+		}
+	}
+
+	query := `DELETE FROM login_throttles WHERE scope = $1 AND key = $2`
+	if _, err := tx.ExecContext(ctx, query, domain.ThrottleAccount, strconv.Itoa(user.ID)); err != nil {
+		return domain.Internal("failed to erase user data", err)
+	}
+
+	query = `UPDATE user_events SET snapshot = (SELECT ` + userSnapshot + ` FROM users WHERE id = $1) WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+		return domain.Internal("failed to erase user events", err)
+	}
+
+	payload, err := json.Marshal(domain.NewUserPayload(user))
+	if err != nil {
+		return domain.Internal("failed to encode outbox payload", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE outbox SET payload = $2 WHERE user_id = $1`, user.ID, payload); err != nil {
+		return domain.Internal("failed to erase outbox messages", err)
+	}
+	// Deliveries carry the published envelope, with the user under "data".
+	// Sealed envelopes have to be opened to replace it.
+	var deliveries []struct {
+		ID      int64  `db:"id"`
+		Payload []byte `db:"payload"`
+	}
+	query = `SELECT id, payload FROM webhook_deliveries WHERE payload->>'user_id' = $1 FOR UPDATE`
+	if err := tx.SelectContext(ctx, &deliveries, query, strconv.Itoa(user.ID)); err != nil {
+		return domain.Internal("failed to erase webhook deliveries", err)
+	}
+	for _, delivery := range deliveries {
+		plain, err := enc.openPayload(delivery.Payload)
+		if err != nil {
+			return err
+		}
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(plain, &envelope); err != nil {
+			return domain.Internal("failed to decode webhook payload", err)
+		}
+		envelope["data"] = payload
+		erased, err := json.Marshal(envelope)
+		if err != nil {
+			return domain.Internal("failed to encode webhook payload", err)
+		}
+		query = `UPDATE webhook_deliveries SET payload = $2 WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, query, delivery.ID, erased); err != nil {
+			return domain.Internal("failed to erase webhook deliveries", err)
+		}
+	}
+	return nil
+}
+
+// Purge permanently deletes users soft-deleted before deletedBefore and
+// returns how many were removed. Erased users are kept, see Erase.
+func (r *userRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := `DELETE FROM users WHERE deleted_at < $1 AND erased_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, domain.Internal("failed to purge deleted users", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, domain.Internal("failed to check rows affected", err)
+	}
+	return purged, nil
+}
+
+func (r *userRepository) GetMetadata(ctx context.Context, id int) (domain.Metadata, error) {
+	var metadata domain.Metadata
+	query := `SELECT metadata FROM users WHERE id = $1 AND ` + notDeleted
+	if err := r.db.GetContext(ctx, &metadata, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("user with id %d not found", id)
+		}
+		return nil, domain.Internal("failed to get user metadata", err)
+	}
+	return metadata, nil
+}
+
+// UpdateMetadata merges set into the user's metadata and drops the keys in
+// remove, atomically, and returns the result.
+func (r *userRepository) UpdateMetadata(ctx context.Context, id int, set domain.Metadata, remove []string, audit UserAudit) (domain.Metadata, error) {
+	var metadata domain.Metadata
 	query := `
 		UPDATE users 
-		SET name = $1, email = $2, password = $3 
-		WHERE id = $4 
-		RETURNING created_at`
-	err := r.db.QueryRowxContext(ctx, query, user.Name, user.Email, user.Password, user.ID).Scan(&user.CreatedAt)
+		SET metadata = (metadata || $2::jsonb) - $3::text[] 
+		WHERE id = $1 AND ` + notDeleted + ` 
+		RETURNING metadata`
+	err := r.writeUser(ctx, domain.UserEventUpdated, audit, func(tx *sqlx.Tx) (int, error) {
+		if err := tx.GetContext(ctx, &metadata, query, id, set, pq.Array(remove)); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, domain.NotFound("user with id %d not found", id)
+			}
+			if isCheckViolation(err) {
+				return 0, domain.Validation("metadata cannot exceed %d bytes", domain.MaxMetadataSize)
+			}
+			return 0, domain.Internal("failed to update user metadata", err)
+		}
+		return id, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// Reencrypt encrypts the personal data of every user that is not yet
+// encrypted with the primary key and the configured columns, or lacks an
+// up-to-date blind index, with a new data key. Users are read in batches of
+// batchSize and each is rewritten in its own transaction. It returns how
+// many users were rewritten.
+//
+// The users themselves do not change, so no events are recorded. Snapshots
+// in user events stay encrypted with the key they were written with: keep
+// a retired key in the key file until USER_EVENT_RETENTION has passed.
+func (r *userRepository) Reencrypt(ctx context.Context, batchSize int) (int, error) {
+	if r.enc == nil {
+		return 0, domain.Internal("failed to reencrypt users", errors.New("field encryption is not configured"))
+	}
+	query := `
+		SELECT ` + userColumns + `, search_tokens
+		FROM users
+		WHERE id > $1 AND erased_at IS NULL
+		ORDER BY id
+		LIMIT $2`
+	rewritten, after := 0, 0
+	for {
+		var rows []userRow
+		if err := r.db.SelectContext(ctx, &rows, query, after, batchSize); err != nil {
+			return rewritten, domain.Internal("failed to list users", err)
+		}
+		for i := range rows {
+			user, err := r.enc.open(&rows[i])
+			if err != nil {
+				return rewritten, err
+			}
+			if r.enc.current(&rows[i], &user) {
+				continue
+			}
+			if err := r.reencryptUser(ctx, user.ID); err != nil {
+				return rewritten, err
+			}
+			rewritten++
+		}
+		if len(rows) < batchSize {
+			return rewritten, nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// plainTextIndexes are the indexes on users that only serve the plain text of
+// their columns.
+var plainTextIndexes = []struct {
+	name       string
+	columns    []string
+	definition string
+}{
+	{"idx_users_name_pattern", []string{"name"}, "(name text_pattern_ops)"},
+	{"idx_users_email_pattern", []string{"email"}, "(email text_pattern_ops)"},
+	{"idx_users_name_id", []string{"name"}, "(name, id)"},
+	{"idx_users_search_vector", SearchColumns, "USING GIN (search_vector)"},
+	{"idx_users_name_trgm", []string{"name"}, "USING GIN (name gin_trgm_ops)"},
+	{"idx_users_email_trgm", []string{"email"}, "USING GIN (email gin_trgm_ops)"},
+	{"idx_users_display_name_trgm", []string{"display_name"}, "USING GIN (display_name gin_trgm_ops)"},
+}
+
+// SyncIndexes drops the plain text indexes of encrypted columns, which would
+// only index ciphertext, and creates those of the other columns again. Both
+// run concurrently, so that users can still be written meanwhile. A failed
+// concurrent build leaves an invalid index behind, which is built again.
+func (r *userRepository) SyncIndexes(ctx context.Context) error {
+	for _, index := range plainTextIndexes {
+		drop := `DROP INDEX CONCURRENTLY IF EXISTS ` + index.name
+		create := `CREATE INDEX CONCURRENTLY ` + index.name + ` ON users ` + index.definition
+		queries := []string{drop}
+		if !r.encryptedAny(index.columns) {
+			var valid sql.NullBool
+			query := `
+				SELECT i.indisvalid
+				FROM pg_index i
+				JOIN pg_class c ON c.oid = i.indexrelid
+				WHERE c.relname = $1 AND c.relnamespace = current_schema()::regnamespace`
+			if err := r.db.GetContext(ctx, &valid, query, index.name); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return domain.Internal("failed to sync user indexes", err)
+			}
+			switch {
+			case !valid.Valid:
+				queries = []string{create}
+			case valid.Bool:
+				queries = nil
+			default:
+				queries = []string{drop, create}
+			}
+		}
+		for _, query := range queries {
+			if _, err := r.db.ExecContext(ctx, query); err != nil {
+				return domain.Internal("failed to sync user indexes", err)
+			}
+		}
+	}
+	return nil
+}
+
+// encryptedAny reports whether any of columns is encrypted.
+func (r *userRepository) encryptedAny(columns []string) bool {
+	for _, column := range columns {
+		if r.enc.Encrypted(column) {
+			return true
+		}
+	}
+	return false
+}
+
+// reencryptUser rewrites the personal data of the user with a new data key,
+// unless it was erased in the meantime.
+func (r *userRepository) reencryptUser(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
 	if err != nil {
+		return domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	row := &userRow{}
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND erased_at IS NULL FOR UPDATE`
+	if err := tx.GetContext(ctx, row, query, id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("user with id %d not found", user.ID)
+			return nil
 		}
-		return fmt.Errorf("failed to update user: %v", err)
+		return domain.Internal("failed to get user by id", err)
+	}
+	user, err := r.enc.openUser(row)
+	if err != nil {
+		return err
+	}
+	if err := r.updateUser(ctx, tx, user, user); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
 	}
 	return nil
 }
 
-func (r *userRepository) Delete(ctx context.Context, id int) error {
-	query := `DELETE FROM users WHERE id = $1`
-	result, err := r.db.ExecContext(ctx, query, id)
+// writeUser runs write in a transaction together with the audit event of
+// the change and recordUserChange for the user whose id write returns, so
+// that the audit log, watchers and other services learn about every
+// committed change and about nothing else.
+func (r *userRepository) writeUser(ctx context.Context, eventType string, audit UserAudit, write func(tx *sqlx.Tx) (int, error)) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %v", err)
+		return domain.Internal("failed to begin transaction", err)
 	}
-	rowsAffected, err := result.RowsAffected()
+	defer tx.Rollback()
+
+	id, err := write(tx)
 	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %v", err)
+		return err
+	}
+	if err := appendUserAudit(ctx, tx, r.enc, audit, id); err != nil {
+		return err
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("user with id %d not found", id)
+	if err := recordUserChange(ctx, tx, r.enc, eventType, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
 	}
 	return nil
 }
+
+// recordUserChange records a change of eventType to the user for watchers
+// and enqueues it for publishing, as part of tx. The message is published
+// decrypted with enc. It must be the last statement before tx commits, see
+// recordUserEvent.
+func recordUserChange(ctx context.Context, tx *sqlx.Tx, enc *FieldEncryption, eventType string, userID int) error {
+	if err := enqueueUserMessage(ctx, tx, enc, eventType, userID); err != nil {
+		return err
+	}
+	return recordUserEvent(ctx, tx, eventType, userID)
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func isCheckViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23514"
+}
@@ -0,0 +1,157 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"strconv"
+	"time"
+	"user-srv/domain"
+)
+
+// UserEventsChannel is the Postgres notification channel that receives the
+// revision of every recorded user event.
+const UserEventsChannel = "user_events"
+
+// userSnapshot is the JSON form of a users row stored with user events,
+// without the password hash and the derived search columns. Encrypted columns
+// stay encrypted.
+const userSnapshot = `(to_jsonb(users) - 'search_vector' - 'search_tokens') || '{"password": ""}'::jsonb`
+
+// userEventsLock is the advisory lock key serializing user event writers.
+const userEventsLock = 0x75736572 // "user"
+
+type UserEventRepository interface {
+	ListAfter(ctx context.Context, revision int64, limit int) ([]domain.UserEvent, error)
+	Revisions(ctx context.Context) (oldest, latest int64, err error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type userEventRepository struct {
+	db  *sqlx.DB
+	enc *FieldEncryption
+}
+
+// NewUserEventRepository returns a repository that decrypts the snapshots of
+// users with enc.
+func NewUserEventRepository(db *sqlx.DB, enc *FieldEncryption) UserEventRepository {
+	return &userEventRepository{db: db, enc: enc}
+}
+
+// userEventRow is a user event with the user decoded from its snapshot.
+type userEventRow struct {
+	Revision   int64     `db:"revision"`
+	Type       string    `db:"type"`
+	OccurredAt time.Time `db:"occurred_at"`
+	userRow
+}
+
+// ListAfter returns up to limit events with a revision greater than
+// revision, oldest first.
+func (r *userEventRepository) ListAfter(ctx context.Context, revision int64, limit int) ([]domain.UserEvent, error) {
+	// Turning the snapshot back into a users row lets userColumns read it
+	// exactly like the table itself.
+	query := `
+		SELECT e.revision, e.type, e.occurred_at, ` + userColumns + `
+		FROM user_events e
+		CROSS JOIN LATERAL jsonb_populate_record(NULL::users, e.snapshot) AS users
+		WHERE e.revision > $1
+		ORDER BY e.revision
+		LIMIT $2`
+	var rows []userEventRow
+	if err := r.db.SelectContext(ctx, &rows, query, revision, limit); err != nil {
+		return nil, domain.Internal("failed to list user events", err)
+	}
+
+	events := make([]domain.UserEvent, len(rows))
+	for i, row := range rows {
+		user, err := r.enc.open(&row.userRow)
+		if err != nil {
+			return nil, err
+		}
+		events[i] = domain.UserEvent{
+			Revision:   row.Revision,
+			Type:       row.Type,
+			User:       user,
+			OccurredAt: row.OccurredAt,
+		}
+	}
+	return events, nil
+}
+
+// Revisions returns the oldest revision still stored and the latest one
+// committed. When no events are stored, oldest is latest + 1.
+func (r *userEventRepository) Revisions(ctx context.Context) (int64, int64, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, 0, domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	// Waits for writers that have taken a revision but not committed it yet.
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock_shared($1)`, userEventsLock); err != nil {
+		return 0, 0, domain.Internal("failed to lock user events", err)
+	}
+	var revisions struct {
+		Oldest sql.NullInt64 `db:"oldest"`
+		Latest int64         `db:"latest"`
+	}
+	query := `
+		SELECT (SELECT MIN(revision) FROM user_events) AS oldest,
+		       CASE WHEN is_called THEN last_value ELSE 0 END AS latest
+		FROM user_events_revision_seq`
+	if err := tx.GetContext(ctx, &revisions, query); err != nil {
+		return 0, 0, domain.Internal("failed to get user event revisions", err)
+	}
+	if !revisions.Oldest.Valid {
+		return revisions.Latest + 1, revisions.Latest, nil
+	}
+	return revisions.Oldest.Int64, revisions.Latest, nil
+}
+
+// Purge deletes events that occurred before before and returns how many
+// were removed.
+func (r *userEventRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM user_events WHERE occurred_at < $1`, before)
+	if err != nil {
+		return 0, domain.Internal("failed to purge user events", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, domain.Internal("failed to check rows affected", err)
+	}
+	return purged, nil
+}
+
+// recordUserEvent stores an event with the current state of the user and
+// notifies UserEventsChannel once tx commits. The advisory lock is held until
+// then, so revisions are committed in order and a watcher that has seen a
+// revision never finds a smaller one later.
+//
+// The lock serializes the commits of all user changes: at most one
+// transaction at a time sits between taking a revision and committing it,
+// which caps user writes at roughly one per commit round trip (a few
+// thousand per second with a local disk, far fewer with synchronous
+// replication). Callers therefore record the event as the last statement
+// before committing, after all other work of the transaction is done.
+func recordUserEvent(ctx context.Context, tx *sqlx.Tx, eventType string, userID int) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, userEventsLock); err != nil {
+		return domain.Internal("failed to lock user events", err)
+	}
+
+	var revision int64
+	query := `
+		INSERT INTO user_events (type, user_id, snapshot)
+		SELECT $1, id, ` + userSnapshot + `
+		FROM users
+		WHERE id = $2
+		RETURNING revision`
+	if err := tx.GetContext(ctx, &revision, query, eventType, userID); err != nil {
+		return domain.Internal("failed to record user event", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, UserEventsChannel, strconv.FormatInt(revision, 10)); err != nil {
+		return domain.Internal("failed to notify user event", err)
+	}
+	return nil
+}
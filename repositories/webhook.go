@@ -0,0 +1,318 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+	"user-srv/domain"
+)
+
+type WebhookRepository interface {
+	Create(ctx context.Context, webhook *domain.Webhook) error
+	Get(ctx context.Context, id int) (*domain.Webhook, error)
+	List(ctx context.Context) ([]domain.Webhook, error)
+	Update(ctx context.Context, webhook *domain.Webhook) error
+	Delete(ctx context.Context, id int) error
+	Enqueue(ctx context.Context, messageID int64, userID int, eventType string, payload []byte) error
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error)
+	FinishAttempt(ctx context.Context, attempt *domain.WebhookAttempt, status string, retryIn time.Duration) error
+	ListDeliveries(ctx context.Context, query domain.WebhookDeliveryQuery) ([]domain.WebhookDelivery, error)
+	ReplayDelivery(ctx context.Context, id int64) (*domain.WebhookDelivery, error)
+	PurgeDeliveries(ctx context.Context, deliveredBefore time.Time) (int64, error)
+}
+
+type webhookRepository struct {
+	db  *sqlx.DB
+	enc *FieldEncryption
+}
+
+func NewWebhookRepository(db *sqlx.DB, enc *FieldEncryption) WebhookRepository {
+	return &webhookRepository{db: db, enc: enc}
+}
+
+// webhookRow is a webhook as stored, with event types as a Postgres array.
+type webhookRow struct {
+	ID         int            `db:"id"`
+	URL        string         `db:"url"`
+	EventTypes pq.StringArray `db:"event_types"`
+	Secret     string         `db:"secret"`
+	Active     bool           `db:"active"`
+	CreatedAt  time.Time      `db:"created_at"`
+	UpdatedAt  time.Time      `db:"updated_at"`
+}
+
+func (row *webhookRow) webhook() domain.Webhook {
+	return domain.Webhook{
+		ID:         row.ID,
+		URL:        row.URL,
+		EventTypes: []string(row.EventTypes),
+		Secret:     row.Secret,
+		Active:     row.Active,
+		CreatedAt:  row.CreatedAt,
+		UpdatedAt:  row.UpdatedAt,
+	}
+}
+
+const webhookColumns = "id, url, event_types, secret, active, created_at, updated_at"
+
+const webhookDeliveryColumns = "id, webhook_id, message_id, event_type, payload, status, attempts, " +
+	"last_status_code, last_error, next_attempt_at, created_at, delivered_at"
+
+func (r *webhookRepository) Create(ctx context.Context, webhook *domain.Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, event_types, secret, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at`
+	err := r.db.QueryRowxContext(ctx, query, webhook.URL, pq.Array(webhook.EventTypes), webhook.Secret, webhook.Active).
+		Scan(&webhook.ID, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return domain.Internal("failed to create webhook", err)
+	}
+	return nil
+}
+
+func (r *webhookRepository) Get(ctx context.Context, id int) (*domain.Webhook, error) {
+	var row webhookRow
+	if err := r.db.GetContext(ctx, &row, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("webhook with id %d not found", id)
+		}
+		return nil, domain.Internal("failed to get webhook", err)
+	}
+	webhook := row.webhook()
+	return &webhook, nil
+}
+
+func (r *webhookRepository) List(ctx context.Context) ([]domain.Webhook, error) {
+	var rows []webhookRow
+	if err := r.db.SelectContext(ctx, &rows, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`); err != nil {
+		return nil, domain.Internal("failed to list webhooks", err)
+	}
+	webhooks := make([]domain.Webhook, len(rows))
+	for i := range rows {
+		webhooks[i] = rows[i].webhook()
+	}
+	return webhooks, nil
+}
+
+func (r *webhookRepository) Update(ctx context.Context, webhook *domain.Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $2, event_types = $3, secret = $4, active = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING created_at, updated_at`
+	err := r.db.QueryRowxContext(ctx, query, webhook.ID, webhook.URL, pq.Array(webhook.EventTypes), webhook.Secret, webhook.Active).
+		Scan(&webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.NotFound("webhook with id %d not found", webhook.ID)
+		}
+		return domain.Internal("failed to update webhook", err)
+	}
+	return nil
+}
+
+// Delete removes the webhook together with its deliveries.
+func (r *webhookRepository) Delete(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return domain.Internal("failed to delete webhook", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return domain.Internal("failed to check rows affected", err)
+	}
+	if rowsAffected == 0 {
+		return domain.NotFound("webhook with id %d not found", id)
+	}
+	return nil
+}
+
+// Enqueue creates a delivery of the message for every active webhook
+// subscribed to eventType. A message enqueued again creates no duplicates.
+// The payload, which is about the user userID, is stored sealed.
+func (r *webhookRepository) Enqueue(ctx context.Context, messageID int64, userID int, eventType string, payload []byte) error {
+	payload, err := r.enc.sealPayload(userID, payload)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, message_id, event_type, payload)
+		SELECT id, $1, $2, $3
+		FROM webhooks
+		WHERE active AND (cardinality(event_types) = 0 OR $2 = ANY (event_types))
+		ON CONFLICT (webhook_id, message_id) DO NOTHING`
+	if _, err := r.db.ExecContext(ctx, query, messageID, eventType, payload); err != nil {
+		return domain.Internal("failed to enqueue webhook deliveries", err)
+	}
+	return nil
+}
+
+// ClaimDeliveries leases up to limit pending deliveries of active webhooks
+// that are due, oldest first. Deliveries whose lease runs out are claimed
+// again.
+func (r *webhookRepository) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET locked_until = CURRENT_TIMESTAMP + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT d.id
+			FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id AND w.active
+			WHERE d.status = 'pending'
+			  AND d.next_attempt_at <= CURRENT_TIMESTAMP
+			  AND (d.locked_until IS NULL OR d.locked_until < CURRENT_TIMESTAMP)
+			ORDER BY d.next_attempt_at, d.id
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED)
+		RETURNING ` + webhookDeliveryColumns
+	var deliveries []domain.WebhookDelivery
+	if err := r.db.SelectContext(ctx, &deliveries, query, limit, lease.Milliseconds()); err != nil {
+		return nil, domain.Internal("failed to claim webhook deliveries", err)
+	}
+	if err := r.openDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// FinishAttempt logs the attempt and moves its delivery to status. Pending
+// deliveries are tried again retryIn from now.
+func (r *webhookRepository) FinishAttempt(ctx context.Context, attempt *domain.WebhookAttempt, status string, retryIn time.Duration) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return domain.Internal("failed to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO webhook_delivery_attempts (delivery_id, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4)
+		RETURNING attempted_at`
+	if err := tx.GetContext(ctx, &attempt.AttemptedAt, query, attempt.DeliveryID, attempt.StatusCode, attempt.Error, attempt.DurationMS); err != nil {
+		return domain.Internal("failed to log webhook attempt", err)
+	}
+
+	query = `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, last_status_code = $3, last_error = $4,
+		    next_attempt_at = CURRENT_TIMESTAMP + $5 * INTERVAL '1 millisecond', locked_until = NULL,
+		    delivered_at = CASE WHEN $2 = 'succeeded' THEN CURRENT_TIMESTAMP END
+		WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, attempt.DeliveryID, status, attempt.StatusCode, attempt.Error, retryIn.Milliseconds())
+	if err != nil {
+		return domain.Internal("failed to update webhook delivery", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.Internal("failed to commit transaction", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns a page of deliveries of a webhook, newest first,
+// each with its attempt log.
+func (r *webhookRepository) ListDeliveries(ctx context.Context, query domain.WebhookDeliveryQuery) ([]domain.WebhookDelivery, error) {
+	conditions := []string{"webhook_id = $1"}
+	args := []interface{}{query.WebhookID}
+	if query.Status != "" {
+		args = append(args, query.Status)
+		conditions = append(conditions, "status = $2")
+	}
+	if query.Before > 0 {
+		args = append(args, query.Before)
+		conditions = append(conditions, "id < $"+strconv.Itoa(len(args)))
+	}
+	args = append(args, query.Limit)
+	listQuery := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries` + whereClause(conditions) + `
+		ORDER BY id DESC
+		LIMIT $` + strconv.Itoa(len(args))
+
+	var deliveries []domain.WebhookDelivery
+	if err := r.db.SelectContext(ctx, &deliveries, listQuery, args...); err != nil {
+		return nil, domain.Internal("failed to list webhook deliveries", err)
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+	if err := r.openDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(deliveries))
+	index := make(map[int64]int, len(deliveries))
+	for i, delivery := range deliveries {
+		ids[i] = delivery.ID
+		index[delivery.ID] = i
+	}
+	var attempts []domain.WebhookAttempt
+	attemptsQuery := `
+		SELECT delivery_id, status_code, error, duration_ms, attempted_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = ANY ($1)
+		ORDER BY id`
+	if err := r.db.SelectContext(ctx, &attempts, attemptsQuery, pq.Array(ids)); err != nil {
+		return nil, domain.Internal("failed to list webhook attempts", err)
+	}
+	for _, attempt := range attempts {
+		delivery := &deliveries[index[attempt.DeliveryID]]
+		delivery.Log = append(delivery.Log, attempt)
+	}
+	return deliveries, nil
+}
+
+// ReplayDelivery schedules a failed delivery to be tried again right away
+// with a fresh set of attempts. Its log is kept.
+func (r *webhookRepository) ReplayDelivery(ctx context.Context, id int64) (*domain.WebhookDelivery, error) {
+	delivery := &domain.WebhookDelivery{}
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP, locked_until = NULL
+		WHERE id = $1 AND status = 'failed'
+		RETURNING ` + webhookDeliveryColumns
+	if err := r.db.GetContext(ctx, delivery, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NotFound("failed webhook delivery with id %d not found", id)
+		}
+		return nil, domain.Internal("failed to replay webhook delivery", err)
+	}
+	payload, err := r.enc.openPayload(delivery.Payload)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = payload
+	return delivery, nil
+}
+
+// openDeliveries decrypts the payloads of deliveries in place.
+func (r *webhookRepository) openDeliveries(deliveries []domain.WebhookDelivery) error {
+	for i := range deliveries {
+		payload, err := r.enc.openPayload(deliveries[i].Payload)
+		if err != nil {
+			return err
+		}
+		deliveries[i].Payload = payload
+	}
+	return nil
+}
+
+// PurgeDeliveries deletes deliveries that succeeded before deliveredBefore
+// and returns how many were removed. Failed ones are kept for replay.
+func (r *webhookRepository) PurgeDeliveries(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	query := `DELETE FROM webhook_deliveries WHERE status = 'succeeded' AND delivered_at < $1`
+	result, err := r.db.ExecContext(ctx, query, deliveredBefore)
+	if err != nil {
+		return 0, domain.Internal("failed to purge webhook deliveries", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, domain.Internal("failed to check rows affected", err)
+	}
+	return purged, nil
+}
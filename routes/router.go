@@ -13,19 +13,56 @@ func SetRoutes(userService services.UserService) *chi.Mux {
 	r := chi.NewRouter()
 
 	userHandler := handlers.NewUserHandler(userService)
+	r.Use(userHandler.ClientInfoMiddleware)
 
 	r.Get("/swagger/*", httpSwagger.Handler(
 		httpSwagger.URL("http://localhost:8080/swagger/doc.json"), // Полный URL
 	))
 
 	r.Post("/users", userHandler.Create)
-	r.Get("/users/{id}", userHandler.ByID)
-	r.Get("/users", userHandler.All)
-	r.Put("/users/{id}", userHandler.Update)
-	r.Delete("/users/{id}", userHandler.Delete)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Get("/users/search", userHandler.Search)
+	r.With(userHandler.OptionalAuthMiddleware).Get("/users/{id}", userHandler.ByID)
+	r.With(userHandler.OptionalAuthMiddleware).Get("/users", userHandler.All)
 	r.Post("/login", userHandler.Login)
+	r.Post("/login/mfa", userHandler.CompleteMFALogin)
+	r.Post("/token/refresh", userHandler.RefreshToken)
+	r.Post("/password/forgot", userHandler.ForgotPassword)
+	r.Post("/password/reset", userHandler.ResetPassword)
+	r.Get("/verify-email", userHandler.VerifyEmail)
+	r.Get("/.well-known/jwks.json", userHandler.JWKS)
 
+	r.With(userHandler.AuthMiddleware).Post("/logout", userHandler.Logout)
+	r.With(userHandler.AuthMiddleware).Post("/logout-all", userHandler.LogoutAll)
 	r.With(userHandler.AuthMiddleware).Get("/users/me", userHandler.CurrentUser)
+	r.With(userHandler.AuthMiddleware).Get("/users/me/sessions", userHandler.Sessions)
+	r.With(userHandler.AuthMiddleware).Delete("/users/me/sessions/{id}", userHandler.RevokeSession)
+	r.With(userHandler.AuthMiddleware).Post("/users/me/mfa/totp", userHandler.EnrollTOTP)
+	r.With(userHandler.AuthMiddleware).Post("/users/me/mfa/totp/confirm", userHandler.ConfirmTOTP)
+	r.With(userHandler.AuthMiddleware).Post("/users/me/mfa/totp/disable", userHandler.DisableTOTP)
+	r.With(userHandler.AuthMiddleware).Post("/users/me/mfa/recovery-codes", userHandler.RegenerateRecoveryCodes)
+	r.With(userHandler.AuthMiddleware).Get("/users/me/export", userHandler.ExportMe)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireSelfOrAdmin).Put("/users/{id}", userHandler.Update)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireSelfOrAdmin).Patch("/users/{id}", userHandler.Patch)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireSelfOrAdmin).Delete("/users/{id}", userHandler.Delete)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireSelfOrAdmin).Get("/users/{id}/metadata", userHandler.GetMetadata)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireSelfOrAdmin).Patch("/users/{id}/metadata", userHandler.UpdateMetadata)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireSelfOrAdmin).Delete("/users/{id}/metadata/{namespace}/{name}", userHandler.DeleteMetadataKey)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Post("/users/{id}/restore", userHandler.Restore)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Get("/users/{id}/export", userHandler.ExportUser)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Post("/users/{id}/erase", userHandler.EraseUser)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Put("/users/{id}/status", userHandler.SetStatus)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Get("/users/{id}/lockout", userHandler.LoginLockout)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Delete("/users/{id}/lockout", userHandler.Unlock)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Delete("/users/{id}/mfa", userHandler.ResetMFA)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Post("/webhooks", userHandler.CreateWebhook)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Get("/webhooks", userHandler.Webhooks)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Get("/webhooks/{id}", userHandler.Webhook)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Put("/webhooks/{id}", userHandler.UpdateWebhook)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Delete("/webhooks/{id}", userHandler.DeleteWebhook)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Get("/webhooks/{id}/deliveries", userHandler.WebhookDeliveries)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Post("/webhooks/deliveries/{id}/replay", userHandler.ReplayWebhookDelivery)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Get("/audit", userHandler.AuditEvents)
+	r.With(userHandler.AuthMiddleware, userHandler.RequireAdmin).Get("/audit/verify", userHandler.VerifyAudit)
 
 	return r
 }
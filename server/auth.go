@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"user-srv/proto"
+	"user-srv/services"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// publicMethods can be called without an access token, mirroring the
+// unauthenticated REST routes.
+var publicMethods = map[string]bool{
+	proto.UserService_CreateUser_FullMethodName:       true,
+	proto.UserService_Login_FullMethodName:            true,
+	proto.UserService_RefreshToken_FullMethodName:     true,
+	proto.UserService_ForgotPassword_FullMethodName:   true,
+	proto.UserService_ResetPassword_FullMethodName:    true,
+	proto.UserService_VerifyEmail_FullMethodName:      true,
+	proto.UserService_CompleteMfaLogin_FullMethodName: true,
+}
+
+// optionalAuthMethods can be called without an access token too, but
+// authenticate the caller if one is sent, like the REST routes behind
+// UserHandler.OptionalAuthMiddleware.
+var optionalAuthMethods = map[string]bool{
+	proto.UserService_GetUser_FullMethodName:     true,
+	proto.UserService_GetAllUsers_FullMethodName: true,
+}
+
+func AuthUnaryInterceptor(service services.UserService) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if publicMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		if optionalAuthMethods[info.FullMethod] && !hasAuthorization(ctx) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func AuthStreamInterceptor(service services.UserService) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if publicMethods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+		if optionalAuthMethods[info.FullMethod] && !hasAuthorization(ss.Context()) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticate(ss.Context(), service)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func hasAuthorization(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	return ok && len(md.Get("authorization")) > 0
+}
+
+// authenticate validates the bearer token from the "authorization" metadata
+// with the same rules as UserHandler.AuthMiddleware.
+func authenticate(ctx context.Context, service services.UserService) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("authorization")) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+
+	parts := strings.Split(md.Get("authorization")[0], " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization metadata format")
+	}
+
+	identity, err := service.Authenticate(ctx, parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return services.ContextWithIdentity(ctx, identity), nil
+}
+
+// contextStream overrides the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"user-srv/domain"
+	"user-srv/proto"
+	"user-srv/services"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenService accepts only the token "valid", for user 7.
+type tokenService struct {
+	services.UserService
+}
+
+func (s *tokenService) Authenticate(ctx context.Context, accessToken string) (*domain.Identity, error) {
+	if accessToken != "valid" {
+		return nil, domain.Unauthenticated("invalid token")
+	}
+	return &domain.Identity{UserID: 7, Role: domain.RoleUser}, nil
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	interceptor := AuthUnaryInterceptor(&tokenService{})
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		wantCode      codes.Code
+		wantIdentity  bool
+	}{
+		{"public", proto.UserService_Login_FullMethodName, "", codes.OK, false},
+		{"missing token", proto.UserService_GetCurrentUser_FullMethodName, "", codes.Unauthenticated, false},
+		{"not a bearer token", proto.UserService_GetCurrentUser_FullMethodName, "Basic valid", codes.Unauthenticated, false},
+		{"invalid token", proto.UserService_GetCurrentUser_FullMethodName, "Bearer forged", codes.Unauthenticated, false},
+		{"valid token", proto.UserService_GetCurrentUser_FullMethodName, "Bearer valid", codes.OK, true},
+		{"optional without token", proto.UserService_GetUser_FullMethodName, "", codes.OK, false},
+		{"optional with token", proto.UserService_GetUser_FullMethodName, "Bearer valid", codes.OK, true},
+		{"optional with invalid token", proto.UserService_GetUser_FullMethodName, "Bearer forged", codes.Unauthenticated, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+			var identity *domain.Identity
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				identity, _ = services.IdentityFromContext(ctx)
+				return nil, nil
+			}
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if code := status.Code(toStatus(ctx, err)); code != tt.wantCode {
+				t.Fatalf("code = %s, want %s", code, tt.wantCode)
+			}
+			if (identity != nil) != tt.wantIdentity {
+				t.Errorf("identity = %+v, want one: %v", identity, tt.wantIdentity)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"net"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/services"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientInfoUnaryInterceptor records the caller's IP address, user agent and
+// request id in the context, like UserHandler.ClientInfoMiddleware does for
+// HTTP. The request id is sent back in the "x-request-id" header.
+func ClientInfoUnaryInterceptor(cfg *config.Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(withClientInfo(ctx, cfg), req)
+	}
+}
+
+func ClientInfoStreamInterceptor(cfg *config.Config) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: withClientInfo(ss.Context(), cfg)})
+	}
+}
+
+func withClientInfo(ctx context.Context, cfg *config.Config) context.Context {
+	info := &domain.ClientInfo{}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		info.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(info.IP); err == nil {
+			info.IP = host
+		}
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			info.UserAgent = values[0]
+		}
+		info.IP = services.ClientIP(info.IP, md.Get("x-forwarded-for"), cfg.TrustedProxyHops)
+		if values := md.Get("x-request-id"); len(values) > 0 && services.IsValidRequestID(values[0]) {
+			info.RequestID = values[0]
+		}
+	}
+	if info.RequestID == "" {
+		info.RequestID = services.NewRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-request-id", info.RequestID))
+
+	return services.ContextWithClientInfo(ctx, info)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"user-srv/domain"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func ErrorUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, toStatus(ctx, err)
+	}
+}
+
+func ErrorStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return toStatus(ss.Context(), handler(srv, ss))
+	}
+}
+
+// toStatus converts a domain error into a gRPC status. Errors that already
+// carry a status are returned unchanged. Calls that fail because the client
+// went away or ran out of time get the status of the context error, which is
+// expected and not logged; the database driver does not always return the
+// context error itself, so the context of the call is checked as well.
+func toStatus(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+
+	var domainErr *domain.Error
+	if !errors.As(err, &domainErr) {
+		log.Printf("Unexpected error: %v", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	code := grpcCode(domainErr.Kind)
+	if code == codes.Internal {
+		log.Printf("Internal error: %s: %v", domainErr.Message, domainErr.Cause)
+	}
+	st := status.New(code, domainErr.Message)
+	if domainErr.RetryAfter > 0 {
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(domainErr.RetryAfter)}); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+func grpcCode(kind error) codes.Code {
+	switch kind {
+	case domain.ErrNotFound:
+		return codes.NotFound
+	case domain.ErrAlreadyExists:
+		return codes.AlreadyExists
+	case domain.ErrValidation:
+		return codes.InvalidArgument
+	case domain.ErrUnauthenticated:
+		return codes.Unauthenticated
+	case domain.ErrPermissionDenied:
+		return codes.PermissionDenied
+	case domain.ErrTooManyRequests:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
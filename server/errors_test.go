@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"user-srv/domain"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatusOfContextErrors(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want codes.Code
+	}{
+		{"canceled", context.Background(), context.Canceled, codes.Canceled},
+		{"deadline", context.Background(), context.DeadlineExceeded, codes.DeadlineExceeded},
+		{"wrapped", context.Background(), domain.Internal("failed to list users", fmt.Errorf("scan: %w", context.Canceled)), codes.Canceled},
+		{"driver error after cancel", canceled, domain.Internal("failed to list users", errors.New("pq: canceling statement due to user request")), codes.Canceled},
+		{"internal", context.Background(), domain.Internal("failed to list users", errors.New("connection refused")), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(toStatus(tt.ctx, tt.err)); got != tt.want {
+				t.Errorf("toStatus = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToStatusOfDomainErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{domain.NotFound("user with id %d not found", 1), codes.NotFound},
+		{domain.AlreadyExists("user already exists"), codes.AlreadyExists},
+		{domain.Validation("name cannot be empty"), codes.InvalidArgument},
+		{domain.Unauthenticated("invalid token"), codes.Unauthenticated},
+		{domain.PermissionDenied("admin role required"), codes.PermissionDenied},
+		{domain.TooManyRequests(time.Minute, "too many attempts"), codes.ResourceExhausted},
+		{domain.Internal("failed to get user", errors.New("connection refused")), codes.Internal},
+		{errors.New("unexpected"), codes.Internal},
+	}
+	for _, tt := range tests {
+		st := status.Convert(toStatus(context.Background(), tt.err))
+		if st.Code() != tt.want {
+			t.Errorf("toStatus(%v) = %s, want %s", tt.err, st.Code(), tt.want)
+		}
+		if strings.Contains(st.Message(), "connection refused") {
+			t.Errorf("toStatus(%v) leaks the cause: %q", tt.err, st.Message())
+		}
+	}
+
+	st := status.Convert(toStatus(context.Background(), domain.TooManyRequests(90*time.Second, "too many attempts")))
+	var retry *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retry = info
+		}
+	}
+	if retry == nil || retry.RetryDelay.AsDuration() != 90*time.Second {
+		t.Errorf("details = %v, want retry after 90s", st.Details())
+	}
+}
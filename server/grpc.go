@@ -2,13 +2,20 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net"
+	"time"
+	"user-srv/config"
 	"user-srv/domain"
 	"user-srv/proto"
 	"user-srv/services"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type GRPCServer struct {
@@ -25,16 +32,13 @@ func (s *GRPCServer) CreateUser(ctx context.Context, req *proto.CreateUserReques
 		Name:     req.Name,
 		Email:    req.Email,
 		Password: req.Password,
+		Profile: toProfile(req.FirstName, req.LastName, req.DisplayName, req.PhoneNumber,
+			req.Locale, req.Timezone, req.AvatarUrl, req.DateOfBirth),
 	}
 	if err := s.service.Create(ctx, user); err != nil {
 		return nil, err
 	}
-	return &proto.UserResponse{
-		Id:        int32(user.ID),
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
-	}, nil
+	return toUserResponse(user), nil
 }
 
 func (s *GRPCServer) GetUser(ctx context.Context, req *proto.GetUserRequest) (*proto.UserResponse, error) {
@@ -42,76 +46,826 @@ func (s *GRPCServer) GetUser(ctx context.Context, req *proto.GetUserRequest) (*p
 	if err != nil {
 		return nil, err
 	}
-	return &proto.UserResponse{
-		Id:        int32(user.ID),
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
-	}, nil
+	return toUserResponse(user), nil
 }
 
-func (s *GRPCServer) GetAllUsers(ctx context.Context, _ *proto.GetAllUsersRequest) (*proto.GetAllUsersResponse, error) {
-	users, err := s.service.GetAll(ctx)
+func (s *GRPCServer) GetAllUsers(ctx context.Context, req *proto.GetAllUsersRequest) (*proto.GetAllUsersResponse, error) {
+	query := domain.UserQuery{
+		Limit:       int(req.Limit),
+		PageToken:   req.PageToken,
+		EmailPrefix: req.EmailPrefix,
+		NamePrefix:  req.NamePrefix,
+		SortBy:      req.SortBy,
+		Descending:  req.Descending,
+	}
+	if req.CreatedAfter != nil {
+		createdAfter := req.CreatedAfter.AsTime()
+		query.CreatedAfter = &createdAfter
+	}
+	if req.CreatedBefore != nil {
+		createdBefore := req.CreatedBefore.AsTime()
+		query.CreatedBefore = &createdBefore
+	}
+	metadataFilter, err := toMetadataFilter(req.MetadataKey, req.MetadataValue)
+	if err != nil {
+		return nil, err
+	}
+	query.Metadata = metadataFilter
+
+	page, err := s.service.List(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	var resp []*proto.UserResponse
-	for _, user := range users {
-		resp = append(resp, &proto.UserResponse{
-			Id:        int32(user.ID),
-			Name:      user.Name,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt,
+	for _, user := range page.Users {
+		resp = append(resp, toUserResponse(&user))
+	}
+	return &proto.GetAllUsersResponse{
+		Users:         resp,
+		NextPageToken: page.NextPageToken,
+		TotalCount:    int64(page.TotalCount),
+	}, nil
+}
+
+func (s *GRPCServer) StreamUsers(req *proto.StreamUsersRequest, stream proto.UserService_StreamUsersServer) error {
+	ctx := stream.Context()
+	if err := services.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	query := domain.UserQuery{
+		Limit:       int(req.BatchSize),
+		EmailPrefix: req.EmailPrefix,
+		NamePrefix:  req.NamePrefix,
+	}
+	if req.CreatedAfter != nil {
+		createdAfter := req.CreatedAfter.AsTime()
+		query.CreatedAfter = &createdAfter
+	}
+	if req.CreatedBefore != nil {
+		createdBefore := req.CreatedBefore.AsTime()
+		query.CreatedBefore = &createdBefore
+	}
+	metadataFilter, err := toMetadataFilter(req.MetadataKey, req.MetadataValue)
+	if err != nil {
+		return err
+	}
+	query.Metadata = metadataFilter
+
+	// Send blocks while the client's flow control window is full, which
+	// holds back reading the next batch.
+	err = s.service.StreamUsers(ctx, query, func(user *domain.User) error {
+		return stream.Send(toUserResponse(user))
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	return err
+}
+
+func (s *GRPCServer) WatchUsers(req *proto.WatchUsersRequest, stream proto.UserService_WatchUsersServer) error {
+	ctx := stream.Context()
+	if err := services.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	err := s.service.WatchUsers(ctx, req.FromRevision, func(event *domain.UserEvent) error {
+		return stream.Send(&proto.UserEvent{
+			Revision:   event.Revision,
+			Type:       event.Type,
+			User:       toUserResponse(&event.User),
+			OccurredAt: timestamppb.New(event.OccurredAt),
+		})
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	return err
+}
+
+func (s *GRPCServer) SearchUsers(ctx context.Context, req *proto.SearchUsersRequest) (*proto.SearchUsersResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	page, err := s.service.SearchUsers(ctx, domain.UserSearch{
+		Query:     req.Query,
+		Limit:     int(req.Limit),
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.SearchUsersResponse{
+		NextPageToken: page.NextPageToken,
+		TotalCount:    int64(page.TotalCount),
+	}
+	for _, hit := range page.Hits {
+		resp.Hits = append(resp.Hits, &proto.SearchHit{
+			User:       toUserResponse(&hit.User),
+			Rank:       hit.Rank,
+			Highlights: hit.Highlights,
 		})
 	}
-	return &proto.GetAllUsersResponse{Users: resp}, nil
+	return resp, nil
 }
 
 func (s *GRPCServer) UpdateUser(ctx context.Context, req *proto.UpdateUserRequest) (*proto.UserResponse, error) {
+	if err := services.AuthorizeUser(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	if req.UpdateMask != nil {
+		return s.patchUser(ctx, req)
+	}
 	user := &domain.User{
 		ID:       int(req.Id),
 		Name:     req.Name,
 		Email:    req.Email,
 		Password: req.Password,
+		Profile: toProfile(req.FirstName, req.LastName, req.DisplayName, req.PhoneNumber,
+			req.Locale, req.Timezone, req.AvatarUrl, req.DateOfBirth),
 	}
 	if err := s.service.Update(ctx, user); err != nil {
 		return nil, err
 	}
-	return &proto.UserResponse{
-		Id:        int32(user.ID),
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
-	}, nil
+	return toUserResponse(user), nil
+}
+
+func (s *GRPCServer) patchUser(ctx context.Context, req *proto.UpdateUserRequest) (*proto.UserResponse, error) {
+	var patch domain.UserPatch
+	for _, path := range req.UpdateMask.GetPaths() {
+		switch path {
+		case "name":
+			patch.Name = &req.Name
+		case "email":
+			patch.Email = &req.Email
+		case "password":
+			patch.Password = &req.Password
+		case "first_name":
+			patch.FirstName = &req.FirstName
+		case "last_name":
+			patch.LastName = &req.LastName
+		case "display_name":
+			patch.DisplayName = &req.DisplayName
+		case "phone_number":
+			patch.PhoneNumber = &req.PhoneNumber
+		case "locale":
+			patch.Locale = &req.Locale
+		case "timezone":
+			patch.Timezone = &req.Timezone
+		case "avatar_url":
+			patch.AvatarURL = &req.AvatarUrl
+		case "date_of_birth":
+			patch.DateOfBirth = &req.DateOfBirth
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported update_mask path %q", path)
+		}
+	}
+
+	user, err := s.service.Patch(ctx, int(req.Id), patch)
+	if err != nil {
+		return nil, err
+	}
+	return toUserResponse(user), nil
 }
 
 func (s *GRPCServer) DeleteUser(ctx context.Context, req *proto.DeleteUserRequest) (*proto.DeleteUserResponse, error) {
+	if err := services.AuthorizeUser(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
 	if err := s.service.Delete(ctx, int(req.Id)); err != nil {
 		return nil, err
 	}
 	return &proto.DeleteUserResponse{}, nil
 }
 
+func (s *GRPCServer) RestoreUser(ctx context.Context, req *proto.RestoreUserRequest) (*proto.UserResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	user, err := s.service.Restore(ctx, int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+	return toUserResponse(user), nil
+}
+
+func (s *GRPCServer) ExportUser(ctx context.Context, req *proto.ExportUserRequest) (*proto.UserExport, error) {
+	id := int(req.Id)
+	if id == 0 {
+		identity, ok := services.IdentityFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		id = identity.UserID
+	} else if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	export, err := s.service.ExportUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.UserExport{
+		ExportedAt: timestamppb.New(export.ExportedAt),
+		User:       toUserResponse(&export.User),
+	}
+	if export.MFAEnabledAt != nil {
+		resp.MfaEnabledAt = timestamppb.New(*export.MFAEnabledAt)
+	}
+	for _, session := range export.Sessions {
+		entry := &proto.Session{
+			Id:         session.ID,
+			UserAgent:  session.UserAgent,
+			Ip:         session.IP,
+			CreatedAt:  timestamppb.New(session.CreatedAt),
+			LastSeenAt: timestamppb.New(session.LastSeenAt),
+		}
+		if session.RevokedAt != nil {
+			entry.RevokedAt = timestamppb.New(*session.RevokedAt)
+		}
+		resp.Sessions = append(resp.Sessions, entry)
+	}
+	for i := range export.AuditEvents {
+		resp.AuditEvents = append(resp.AuditEvents, toProtoAuditEvent(&export.AuditEvents[i]))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) EraseUser(ctx context.Context, req *proto.EraseUserRequest) (*proto.EraseUserResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.service.EraseUser(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	return &proto.EraseUserResponse{}, nil
+}
+
+func (s *GRPCServer) SetUserStatus(ctx context.Context, req *proto.SetUserStatusRequest) (*proto.UserResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	user, err := s.service.SetStatus(ctx, int(req.Id), req.Status, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return toUserResponse(user), nil
+}
+
+func (s *GRPCServer) GetUserMetadata(ctx context.Context, req *proto.GetUserMetadataRequest) (*proto.UserMetadataResponse, error) {
+	if err := services.AuthorizeUser(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	metadata, err := s.service.GetMetadata(ctx, int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+	return &proto.UserMetadataResponse{Metadata: toProtoMetadata(metadata)}, nil
+}
+
+func (s *GRPCServer) UpdateUserMetadata(ctx context.Context, req *proto.UpdateUserMetadataRequest) (*proto.UserMetadataResponse, error) {
+	if err := services.AuthorizeUser(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	changes := domain.Metadata{}
+	for key, value := range req.Metadata.GetFields() {
+		raw, err := value.MarshalJSON()
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid value for metadata key %q: %v", key, err)
+		}
+		changes[key] = raw
+	}
+	metadata, err := s.service.UpdateMetadata(ctx, int(req.Id), changes)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.UserMetadataResponse{Metadata: toProtoMetadata(metadata)}, nil
+}
+
+func (s *GRPCServer) DeleteUserMetadataKey(ctx context.Context, req *proto.DeleteUserMetadataKeyRequest) (*proto.DeleteUserMetadataKeyResponse, error) {
+	if err := services.AuthorizeUser(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	if err := s.service.DeleteMetadataKey(ctx, int(req.Id), req.Key); err != nil {
+		return nil, err
+	}
+	return &proto.DeleteUserMetadataKeyResponse{}, nil
+}
+
 func (s *GRPCServer) Login(ctx context.Context, req *proto.LoginRequest) (*proto.LoginResponse, error) {
-	token, err := s.service.Login(ctx, req.Email, req.Password)
+	result, err := s.service.Login(ctx, req.Email, req.Password)
+	if err != nil {
+		return nil, err
+	}
+	if result.MFAChallenge != "" {
+		return &proto.LoginResponse{MfaRequired: true, MfaToken: result.MFAChallenge}, nil
+	}
+	return toLoginResponse(result.Tokens), nil
+}
+
+func (s *GRPCServer) CompleteMfaLogin(ctx context.Context, req *proto.CompleteMfaLoginRequest) (*proto.LoginResponse, error) {
+	tokens, err := s.service.CompleteMFALogin(ctx, req.MfaToken, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	return toLoginResponse(tokens), nil
+}
+
+func (s *GRPCServer) EnrollTotp(ctx context.Context, _ *proto.EnrollTotpRequest) (*proto.EnrollTotpResponse, error) {
+	identity, ok := services.IdentityFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	enrollment, err := s.service.EnrollTOTP(ctx, identity.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.EnrollTotpResponse{
+		Secret:     enrollment.Secret,
+		OtpauthUri: enrollment.OTPAuthURI,
+	}, nil
+}
+
+func (s *GRPCServer) ConfirmTotp(ctx context.Context, req *proto.ConfirmTotpRequest) (*proto.ConfirmTotpResponse, error) {
+	identity, ok := services.IdentityFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	recoveryCodes, err := s.service.ConfirmTOTP(ctx, identity.UserID, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.ConfirmTotpResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s *GRPCServer) DisableTotp(ctx context.Context, req *proto.DisableTotpRequest) (*proto.DisableTotpResponse, error) {
+	identity, ok := services.IdentityFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if err := s.service.DisableTOTP(ctx, identity.UserID, req.Code); err != nil {
+		return nil, err
+	}
+	return &proto.DisableTotpResponse{}, nil
+}
+
+func (s *GRPCServer) RegenerateRecoveryCodes(ctx context.Context, req *proto.RegenerateRecoveryCodesRequest) (*proto.ConfirmTotpResponse, error) {
+	identity, ok := services.IdentityFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	recoveryCodes, err := s.service.RegenerateRecoveryCodes(ctx, identity.UserID, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.ConfirmTotpResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s *GRPCServer) ResetMfa(ctx context.Context, req *proto.ResetMfaRequest) (*proto.ResetMfaResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.service.ResetMFA(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	return &proto.ResetMfaResponse{}, nil
+}
+
+func (s *GRPCServer) RefreshToken(ctx context.Context, req *proto.RefreshTokenRequest) (*proto.LoginResponse, error) {
+	tokens, err := s.service.RefreshToken(ctx, req.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return toLoginResponse(tokens), nil
+}
+
+func (s *GRPCServer) Logout(ctx context.Context, req *proto.LogoutRequest) (*proto.LogoutResponse, error) {
+	if err := s.service.Logout(ctx, req.RefreshToken); err != nil {
+		return nil, err
+	}
+	return &proto.LogoutResponse{}, nil
+}
+
+func (s *GRPCServer) LogoutAll(ctx context.Context, _ *proto.LogoutAllRequest) (*proto.LogoutAllResponse, error) {
+	if err := s.service.LogoutAll(ctx); err != nil {
+		return nil, err
+	}
+	return &proto.LogoutAllResponse{}, nil
+}
+
+func (s *GRPCServer) ListSessions(ctx context.Context, _ *proto.ListSessionsRequest) (*proto.ListSessionsResponse, error) {
+	identity, ok := services.IdentityFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	sessions, err := s.service.ListSessions(ctx, identity.UserID)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.ListSessionsResponse{}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &proto.Session{
+			Id:         session.ID,
+			UserAgent:  session.UserAgent,
+			Ip:         session.IP,
+			CreatedAt:  timestamppb.New(session.CreatedAt),
+			LastSeenAt: timestamppb.New(session.LastSeenAt),
+			Current:    session.ID == identity.SessionID,
+		})
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) RevokeSession(ctx context.Context, req *proto.RevokeSessionRequest) (*proto.RevokeSessionResponse, error) {
+	identity, ok := services.IdentityFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if err := s.service.RevokeSession(ctx, identity.UserID, req.Id); err != nil {
+		return nil, err
+	}
+	return &proto.RevokeSessionResponse{}, nil
+}
+
+func (s *GRPCServer) CreateWebhook(ctx context.Context, req *proto.CreateWebhookRequest) (*proto.Webhook, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	webhook, err := s.service.CreateWebhook(ctx, &domain.Webhook{
+		URL:        req.Url,
+		EventTypes: req.EventTypes,
+		Secret:     req.Secret,
+		Active:     req.Active == nil || *req.Active,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toProtoWebhook(webhook), nil
+}
+
+func (s *GRPCServer) GetWebhook(ctx context.Context, req *proto.GetWebhookRequest) (*proto.Webhook, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	webhook, err := s.service.GetWebhook(ctx, int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+	return toProtoWebhook(webhook), nil
+}
+
+func (s *GRPCServer) ListWebhooks(ctx context.Context, _ *proto.ListWebhooksRequest) (*proto.ListWebhooksResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	webhooks, err := s.service.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.ListWebhooksResponse{}
+	for i := range webhooks {
+		resp.Webhooks = append(resp.Webhooks, toProtoWebhook(&webhooks[i]))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) UpdateWebhook(ctx context.Context, req *proto.UpdateWebhookRequest) (*proto.Webhook, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	webhook, err := s.service.UpdateWebhook(ctx, &domain.Webhook{
+		ID:         int(req.Id),
+		URL:        req.Url,
+		EventTypes: req.EventTypes,
+		Secret:     req.Secret,
+		Active:     req.Active == nil || *req.Active,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toProtoWebhook(webhook), nil
+}
+
+func (s *GRPCServer) DeleteWebhook(ctx context.Context, req *proto.DeleteWebhookRequest) (*proto.DeleteWebhookResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.service.DeleteWebhook(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	return &proto.DeleteWebhookResponse{}, nil
+}
+
+func (s *GRPCServer) ListWebhookDeliveries(ctx context.Context, req *proto.ListWebhookDeliveriesRequest) (*proto.ListWebhookDeliveriesResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	page, err := s.service.ListWebhookDeliveries(ctx, domain.WebhookDeliveryQuery{
+		WebhookID: int(req.WebhookId),
+		Status:    req.Status,
+		Limit:     int(req.Limit),
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.ListWebhookDeliveriesResponse{NextPageToken: page.NextPageToken}
+	for i := range page.Deliveries {
+		resp.Deliveries = append(resp.Deliveries, toProtoWebhookDelivery(&page.Deliveries[i]))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) ReplayWebhookDelivery(ctx context.Context, req *proto.ReplayWebhookDeliveryRequest) (*proto.WebhookDelivery, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	delivery, err := s.service.ReplayWebhookDelivery(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return toProtoWebhookDelivery(delivery), nil
+}
+
+func (s *GRPCServer) ListAuditEvents(ctx context.Context, req *proto.ListAuditEventsRequest) (*proto.ListAuditEventsResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	query := domain.AuditQuery{
+		Action:    req.Action,
+		Limit:     int(req.Limit),
+		PageToken: req.PageToken,
+	}
+	if req.ActorId != nil {
+		actorID := int(*req.ActorId)
+		query.ActorID = &actorID
+	}
+	if req.UserId != nil {
+		userID := int(*req.UserId)
+		query.TargetUserID = &userID
+	}
+	if req.Since != nil {
+		since := req.Since.AsTime()
+		query.Since = &since
+	}
+	if req.Until != nil {
+		until := req.Until.AsTime()
+		query.Until = &until
+	}
+
+	page, err := s.service.ListAuditEvents(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.ListAuditEventsResponse{NextPageToken: page.NextPageToken}
+	for i := range page.Events {
+		resp.Events = append(resp.Events, toProtoAuditEvent(&page.Events[i]))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) VerifyAuditLog(ctx context.Context, req *proto.VerifyAuditLogRequest) (*proto.VerifyAuditLogResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	result, err := s.service.VerifyAuditLog(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &proto.LoginResponse{Token: token}, nil
+	return &proto.VerifyAuditLogResponse{
+		Checked:  result.Checked,
+		BrokenAt: result.BrokenAt,
+		Valid:    result.BrokenAt == nil,
+	}, nil
 }
 
 func (s *GRPCServer) GetCurrentUser(ctx context.Context, req *proto.GetCurrentUserRequest) (*proto.UserResponse, error) {
-	// Пока заглушка, авторизацию по токену позже добавим
-	user, err := s.service.GetByID(ctx, 1)
+	identity, ok := services.IdentityFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	user, err := s.service.GetByID(ctx, identity.UserID)
 	if err != nil {
 		return nil, err
 	}
-	return &proto.UserResponse{
+	return toUserResponse(user), nil
+}
+
+func (s *GRPCServer) ForgotPassword(ctx context.Context, req *proto.ForgotPasswordRequest) (*proto.ForgotPasswordResponse, error) {
+	if err := s.service.ForgotPassword(ctx, req.Email); err != nil {
+		return nil, err
+	}
+	return &proto.ForgotPasswordResponse{}, nil
+}
+
+func (s *GRPCServer) ResetPassword(ctx context.Context, req *proto.ResetPasswordRequest) (*proto.ResetPasswordResponse, error) {
+	if err := s.service.ResetPassword(ctx, req.Token, req.Password); err != nil {
+		return nil, err
+	}
+	return &proto.ResetPasswordResponse{}, nil
+}
+
+func (s *GRPCServer) GetLoginLockout(ctx context.Context, req *proto.GetLoginLockoutRequest) (*proto.LoginLockoutResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	throttle, err := s.service.GetLoginLockout(ctx, int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.LoginLockoutResponse{
+		UserId:   req.Id,
+		Failures: int32(throttle.Failures),
+	}
+	if !throttle.LastFailedAt.IsZero() {
+		resp.LastFailedAt = timestamppb.New(throttle.LastFailedAt)
+	}
+	if throttle.LockedUntil != nil {
+		resp.LockedUntil = timestamppb.New(*throttle.LockedUntil)
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) UnlockUser(ctx context.Context, req *proto.UnlockUserRequest) (*proto.UnlockUserResponse, error) {
+	if err := services.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.service.UnlockUser(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	return &proto.UnlockUserResponse{}, nil
+}
+
+func (s *GRPCServer) VerifyEmail(ctx context.Context, req *proto.VerifyEmailRequest) (*proto.VerifyEmailResponse, error) {
+	if err := s.service.VerifyEmail(ctx, req.Token); err != nil {
+		return nil, err
+	}
+	return &proto.VerifyEmailResponse{}, nil
+}
+
+func toUserResponse(user *domain.User) *proto.UserResponse {
+	resp := &proto.UserResponse{
 		Id:        int32(user.ID),
 		Name:      user.Name,
 		Email:     user.Email,
+		Role:      user.Role,
+		Status:    user.Status,
 		CreatedAt: user.CreatedAt,
-	}, nil
+
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		DisplayName: user.DisplayName,
+		PhoneNumber: user.PhoneNumber,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+		AvatarUrl:   user.AvatarURL,
+	}
+	// Metadata is hidden from callers other than the user and admins.
+	if user.Metadata != nil {
+		resp.Metadata = toProtoMetadata(user.Metadata)
+	}
+	if user.DateOfBirth != nil {
+		resp.DateOfBirth = *user.DateOfBirth
+	}
+	if user.EmailVerifiedAt != nil {
+		resp.EmailVerifiedAt = user.EmailVerifiedAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+// toProfile builds a profile from proto fields; an empty date of birth is
+// not set.
+func toProfile(firstName, lastName, displayName, phoneNumber, locale, timezone, avatarURL, dateOfBirth string) domain.Profile {
+	profile := domain.Profile{
+		FirstName:   firstName,
+		LastName:    lastName,
+		DisplayName: displayName,
+		PhoneNumber: phoneNumber,
+		Locale:      locale,
+		Timezone:    timezone,
+		AvatarURL:   avatarURL,
+	}
+	if dateOfBirth != "" {
+		profile.DateOfBirth = &dateOfBirth
+	}
+	return profile
+}
+
+func toProtoMetadata(metadata domain.Metadata) *structpb.Struct {
+	result := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+	for key, raw := range metadata {
+		value := &structpb.Value{}
+		if err := value.UnmarshalJSON(raw); err != nil {
+			log.Printf("Skipping metadata key %q that is not valid JSON: %v", key, err)
+			continue
+		}
+		result.Fields[key] = value
+	}
+	return result
+}
+
+// toMetadataFilter builds the metadata filter of a list request, nil when no
+// key is given.
+func toMetadataFilter(key string, value *structpb.Value) (*domain.MetadataFilter, error) {
+	if key == "" {
+		return nil, nil
+	}
+	filter := &domain.MetadataFilter{Key: key}
+	if value != nil {
+		raw, err := value.MarshalJSON()
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid metadata_value: %v", err)
+		}
+		filter.Value = raw
+	}
+	return filter, nil
+}
+
+func toProtoWebhook(webhook *domain.Webhook) *proto.Webhook {
+	return &proto.Webhook{
+		Id:         int32(webhook.ID),
+		Url:        webhook.URL,
+		EventTypes: webhook.EventTypes,
+		Active:     webhook.Active,
+		CreatedAt:  timestamppb.New(webhook.CreatedAt),
+		UpdatedAt:  timestamppb.New(webhook.UpdatedAt),
+		Secret:     webhook.Secret,
+	}
+}
+
+func toProtoWebhookDelivery(delivery *domain.WebhookDelivery) *proto.WebhookDelivery {
+	resp := &proto.WebhookDelivery{
+		Id:            delivery.ID,
+		WebhookId:     int32(delivery.WebhookID),
+		MessageId:     delivery.MessageID,
+		EventType:     delivery.EventType,
+		Status:        delivery.Status,
+		Attempts:      int32(delivery.Attempts),
+		LastError:     delivery.LastError,
+		NextAttemptAt: timestamppb.New(delivery.NextAttemptAt),
+		CreatedAt:     timestamppb.New(delivery.CreatedAt),
+	}
+	if delivery.LastStatusCode != nil {
+		resp.LastStatusCode = int32(*delivery.LastStatusCode)
+	}
+	if delivery.DeliveredAt != nil {
+		resp.DeliveredAt = timestamppb.New(*delivery.DeliveredAt)
+	}
+	for _, attempt := range delivery.Log {
+		entry := &proto.WebhookAttempt{
+			Error:       attempt.Error,
+			DurationMs:  attempt.DurationMS,
+			AttemptedAt: timestamppb.New(attempt.AttemptedAt),
+		}
+		if attempt.StatusCode != nil {
+			entry.StatusCode = int32(*attempt.StatusCode)
+		}
+		resp.Log = append(resp.Log, entry)
+	}
+	return resp
+}
+
+func toProtoAuditEvent(event *domain.AuditEvent) *proto.AuditEvent {
+	resp := &proto.AuditEvent{
+		Id:         event.ID,
+		OccurredAt: timestamppb.New(event.OccurredAt),
+		Action:     event.Action,
+		Outcome:    event.Outcome,
+		Reason:     event.Reason,
+		Changes:    &structpb.Struct{Fields: map[string]*structpb.Value{}},
+		Ip:         event.IP,
+		RequestId:  event.RequestID,
+		PrevHash:   event.PrevHash,
+		Hash:       event.Hash,
+	}
+	if event.ActorID != nil {
+		actorID := int32(*event.ActorID)
+		resp.ActorId = &actorID
+	}
+	if event.TargetUserID != nil {
+		targetID := int32(*event.TargetUserID)
+		resp.TargetUserId = &targetID
+	}
+	for field, change := range event.Changes {
+		raw, err := json.Marshal(change)
+		if err != nil {
+			log.Printf("Skipping audit change %q that cannot be encoded: %v", field, err)
+			continue
+		}
+		value := &structpb.Value{}
+		if err := value.UnmarshalJSON(raw); err != nil {
+			log.Printf("Skipping audit change %q that cannot be encoded: %v", field, err)
+			continue
+		}
+		resp.Changes.Fields[field] = value
+	}
+	return resp
+}
+
+func toLoginResponse(tokens *domain.TokenPair) *proto.LoginResponse {
+	return &proto.LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}
 }
 
 func StartGRPCServer(service services.UserService, addr string) {
@@ -120,7 +874,19 @@ func StartGRPCServer(service services.UserService, addr string) {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
+	cfg := config.LoadConfig()
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			ErrorUnaryInterceptor(),
+			ClientInfoUnaryInterceptor(cfg),
+			AuthUnaryInterceptor(service),
+		),
+		grpc.ChainStreamInterceptor(
+			ErrorStreamInterceptor(),
+			ClientInfoStreamInterceptor(cfg),
+			AuthStreamInterceptor(service),
+		),
+	)
 	proto.RegisterUserServiceServer(grpcServer, NewGRPCServer(service))
 
 	log.Printf("Starting gRPC server on %s", addr)
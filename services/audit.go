@@ -0,0 +1,276 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"user-srv/aesgcm"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// errAuditChainBroken stops VerifyAuditLog at the first mismatch.
+var errAuditChainBroken = errors.New("audit chain broken")
+
+// ListAuditEvents returns a page of audit events, newest first.
+func (s *userService) ListAuditEvents(ctx context.Context, query domain.AuditQuery) (*domain.AuditPage, error) {
+	if query.Limit < 0 {
+		return nil, domain.Validation("limit cannot be negative")
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultAuditPageSize
+	}
+	if query.Limit > maxAuditPageSize {
+		query.Limit = maxAuditPageSize
+	}
+	if query.Since != nil && query.Until != nil && !query.Since.Before(*query.Until) {
+		return nil, domain.Validation("since must be before until")
+	}
+	if query.PageToken != "" {
+		before, err := decodeIDPageToken(query.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		query.Before = before
+	}
+
+	events, err := s.audits.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	page := &domain.AuditPage{Events: events}
+	if len(events) == query.Limit {
+		page.NextPageToken = encodeIDPageToken(events[len(events)-1].ID)
+	}
+	return page, nil
+}
+
+// VerifyAuditLog recomputes the hash chains of the audit log and reports the
+// first event that was changed, or that follows removed events in its
+// chain. Removing the newest events of a chain cannot be detected from the
+// chain alone.
+func (s *userService) VerifyAuditLog(ctx context.Context) (*domain.AuditVerification, error) {
+	result := &domain.AuditVerification{}
+	prevHashes := map[int]string{}
+	err := s.audits.Walk(ctx, func(event *domain.AuditEvent) error {
+		hash, err := event.ComputeHash()
+		if err != nil || event.PrevHash != prevHashes[event.Chain] || hash != event.Hash {
+			result.BrokenAt = &event.ID
+			return errAuditChainBroken
+		}
+		result.Checked++
+		prevHashes[event.Chain] = event.Hash
+		return nil
+	})
+	if err != nil && !errors.Is(err, errAuditChainBroken) {
+		return nil, err
+	}
+	if result.BrokenAt != nil {
+		log.Printf("Audit log hash chain is broken at event %d", *result.BrokenAt)
+	}
+	return result, nil
+}
+
+// audit records the outcome of an action of the caller on the user with
+// targetID, which is 0 when the user is not known, and returns the error of
+// the action. A successful action fails if it cannot be recorded. Changes
+// to users are recorded by the repository in the transaction of the change
+// instead, see auditUser.
+func (s *userService) audit(ctx context.Context, action string, targetID int, reason string, changes domain.AuditChanges, err error) error {
+	if err != nil {
+		s.auditFailure(ctx, action, targetID, err)
+		return err
+	}
+	// The action has happened even if the caller has gone away since.
+	event := s.newAuditEvent(ctx, action, targetID, reason, changes)
+	if err := s.audits.Append(context.WithoutCancel(ctx), event); err != nil {
+		log.Printf("Failed to record audit event %s for user %d: %v", action, targetID, err)
+		return err
+	}
+	return nil
+}
+
+// auditFailure records a failed action with the kind of the error as its
+// reason; messages can quote personal data, e.g. a taken email. Failures
+// to write the audit log are only logged, as the action has failed anyway.
+func (s *userService) auditFailure(ctx context.Context, action string, targetID int, err error) {
+	reason := domain.ErrInternal.Error()
+	var domainErr *domain.Error
+	if errors.As(err, &domainErr) {
+		reason = domainErr.Kind.Error()
+	}
+	event := s.newAuditEvent(ctx, action, targetID, reason, nil)
+	event.Outcome = domain.AuditFailure
+	if err := s.audits.Append(context.WithoutCancel(ctx), event); err != nil {
+		log.Printf("Failed to record audit event %s for user %d: %v", action, targetID, err)
+	}
+}
+
+// auditUser returns the audit of a change to a user, which the repository
+// records in the transaction of the change. changes, if not nil, computes
+// the changes from the user as written.
+func (s *userService) auditUser(ctx context.Context, action, reason string, changes func(user *domain.User) domain.AuditChanges) repositories.UserAudit {
+	return func(user *domain.User) *domain.AuditEvent {
+		var recorded domain.AuditChanges
+		if changes != nil {
+			recorded = changes(user)
+		}
+		return s.newAuditEvent(ctx, action, user.ID, reason, recorded)
+	}
+}
+
+// newAuditEvent returns a successful event of the caller's action. Personal
+// values in changes and the IP address are replaced with auditHash.
+func (s *userService) newAuditEvent(ctx context.Context, action string, targetID int, reason string, changes domain.AuditChanges) *domain.AuditEvent {
+	client := ClientInfoFromContext(ctx)
+	event := &domain.AuditEvent{
+		OccurredAt: time.Now().UTC().Truncate(time.Microsecond),
+		Action:     action,
+		Outcome:    domain.AuditSuccess,
+		Reason:     reason,
+		Changes:    s.hashPersonalChanges(changes),
+		RequestID:  client.RequestID,
+	}
+	if client.IP != "" {
+		event.IP = s.auditHash([]byte(client.IP))
+	}
+	if identity, ok := IdentityFromContext(ctx); ok {
+		event.ActorID = &identity.UserID
+	}
+	if targetID > 0 {
+		event.TargetUserID = &targetID
+	}
+	if event.Changes == nil {
+		event.Changes = domain.AuditChanges{}
+	}
+	return event
+}
+
+// auditPlainFields are the changed fields recorded as they are. All other
+// values, e.g. names, emails, phone numbers and metadata, are personal or
+// may be, and are recorded as auditHash of their JSON encoding.
+var auditPlainFields = map[string]bool{
+	"id":                true,
+	"role":              true,
+	"status":            true,
+	"email_verified_at": true,
+	"created_at":        true,
+	"locale":            true,
+	"timezone":          true,
+	"deleted":           true,
+	"password":          true, // only ever AuditRedacted
+}
+
+func (s *userService) hashPersonalChanges(changes domain.AuditChanges) domain.AuditChanges {
+	hashed := make(domain.AuditChanges, len(changes))
+	for field, change := range changes {
+		if !auditPlainFields[field] {
+			change = domain.AuditChange{Before: s.auditHashValue(change.Before), After: s.auditHashValue(change.After)}
+		}
+		hashed[field] = change
+	}
+	return hashed
+}
+
+// auditHashValue hashes the JSON encoding of value. Missing and null values
+// stay as they are.
+func (s *userService) auditHashValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" {
+		return nil
+	}
+	return s.auditHash(data)
+}
+
+// auditHash returns a keyed hash of a personal value for the audit log. The
+// log then holds no personal data, and stays free of it when users are
+// erased, while whoever holds AUDIT_HASH_KEY can still check whether a
+// value was a given one.
+func (s *userService) auditHash(value []byte) string {
+	mac := hmac.New(sha256.New, s.auditKey)
+	mac.Write(value)
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// LoadAuditKey decodes AUDIT_HASH_KEY. Without one, a random key is used,
+// and hashes cannot be compared across restarts.
+func LoadAuditKey(cfg *config.Config) ([]byte, error) {
+	if cfg.AuditHashKey == "" {
+		log.Println("AUDIT_HASH_KEY is not set, hashing audit values with an ephemeral key")
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	return aesgcm.DecodeKey(cfg.AuditHashKey)
+}
+
+// userDiff returns the fields that differ between two states of a user. A
+// nil state has no fields, e.g. before a user is created. Password hashes
+// are not compared; callers add passwordChange when the password changed.
+func userDiff(before, after *domain.User) domain.AuditChanges {
+	old, updated := userFields(before), userFields(after)
+	changes := domain.AuditChanges{}
+	for key, value := range old {
+		if other, ok := updated[key]; !ok || !bytes.Equal(value, other) {
+			changes[key] = domain.AuditChange{Before: value, After: other}
+		}
+	}
+	for key, value := range updated {
+		if _, ok := old[key]; !ok {
+			changes[key] = domain.AuditChange{After: value}
+		}
+	}
+	return changes
+}
+
+func userFields(user *domain.User) map[string]json.RawMessage {
+	fields := map[string]json.RawMessage{}
+	if user == nil {
+		return fields
+	}
+	data, err := json.Marshal(domain.NewUserPayload(user))
+	if err == nil {
+		err = json.Unmarshal(data, &fields)
+	}
+	if err != nil {
+		log.Printf("Failed to diff user %d: %v", user.ID, err)
+	}
+	return fields
+}
+
+// passwordChange records that a password was set or changed without
+// recording the hash.
+func passwordChange(changes domain.AuditChanges, created bool) domain.AuditChanges {
+	change := domain.AuditChange{Before: domain.AuditRedacted, After: domain.AuditRedacted}
+	if created {
+		change.Before = nil
+	}
+	changes["password"] = change
+	return changes
+}
+
+// callerID returns the id of the authenticated caller, or 0.
+func callerID(ctx context.Context) int {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		return identity.UserID
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+// walkAuditRepository serves a fixed audit log to VerifyAuditLog.
+type walkAuditRepository struct {
+	repositories.AuditRepository
+	events []domain.AuditEvent
+}
+
+func (r *walkAuditRepository) Walk(ctx context.Context, fn func(*domain.AuditEvent) error) error {
+	for i := range r.events {
+		if err := fn(&r.events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendAuditRepository keeps the appended events.
+type appendAuditRepository struct {
+	repositories.AuditRepository
+	events []*domain.AuditEvent
+}
+
+func (r *appendAuditRepository) Append(ctx context.Context, event *domain.AuditEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+// chainedAuditLog returns events for the given targets, chained like the
+// repository chains them: per chain, in id order.
+func chainedAuditLog(t *testing.T, chains []int) []domain.AuditEvent {
+	t.Helper()
+	prevHashes := map[int]string{}
+	events := make([]domain.AuditEvent, len(chains))
+	for i, chain := range chains {
+		target := 100 + i
+		event := domain.AuditEvent{
+			ID:           int64(i + 1),
+			Chain:        chain,
+			OccurredAt:   time.Date(2025, 7, 1, 12, 0, i, 0, time.UTC),
+			TargetUserID: &target,
+			Action:       domain.AuditUserUpdate,
+			Outcome:      domain.AuditSuccess,
+			Changes:      domain.AuditChanges{"name": {Before: "a", After: "b"}},
+			PrevHash:     prevHashes[chain],
+		}
+		hash, err := event.ComputeHash()
+		if err != nil {
+			t.Fatalf("ComputeHash: %v", err)
+		}
+		event.Hash = hash
+		prevHashes[chain] = hash
+		events[i] = event
+	}
+	return events
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(events []domain.AuditEvent) []domain.AuditEvent
+		brokenAt int64
+	}{
+		{"intact", func(events []domain.AuditEvent) []domain.AuditEvent { return events }, 0},
+		{"changed event", func(events []domain.AuditEvent) []domain.AuditEvent {
+			events[2].Reason = "edited"
+			return events
+		}, 3},
+		{"changed hash", func(events []domain.AuditEvent) []domain.AuditEvent {
+			events[2].Changes = domain.AuditChanges{}
+			events[2].Hash, _ = events[2].ComputeHash()
+			return events
+		}, 5},
+		{"removed event", func(events []domain.AuditEvent) []domain.AuditEvent {
+			return append(events[:1], events[2:]...)
+		}, 4},
+		{"removed newest event of a chain", func(events []domain.AuditEvent) []domain.AuditEvent {
+			return events[:len(events)-1]
+		}, 0},
+		{"moved to another chain", func(events []domain.AuditEvent) []domain.AuditEvent {
+			events[1].Chain = 1
+			return events
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := tt.tamper(chainedAuditLog(t, []int{0, 0, 1, 0, 1, 1}))
+			s := &userService{audits: &walkAuditRepository{events: events}}
+
+			result, err := s.VerifyAuditLog(context.Background())
+			if err != nil {
+				t.Fatalf("VerifyAuditLog: %v", err)
+			}
+			if tt.brokenAt == 0 {
+				if result.BrokenAt != nil {
+					t.Fatalf("BrokenAt = %d, want intact", *result.BrokenAt)
+				}
+				if result.Checked != int64(len(events)) {
+					t.Errorf("Checked = %d, want %d", result.Checked, len(events))
+				}
+				return
+			}
+			if result.BrokenAt == nil || *result.BrokenAt != tt.brokenAt {
+				t.Fatalf("BrokenAt = %v, want %d", result.BrokenAt, tt.brokenAt)
+			}
+		})
+	}
+}
+
+func TestAuditEventHoldsNoPersonalData(t *testing.T) {
+	s := &userService{auditKey: []byte("0123456789abcdef0123456789abcdef")}
+	ctx := ContextWithClientInfo(context.Background(), &domain.ClientInfo{IP: "203.0.113.7"})
+	before := &domain.User{ID: 1, Name: "Ada", Email: "ada@example.com", Status: domain.StatusActive}
+	after := &domain.User{ID: 1, Name: "Ada", Email: "ada@example.org", Status: domain.StatusSuspended}
+
+	event := s.newAuditEvent(ctx, domain.AuditUserUpdate, 1, "", passwordChange(userDiff(before, after), false))
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	for _, value := range []string{"ada@example", "203.0.113.7"} {
+		if strings.Contains(string(data), value) {
+			t.Errorf("event contains %q: %s", value, data)
+		}
+	}
+	if _, ok := event.Changes["name"]; ok {
+		t.Errorf("unchanged name recorded")
+	}
+	email := event.Changes["email"]
+	if email.Before != s.auditHash([]byte(`"ada@example.com"`)) || email.After != s.auditHash([]byte(`"ada@example.org"`)) {
+		t.Errorf("email change = %+v, want hashes of the JSON values", email)
+	}
+	status := event.Changes["status"]
+	if string(status.Before.(json.RawMessage)) != `"active"` || string(status.After.(json.RawMessage)) != `"suspended"` {
+		t.Errorf("status change = %+v, want plain values", status)
+	}
+	if event.Changes["password"].After != domain.AuditRedacted {
+		t.Errorf("password change = %+v, want redacted", event.Changes["password"])
+	}
+	if event.IP != s.auditHash([]byte("203.0.113.7")) || len(event.IP) > 45 {
+		t.Errorf("IP = %q, want its hash", event.IP)
+	}
+
+	other := &userService{auditKey: []byte("another key")}
+	if other.auditHash([]byte("x")) == s.auditHash([]byte("x")) {
+		t.Errorf("hash does not depend on the key")
+	}
+}
+
+func TestAuditFailureRecordsErrorKind(t *testing.T) {
+	audits := &appendAuditRepository{}
+	s := &userService{audits: audits}
+
+	s.auditFailure(context.Background(), domain.AuditUserCreate, 0, domain.AlreadyExists("user with email %s already exists", "ada@example.com"))
+	s.auditFailure(context.Background(), domain.AuditUserCreate, 0, errors.New("connection to ada@example.com refused"))
+
+	if len(audits.events) != 2 {
+		t.Fatalf("got %d events, want 2", len(audits.events))
+	}
+	for i, want := range []string{domain.ErrAlreadyExists.Error(), domain.ErrInternal.Error()} {
+		event := audits.events[i]
+		if event.Outcome != domain.AuditFailure || event.Reason != want {
+			t.Errorf("event %d = %s %q, want failure %q", i, event.Outcome, event.Reason, want)
+		}
+	}
+}
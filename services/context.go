@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"user-srv/domain"
+)
+
+type identityKey struct{}
+
+type clientInfoKey struct{}
+
+// maxRequestIDLength is the length of the audit_events.request_id column.
+const maxRequestIDLength = 128
+
+func ContextWithIdentity(ctx context.Context, identity *domain.Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+func IdentityFromContext(ctx context.Context) (*domain.Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*domain.Identity)
+	return identity, ok && identity != nil
+}
+
+func ContextWithClientInfo(ctx context.Context, info *domain.ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// ClientInfoFromContext returns the client info of the request, or an empty
+// value if the transport did not provide any.
+func ClientInfoFromContext(ctx context.Context) *domain.ClientInfo {
+	if info, ok := ctx.Value(clientInfoKey{}).(*domain.ClientInfo); ok && info != nil {
+		return info
+	}
+	return &domain.ClientInfo{}
+}
+
+// ClientIP returns the address of the client behind trustedHops proxies,
+// given the address of the peer and the X-Forwarded-For values of the
+// request. Every proxy appends the address it received the request from, so
+// the client is trustedHops entries from the right; entries further left
+// were sent by the client and can be anything.
+func ClientIP(peer string, forwardedFor []string, trustedHops int) string {
+	if trustedHops <= 0 {
+		return peer
+	}
+	var entries []string
+	for _, value := range forwardedFor {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return peer
+	}
+	if trustedHops > len(entries) {
+		return entries[0]
+	}
+	return entries[len(entries)-trustedHops]
+}
+
+// RequireAdmin allows only admins to proceed.
+func RequireAdmin(ctx context.Context) error {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return domain.Unauthenticated("authentication required")
+	}
+	if !identity.IsAdmin() {
+		return domain.PermissionDenied("admin role required")
+	}
+	return checkAdminMFA(identity)
+}
+
+// AuthorizeUser allows the caller to act on userID only if it is the caller
+// themselves or the caller is an admin. Admins acting on other users are
+// held to the same second factor as in RequireAdmin.
+func AuthorizeUser(ctx context.Context, userID int) error {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return domain.Unauthenticated("authentication required")
+	}
+	if identity.UserID == userID {
+		return nil
+	}
+	if !identity.IsAdmin() {
+		return domain.PermissionDenied("not allowed to modify user %d", userID)
+	}
+	return checkAdminMFA(identity)
+}
+
+// checkAdminMFA fails if the admin identity has to but did not sign in with
+// two-factor authentication.
+func checkAdminMFA(identity *domain.Identity) error {
+	if identity.MFARequired && !identity.MFA {
+		return domain.PermissionDenied("admin actions require signing in with two-factor authentication")
+	}
+	return nil
+}
+
+// canViewPrivate reports whether the caller may see the private fields of
+// userID, which the public user routes hide from everyone else.
+func canViewPrivate(ctx context.Context, userID int) bool {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return false
+	}
+	return identity.UserID == userID || RequireAdmin(ctx) == nil
+}
+
+// NewRequestID returns a random id for a request that did not bring one.
+func NewRequestID() string {
+	id, err := randomToken(16)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// IsValidRequestID reports whether a request id sent by a client can be
+// kept: it must be short and printable.
+func IsValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
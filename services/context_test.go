@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"user-srv/domain"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity *domain.Identity
+		want     error
+	}{
+		{"anonymous", nil, domain.ErrUnauthenticated},
+		{"user", &domain.Identity{UserID: 1, Role: domain.RoleUser}, domain.ErrPermissionDenied},
+		{"admin", &domain.Identity{UserID: 1, Role: domain.RoleAdmin}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.identity != nil {
+				ctx = ContextWithIdentity(ctx, tt.identity)
+			}
+			if err := RequireAdmin(ctx); !errors.Is(err, tt.want) {
+				t.Errorf("RequireAdmin = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity *domain.Identity
+		userID   int
+		want     error
+	}{
+		{"anonymous", nil, 1, domain.ErrUnauthenticated},
+		{"self", &domain.Identity{UserID: 1, Role: domain.RoleUser}, 1, nil},
+		{"other user", &domain.Identity{UserID: 1, Role: domain.RoleUser}, 2, domain.ErrPermissionDenied},
+		{"admin on other user", &domain.Identity{UserID: 1, Role: domain.RoleAdmin}, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.identity != nil {
+				ctx = ContextWithIdentity(ctx, tt.identity)
+			}
+			if err := AuthorizeUser(ctx, tt.userID); !errors.Is(err, tt.want) {
+				t.Errorf("AuthorizeUser = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		forwardedFor []string
+		trustedHops  int
+		want         string
+	}{
+		{"untrusted", []string{"203.0.113.9"}, 0, "10.0.0.2"},
+		{"no header", nil, 1, "10.0.0.2"},
+		{"one proxy", []string{"203.0.113.9"}, 1, "203.0.113.9"},
+		{"spoofed by client", []string{"198.51.100.1, 203.0.113.9"}, 1, "203.0.113.9"},
+		{"two proxies", []string{"198.51.100.1, 203.0.113.9, 10.0.0.1"}, 2, "203.0.113.9"},
+		{"repeated header", []string{"198.51.100.1", "203.0.113.9"}, 1, "203.0.113.9"},
+		{"fewer entries than proxies", []string{"203.0.113.9"}, 2, "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClientIP("10.0.0.2", tt.forwardedFor, tt.trustedHops); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"user-srv/config"
+	"user-srv/domain"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSAKeyBits = 2048
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// KeySet holds the keys used to sign and verify tokens. One key signs new
+// tokens; every loaded key that has not been retired is accepted when
+// verifying, so keys can be rotated without invalidating issued tokens.
+type KeySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+}
+
+// LoadKeySet reads PEM private keys (RSA or Ed25519) from cfg.JWTKeysDir.
+// The file name without the .pem extension is the key id. Keys listed in
+// cfg.JWTRetiredKids are skipped. Without a directory an ephemeral Ed25519
+// key is generated, which is only suitable for local development.
+func LoadKeySet(cfg *config.Config) (*KeySet, error) {
+	if cfg.JWTKeysDir == "" {
+		log.Println("JWT_KEYS_DIR is not set, signing tokens with an ephemeral key")
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %v", err)
+		}
+		key := &signingKey{kid: "ephemeral", method: jwt.SigningMethodEdDSA, private: private}
+		return &KeySet{signing: key, keys: map[string]*signingKey{key.kid: key}}, nil
+	}
+
+	retired := map[string]bool{}
+	for _, kid := range strings.Split(cfg.JWTRetiredKids, ",") {
+		if kid = strings.TrimSpace(kid); kid != "" {
+			retired[kid] = true
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(cfg.JWTKeysDir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %v", err)
+	}
+
+	set := &KeySet{keys: map[string]*signingKey{}}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		if retired[kid] {
+			continue
+		}
+		key, err := loadSigningKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+	}
+
+	set.signing = set.keys[cfg.JWTSigningKid]
+	if set.signing == nil {
+		return nil, fmt.Errorf("signing key %q not found in %s", cfg.JWTSigningKid, cfg.JWTKeysDir)
+	}
+	return set, nil
+}
+
+func loadSigningKey(kid, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key %s: %v", kid, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", kid)
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %v", kid, err)
+	}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("signing key %s: RSA keys must be at least %d bits", kid, minRSAKeyBits)
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: private}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: private}, nil
+	default:
+		return nil, fmt.Errorf("signing key %s: unsupported key type %T", kid, parsed)
+	}
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.kid
+	return token.SignedString(k.signing.private)
+}
+
+// Parse verifies a token against the non-retired key named by its kid header.
+func (k *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, errors.New("unknown signing key")
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.private.Public(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys accepted for verification, ordered by kid.
+func (k *KeySet) JWKS() []domain.JWK {
+	keys := make([]domain.JWK, 0, len(k.keys))
+	for _, key := range k.keys {
+		jwk := domain.JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"user-srv/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey writes private as <dir>/<kid>.pem.
+func writeKey(t *testing.T, dir, kid string, private interface{}) {
+	t.Helper()
+	var block *pem.Block
+	if key, ok := private.(*rsa.PrivateKey); ok {
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "ed", private)
+	writeKey(t, dir, "rsa", rsaKey)
+
+	old, err := LoadKeySet(&config.Config{JWTKeysDir: dir, JWTSigningKid: "ed"})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	token, err := old.Sign(jwt.MapClaims{"sub": "7"})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if claims, err := old.Parse(token); err != nil || claims["sub"] != "7" {
+		t.Errorf("Parse = %v, %v", claims, err)
+	}
+
+	// Tokens of the previous signing key stay valid until it is retired.
+	rotated, err := LoadKeySet(&config.Config{JWTKeysDir: dir, JWTSigningKid: "rsa"})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if _, err := rotated.Parse(token); err != nil {
+		t.Errorf("Parse of a token of the previous key: %v", err)
+	}
+	rsaToken, err := rotated.Sign(jwt.MapClaims{"sub": "7"})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := old.Parse(rsaToken); err != nil {
+		t.Errorf("Parse of a token of the new key: %v", err)
+	}
+
+	retired, err := LoadKeySet(&config.Config{JWTKeysDir: dir, JWTSigningKid: "rsa", JWTRetiredKids: "ed"})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if _, err := retired.Parse(token); err == nil {
+		t.Errorf("Parse of a token of a retired key succeeded")
+	}
+	if _, err := LoadKeySet(&config.Config{JWTKeysDir: dir, JWTSigningKid: "ed", JWTRetiredKids: "ed"}); err == nil {
+		t.Errorf("LoadKeySet with a retired signing key succeeded")
+	}
+
+	jwks := rotated.JWKS()
+	if len(jwks) != 2 || jwks[0].Kid != "ed" || jwks[1].Kid != "rsa" {
+		t.Fatalf("JWKS = %+v, want ed and rsa", jwks)
+	}
+	if jwks[0].Kty != "OKP" || jwks[0].Crv != "Ed25519" || jwks[0].Alg != "EdDSA" || jwks[0].X != base64.RawURLEncoding.EncodeToString(public) {
+		t.Errorf("Ed25519 JWK = %+v", jwks[0])
+	}
+	if jwks[1].Kty != "RSA" || jwks[1].Alg != "RS256" || jwks[1].E != "AQAB" || jwks[1].N == "" {
+		t.Errorf("RSA JWK = %+v", jwks[1])
+	}
+	if len(retired.JWKS()) != 1 {
+		t.Errorf("JWKS publishes a retired key")
+	}
+}
+
+func TestKeySetRejectsForgedTokens(t *testing.T) {
+	keys, err := LoadKeySet(&config.Config{})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	other, err := LoadKeySet(&config.Config{})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	forged, err := other.Sign(jwt.MapClaims{"sub": "7"})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := keys.Parse(forged); err == nil {
+		t.Errorf("Parse of a token of another key with the same kid succeeded")
+	}
+
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "7"})
+	hmac.Header["kid"] = "ephemeral"
+	signed, err := hmac.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(signed); err == nil {
+		t.Errorf("Parse of an HS256 token succeeded")
+	}
+}
+
+func TestLoadKeySetRejectsShortRSAKeys(t *testing.T) {
+	dir := t.TempDir()
+	short, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "short", short)
+	if _, err := LoadKeySet(&config.Config{JWTKeysDir: dir, JWTSigningKid: "short"}); err == nil {
+		t.Errorf("LoadKeySet with a 1024 bit RSA key succeeded")
+	}
+}
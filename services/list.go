@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+
+	defaultStreamBatchSize = 500
+	maxStreamBatchSize     = 5000
+)
+
+// pageToken is the opaque cursor handed out as next_page_token. It remembers
+// the sort order so a token cannot be replayed against a different ordering.
+type pageToken struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         int    `json:"id"`
+}
+
+func (s *userService) List(ctx context.Context, query domain.UserQuery) (*domain.UserPage, error) {
+	if query.Limit < 0 {
+		return nil, domain.Validation("limit cannot be negative")
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit > maxPageSize {
+		query.Limit = maxPageSize
+	}
+
+	switch query.SortBy {
+	case "":
+		query.SortBy = domain.UserSortID
+	case domain.UserSortID, domain.UserSortName, domain.UserSortEmail, domain.UserSortCreatedAt:
+	default:
+		return nil, domain.Validation("cannot sort by %q", query.SortBy)
+	}
+	if query.SortBy != domain.UserSortID && query.SortBy != domain.UserSortCreatedAt && s.enc.Encrypted(query.SortBy) {
+		return nil, domain.Validation("cannot sort by %q, it is encrypted", query.SortBy)
+	}
+
+	if err := validateUserFilters(query); err != nil {
+		return nil, err
+	}
+	if err := s.validateEncryptedFilters(query); err != nil {
+		return nil, err
+	}
+	// Matching on email prefixes or metadata would let anyone enumerate
+	// addresses or probe metadata values that are not shown to them.
+	if query.EmailPrefix != "" || query.Metadata != nil {
+		if err := RequireAdmin(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if query.PageToken != "" {
+		cursor, err := decodePageToken(query.PageToken, query.SortBy, query.Descending)
+		if err != nil {
+			return nil, err
+		}
+		query.After = cursor
+	}
+
+	// Fetch one extra row to find out whether there is a next page.
+	limit := query.Limit
+	query.Limit++
+	users, total, err := s.repo.List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		redactPrivate(ctx, &users[i])
+	}
+	page := &domain.UserPage{Users: users, TotalCount: total}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		last := page.Users[limit-1]
+		page.NextPageToken = encodePageToken(pageToken{
+			SortBy:     query.SortBy,
+			Descending: query.Descending,
+			Value:      sortValue(&last, query.SortBy),
+			ID:         last.ID,
+		})
+	}
+	return page, nil
+}
+
+// StreamUsers calls send for every user matching the filters of query, in id
+// order. query.Limit is the number of users read from the database at a
+// time; the next batch is only read once send has accepted the current one,
+// so a slow receiver slows down the reads instead of piling users up in
+// memory. Sorting and page tokens do not apply.
+func (s *userService) StreamUsers(ctx context.Context, query domain.UserQuery, send func(*domain.User) error) error {
+	if query.Limit < 0 {
+		return domain.Validation("batch size cannot be negative")
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultStreamBatchSize
+	}
+	if query.Limit > maxStreamBatchSize {
+		query.Limit = maxStreamBatchSize
+	}
+	if err := validateUserFilters(query); err != nil {
+		return err
+	}
+	if err := s.validateEncryptedFilters(query); err != nil {
+		return err
+	}
+	return s.repo.Stream(ctx, query, send)
+}
+
+func validateUserFilters(query domain.UserQuery) error {
+	if query.CreatedAfter != nil && query.CreatedBefore != nil && !query.CreatedAfter.Before(*query.CreatedBefore) {
+		return domain.Validation("created_after must be before created_before")
+	}
+	if query.Metadata != nil {
+		if !domain.IsValidMetadataKey(query.Metadata.Key) {
+			return domain.Validation("invalid metadata key %q, expected namespace/name", query.Metadata.Key)
+		}
+		if query.Metadata.Value != nil && !json.Valid(query.Metadata.Value) {
+			return domain.Validation("invalid JSON metadata value")
+		}
+	}
+	return nil
+}
+
+// validateEncryptedFilters rejects prefix filters on encrypted columns that
+// are longer than their blind tokens go.
+func (s *userService) validateEncryptedFilters(query domain.UserQuery) error {
+	if utf8.RuneCountInString(query.EmailPrefix) > repositories.MaxTokenLength && s.enc.Encrypted("email") {
+		return domain.Validation("email prefix must be at most %d characters, emails are encrypted", repositories.MaxTokenLength)
+	}
+	if utf8.RuneCountInString(query.NamePrefix) > repositories.MaxTokenLength && s.enc.Encrypted("name") {
+		return domain.Validation("name prefix must be at most %d characters, names are encrypted", repositories.MaxTokenLength)
+	}
+	return nil
+}
+
+func sortValue(user *domain.User, sortBy string) string {
+	switch sortBy {
+	case domain.UserSortName:
+		return user.Name
+	case domain.UserSortEmail:
+		return user.Email
+	case domain.UserSortCreatedAt:
+		return user.CreatedAt
+	default:
+		return strconv.Itoa(user.ID)
+	}
+}
+
+func encodePageToken(token pageToken) string {
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(raw, sortBy string, descending bool) (*domain.UserCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, domain.Validation("invalid page token")
+	}
+	var token pageToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, domain.Validation("invalid page token")
+	}
+	if token.SortBy != sortBy || token.Descending != descending {
+		return nil, domain.Validation("page token does not match sort order")
+	}
+	return &domain.UserCursor{Value: token.Value, ID: token.ID}, nil
+}
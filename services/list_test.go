@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+// pagedUsers lists its users in id order, starting after the cursor of the
+// query.
+type pagedUsers struct {
+	repositories.UserRepository
+	users []domain.User
+}
+
+func (r *pagedUsers) List(ctx context.Context, query domain.UserQuery) ([]domain.User, int, error) {
+	var page []domain.User
+	for _, user := range r.users {
+		if query.After != nil && user.ID <= query.After.ID {
+			continue
+		}
+		if len(page) == query.Limit {
+			break
+		}
+		page = append(page, user)
+	}
+	return page, len(r.users), nil
+}
+
+func TestListPagesThroughUsers(t *testing.T) {
+	repo := &pagedUsers{}
+	for id := 1; id <= 5; id++ {
+		repo.users = append(repo.users, domain.User{ID: id})
+	}
+	s := &userService{repo: repo}
+
+	var ids []int
+	query := domain.UserQuery{Limit: 2}
+	for pages := 1; ; pages++ {
+		page, err := s.List(context.Background(), query)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if page.TotalCount != 5 {
+			t.Errorf("TotalCount = %d, want 5", page.TotalCount)
+		}
+		for _, user := range page.Users {
+			ids = append(ids, user.ID)
+		}
+		if page.NextPageToken == "" {
+			if pages != 3 {
+				t.Errorf("got %d pages, want 3", pages)
+			}
+			break
+		}
+		query.PageToken = page.NextPageToken
+	}
+	if len(ids) != 5 || ids[0] != 1 || ids[4] != 5 {
+		t.Errorf("listed ids %v, want 1 to 5", ids)
+	}
+}
+
+func TestListRejectsForeignPageTokens(t *testing.T) {
+	s := &userService{repo: &pagedUsers{}}
+	token := encodePageToken(pageToken{SortBy: domain.UserSortID, Value: "2", ID: 2})
+
+	tests := []struct {
+		name  string
+		query domain.UserQuery
+	}{
+		{"garbage", domain.UserQuery{PageToken: "not a token"}},
+		{"other sort", domain.UserQuery{PageToken: token, SortBy: domain.UserSortCreatedAt}},
+		{"other direction", domain.UserQuery{PageToken: token, Descending: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.List(context.Background(), tt.query); !errors.Is(err, domain.ErrValidation) {
+				t.Errorf("List = %v, want a validation error", err)
+			}
+		})
+	}
+}
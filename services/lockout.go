@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+	"user-srv/domain"
+)
+
+// checkLoginThrottle rejects the attempt while the account or IP is backing
+// off or locked out.
+func (s *userService) checkLoginThrottle(ctx context.Context, scope, key string) error {
+	if key == "" {
+		return nil
+	}
+	throttle, err := s.throttles.Get(ctx, scope, key)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if throttle.LockedUntil == nil {
+		return nil
+	}
+	if wait := time.Until(*throttle.LockedUntil); wait > 0 {
+		return domain.TooManyRequests(wait, "too many failed login attempts, try again in %s", wait.Round(time.Second))
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed attempt for the user (if known) and the
+// client IP, and delays further attempts: exponentially up to the threshold,
+// then by locking out for the configured duration.
+func (s *userService) recordLoginFailure(ctx context.Context, userID int) {
+	if userID > 0 {
+		s.throttleFailure(ctx, domain.ThrottleAccount, strconv.Itoa(userID), s.cfg.LoginMaxAttempts)
+	}
+	if ip := ClientInfoFromContext(ctx).IP; ip != "" {
+		s.throttleFailure(ctx, domain.ThrottleIP, ip, s.cfg.LoginIPMaxAttempts)
+	}
+}
+
+func (s *userService) throttleFailure(ctx context.Context, scope, key string, threshold int) {
+	now := time.Now()
+	failures, err := s.throttles.RecordFailure(ctx, scope, key, now, s.cfg.LoginLockoutDuration)
+	if err != nil {
+		log.Printf("Failed to record failed login for %s %s: %v", scope, key, err)
+		return
+	}
+
+	delay := s.cfg.LoginLockoutDuration
+	if failures < threshold {
+		delay = s.cfg.LoginBackoffBase << (failures - 1)
+		if delay > s.cfg.LoginBackoffMax || delay <= 0 {
+			delay = s.cfg.LoginBackoffMax
+		}
+	} else if failures == threshold {
+		log.Printf("Locking out %s %s after %d failed logins", scope, key, failures)
+	}
+
+	if err := s.throttles.Lock(ctx, scope, key, now.Add(delay)); err != nil {
+		log.Printf("Failed to lock login for %s %s: %v", scope, key, err)
+	}
+}
+
+func (s *userService) resetLoginFailures(ctx context.Context, userID int) {
+	if err := s.throttles.Reset(ctx, domain.ThrottleAccount, strconv.Itoa(userID)); err != nil {
+		log.Printf("Failed to reset failed logins for user %d: %v", userID, err)
+	}
+}
+
+// GetLoginLockout returns the failed login state of a user's account.
+func (s *userService) GetLoginLockout(ctx context.Context, userID int) (*domain.LoginThrottle, error) {
+	if _, err := s.repo.GetByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	throttle, err := s.throttles.Get(ctx, domain.ThrottleAccount, strconv.Itoa(userID))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return &domain.LoginThrottle{Scope: domain.ThrottleAccount, Key: strconv.Itoa(userID)}, nil
+		}
+		return nil, err
+	}
+	return throttle, nil
+}
+
+// UnlockUser clears failed logins and any lockout of a user's account.
+func (s *userService) UnlockUser(ctx context.Context, userID int) error {
+	if _, err := s.repo.GetByID(ctx, userID); err != nil {
+		return err
+	}
+	return s.throttles.Reset(ctx, domain.ThrottleAccount, strconv.Itoa(userID))
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+type memThrottles struct {
+	repositories.LoginThrottleRepository
+	throttles map[string]*domain.LoginThrottle
+}
+
+func (r *memThrottles) Get(ctx context.Context, scope, key string) (*domain.LoginThrottle, error) {
+	throttle, ok := r.throttles[scope+"/"+key]
+	if !ok {
+		return nil, domain.NotFound("no failed logins for %s %s", scope, key)
+	}
+	return throttle, nil
+}
+
+func (r *memThrottles) RecordFailure(ctx context.Context, scope, key string, now time.Time, window time.Duration) (int, error) {
+	throttle, ok := r.throttles[scope+"/"+key]
+	if !ok {
+		throttle = &domain.LoginThrottle{Scope: scope, Key: key}
+		r.throttles[scope+"/"+key] = throttle
+	}
+	throttle.Failures++
+	throttle.LastFailedAt = now
+	return throttle.Failures, nil
+}
+
+func (r *memThrottles) Lock(ctx context.Context, scope, key string, until time.Time) error {
+	r.throttles[scope+"/"+key].LockedUntil = &until
+	return nil
+}
+
+func (r *memThrottles) Reset(ctx context.Context, scope, key string) error {
+	delete(r.throttles, scope+"/"+key)
+	return nil
+}
+
+func TestLoginBackoffAndLockout(t *testing.T) {
+	throttles := &memThrottles{throttles: map[string]*domain.LoginThrottle{}}
+	s := &userService{throttles: throttles, cfg: &config.Config{
+		LoginMaxAttempts:     5,
+		LoginIPMaxAttempts:   20,
+		LoginBackoffBase:     time.Second,
+		LoginBackoffMax:      5 * time.Second,
+		LoginLockoutDuration: time.Hour,
+	}}
+	ctx := ContextWithClientInfo(context.Background(), &domain.ClientInfo{IP: "203.0.113.9"})
+
+	// Delays double up to the maximum, then the fifth failure locks out.
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, time.Hour} {
+		start := time.Now()
+		s.recordLoginFailure(ctx, 7)
+		locked := throttles.throttles["account/7"].LockedUntil
+		if locked == nil {
+			t.Fatalf("failure %d did not delay the next login", i+1)
+		}
+		if delay := locked.Sub(start); delay < want || delay > want+time.Second {
+			t.Errorf("failure %d delays by %s, want %s", i+1, delay, want)
+		}
+
+		err := s.checkLoginThrottle(ctx, domain.ThrottleAccount, "7")
+		var domainErr *domain.Error
+		if !errors.As(err, &domainErr) || !errors.Is(err, domain.ErrTooManyRequests) || domainErr.RetryAfter <= 0 {
+			t.Errorf("checkLoginThrottle after failure %d = %v, want too many requests with a retry delay", i+1, err)
+		}
+	}
+	if ip := throttles.throttles["ip/203.0.113.9"]; ip == nil || ip.Failures != 5 {
+		t.Errorf("IP throttle = %+v, want 5 failures", ip)
+	}
+	if err := s.checkLoginThrottle(ctx, domain.ThrottleAccount, "8"); err != nil {
+		t.Errorf("checkLoginThrottle of another account = %v", err)
+	}
+
+	s.resetLoginFailures(ctx, 7)
+	if err := s.checkLoginThrottle(ctx, domain.ThrottleAccount, "7"); err != nil {
+		t.Errorf("checkLoginThrottle after reset = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"user-srv/domain"
+)
+
+func (s *userService) GetMetadata(ctx context.Context, id int) (domain.Metadata, error) {
+	if id <= 0 {
+		return nil, domain.Validation("id must be positive")
+	}
+	return s.repo.GetMetadata(ctx, id)
+}
+
+// UpdateMetadata merges changes into the user's metadata. Keys with a null
+// value are removed, other keys are added or replaced.
+func (s *userService) UpdateMetadata(ctx context.Context, id int, changes domain.Metadata) (domain.Metadata, error) {
+	if id <= 0 {
+		return nil, domain.Validation("id must be positive")
+	}
+	if len(changes) == 0 {
+		return nil, domain.Validation("no metadata to update")
+	}
+
+	set := domain.Metadata{}
+	var remove []string
+	for key, value := range changes {
+		if !domain.IsValidMetadataKey(key) {
+			return nil, domain.Validation("invalid metadata key %q, expected namespace/name", key)
+		}
+		if !json.Valid(value) {
+			return nil, domain.Validation("invalid JSON value for metadata key %q", key)
+		}
+		if string(value) == "null" {
+			remove = append(remove, key)
+		} else {
+			set[key] = value
+		}
+	}
+	return s.updateMetadata(ctx, id, set, remove)
+}
+
+func (s *userService) DeleteMetadataKey(ctx context.Context, id int, key string) error {
+	if id <= 0 {
+		return domain.Validation("id must be positive")
+	}
+	if !domain.IsValidMetadataKey(key) {
+		return domain.Validation("invalid metadata key %q, expected namespace/name", key)
+	}
+	_, err := s.updateMetadata(ctx, id, domain.Metadata{}, []string{key})
+	return err
+}
+
+// updateMetadata applies metadata changes and records them in the audit log,
+// one change per key.
+func (s *userService) updateMetadata(ctx context.Context, id int, set domain.Metadata, remove []string) (metadata domain.Metadata, err error) {
+	defer func() {
+		if err != nil {
+			s.auditFailure(ctx, domain.AuditUserMetadata, id, err)
+		}
+	}()
+
+	before, err := s.repo.GetMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	audit := s.auditUser(ctx, domain.AuditUserMetadata, "", func(user *domain.User) domain.AuditChanges {
+		changes := domain.AuditChanges{}
+		for key, value := range set {
+			if !bytes.Equal(before[key], value) {
+				changes["metadata."+key] = domain.AuditChange{Before: before[key], After: value}
+			}
+		}
+		for _, key := range remove {
+			if value, ok := before[key]; ok {
+				changes["metadata."+key] = domain.AuditChange{Before: value}
+			}
+		}
+		return changes
+	})
+	return s.repo.UpdateMetadata(ctx, id, set, remove, audit)
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"user-srv/aesgcm"
+	"user-srv/domain"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	totpSecretSize    = 20
+	recoveryCodeCount = 10
+	// mfaTokenType marks challenge tokens so they are never accepted as access tokens.
+	mfaTokenType = "mfa"
+)
+
+// EnrollTOTP generates a new TOTP secret for the user. It only takes effect
+// once confirmed with ConfirmTOTP.
+func (s *userService) EnrollTOTP(ctx context.Context, userID int) (*domain.TOTPEnrollment, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.mfaKey()
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, domain.Internal("failed to generate two-factor secret", err)
+	}
+	encrypted, err := aesgcm.Seal(key, secret, nil)
+	if err != nil {
+		return nil, domain.Internal("failed to encrypt two-factor secret", err)
+	}
+	if err := s.mfa.SavePending(ctx, userID, encrypted); err != nil {
+		return nil, err
+	}
+
+	return &domain.TOTPEnrollment{
+		Secret:     totpEncoding.EncodeToString(secret),
+		OTPAuthURI: totpURI(s.cfg.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication after checking a code from
+// the pending enrollment and returns freshly generated recovery codes. The
+// codes are only stored hashed and cannot be shown again.
+func (s *userService) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error) {
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.Validation("no pending two-factor enrollment")
+		}
+		return nil, err
+	}
+	if mfa.EnabledAt != nil {
+		return nil, domain.AlreadyExists("two-factor authentication is already enabled")
+	}
+
+	secret, err := s.decryptMFASecret(mfa)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, domain.Validation("invalid two-factor code")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.Enable(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking
+// a TOTP code and returns the new ones.
+func (s *userService) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) (codes []string, err error) {
+	defer func() {
+		if err = s.audit(ctx, domain.AuditMFARecoveryCodes, userID, "", nil, err); err != nil {
+			codes = nil
+		}
+	}()
+
+	if err := s.checkTOTP(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off after checking a TOTP
+// code.
+func (s *userService) DisableTOTP(ctx context.Context, userID int, code string) (err error) {
+	defer func() {
+		err = s.audit(ctx, domain.AuditMFADisable, userID, "", nil, err)
+	}()
+
+	if err := s.checkTOTP(ctx, userID, code); err != nil {
+		return err
+	}
+	return s.mfa.Delete(ctx, userID)
+}
+
+// ResetMFA turns two-factor authentication off for a user who lost both
+// their authenticator and their recovery codes. The user is signed out
+// everywhere and can enroll again after signing in with their password.
+func (s *userService) ResetMFA(ctx context.Context, userID int) (err error) {
+	defer func() {
+		err = s.audit(ctx, domain.AuditMFAReset, userID, "", nil, err)
+	}()
+
+	if userID <= 0 {
+		return domain.Validation("id must be positive")
+	}
+	if _, err := s.repo.GetByID(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.mfa.Delete(ctx, userID); err != nil {
+		return err
+	}
+	return s.revokeUserTokens(ctx, userID)
+}
+
+// checkTOTP checks a TOTP code of the user's enabled two-factor
+// authentication and marks it as used. Recovery codes are not accepted.
+func (s *userService) checkTOTP(ctx context.Context, userID int, code string) error {
+	if strings.TrimSpace(code) == "" {
+		return domain.Validation("code cannot be empty")
+	}
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.Validation("two-factor authentication is not enabled")
+		}
+		return err
+	}
+	if mfa.EnabledAt == nil {
+		return domain.Validation("two-factor authentication is not enabled")
+	}
+	if err := s.checkLoginThrottle(ctx, domain.ThrottleAccount, strconv.Itoa(userID)); err != nil {
+		return err
+	}
+
+	secret, err := s.decryptMFASecret(mfa)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		s.recordLoginFailure(ctx, userID)
+		return domain.Validation("invalid two-factor code")
+	}
+	if err := s.mfa.UseStep(ctx, userID, step); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.Validation("two-factor code already used")
+		}
+		return err
+	}
+	return nil
+}
+
+// newRecoveryCodes generates recovery codes and their hashes. The codes are
+// only stored hashed and cannot be shown again.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, domain.Internal("failed to generate recovery code", err)
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(raw))
+		codes[i] = encoded[:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:]
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+// CompleteMFALogin finishes a login started by Login for an account with
+// two-factor authentication, using either a TOTP or a recovery code.
+func (s *userService) CompleteMFALogin(ctx context.Context, challenge, code string) (tokens *domain.TokenPair, err error) {
+	var userID int
+	defer func() {
+		if err = s.audit(ctx, domain.AuditLoginMFA, userID, "", nil, err); err != nil {
+			tokens = nil
+		}
+	}()
+
+	if strings.TrimSpace(challenge) == "" {
+		return nil, domain.Validation("mfa token cannot be empty")
+	}
+	if strings.TrimSpace(code) == "" {
+		return nil, domain.Validation("code cannot be empty")
+	}
+
+	userID, err = s.parseMFAChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.Unauthenticated("invalid mfa token")
+		}
+		return nil, err
+	}
+	if mfa.EnabledAt == nil {
+		return nil, domain.Unauthenticated("invalid mfa token")
+	}
+	if err := s.checkLoginThrottle(ctx, domain.ThrottleAccount, strconv.Itoa(userID)); err != nil {
+		return nil, err
+	}
+	if err := s.verifySecondFactor(ctx, mfa, code); err != nil {
+		if errors.Is(err, domain.ErrUnauthenticated) {
+			s.recordLoginFailure(ctx, userID)
+		}
+		return nil, err
+	}
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkActive(user); err != nil {
+		return nil, err
+	}
+	s.resetLoginFailures(ctx, userID)
+	return s.issueTokens(ctx, user, true)
+}
+
+// mfaEnabled reports whether the user has confirmed two-factor authentication.
+func (s *userService) mfaEnabled(ctx context.Context, userID int) (bool, error) {
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return mfa.EnabledAt != nil, nil
+}
+
+func (s *userService) verifySecondFactor(ctx context.Context, mfa *domain.UserMFA, code string) error {
+	secret, err := s.decryptMFASecret(mfa)
+	if err != nil {
+		return err
+	}
+
+	if step, ok := matchTOTP(secret, code, time.Now()); ok {
+		if err := s.mfa.UseStep(ctx, mfa.UserID, step); err != nil {
+			if errors.Is(err, domain.ErrNotFound) {
+				return domain.Unauthenticated("two-factor code already used")
+			}
+			return err
+		}
+		return nil
+	}
+
+	if err := s.mfa.UseRecoveryCode(ctx, mfa.UserID, hashToken(normalizeRecoveryCode(code))); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.Unauthenticated("invalid two-factor code")
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *userService) generateMFAChallenge(userID int) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", domain.Internal("failed to generate token", err)
+	}
+	tokenString, err := s.keys.Sign(jwt.MapClaims{
+		"id":  userID,
+		"jti": jti,
+		"typ": mfaTokenType,
+		"exp": time.Now().Add(s.cfg.MFAChallengeTTL).Unix(),
+	})
+	if err != nil {
+		return "", domain.Internal("failed to generate token", err)
+	}
+	return tokenString, nil
+}
+
+func (s *userService) parseMFAChallenge(challenge string) (int, error) {
+	claims, err := s.keys.Parse(challenge)
+	if err != nil || claims["typ"] != mfaTokenType {
+		return 0, domain.Unauthenticated("invalid mfa token")
+	}
+	userID, ok := claims["id"].(float64)
+	if !ok {
+		return 0, domain.Unauthenticated("invalid mfa token")
+	}
+	return int(userID), nil
+}
+
+func (s *userService) mfaKey() ([]byte, error) {
+	key, err := aesgcm.DecodeKey(s.cfg.MFAEncryptionKey)
+	if err != nil {
+		return nil, domain.Internal("two-factor authentication is not configured", err)
+	}
+	return key, nil
+}
+
+func (s *userService) decryptMFASecret(mfa *domain.UserMFA) ([]byte, error) {
+	key, err := s.mfaKey()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := aesgcm.Open(key, mfa.Secret, nil)
+	if err != nil {
+		return nil, domain.Internal("failed to decrypt two-factor secret", err)
+	}
+	return secret, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"user-srv/config"
+	"user-srv/domain"
+)
+
+func newTokenTestService(t *testing.T) *userService {
+	t.Helper()
+	cfg := &config.Config{AccessTokenTTL: time.Minute, MFAChallengeTTL: time.Minute}
+	keys, err := LoadKeySet(cfg)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	return &userService{keys: keys, cfg: cfg}
+}
+
+func TestMFAChallengeIsRejectedByAuthenticate(t *testing.T) {
+	s := newTokenTestService(t)
+	challenge, err := s.generateMFAChallenge(42)
+	if err != nil {
+		t.Fatalf("generateMFAChallenge: %v", err)
+	}
+
+	if _, err := s.Authenticate(context.Background(), challenge); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Fatalf("Authenticate with MFA challenge = %v, want unauthenticated", err)
+	}
+	userID, err := s.parseMFAChallenge(challenge)
+	if err != nil || userID != 42 {
+		t.Fatalf("parseMFAChallenge = %d, %v, want 42", userID, err)
+	}
+}
+
+func TestAccessTokenIsRejectedAsMFAChallenge(t *testing.T) {
+	s := newTokenTestService(t)
+	accessToken, err := s.generateAccessToken(&domain.User{ID: 42, Role: domain.RoleUser}, "session", false)
+	if err != nil {
+		t.Fatalf("generateAccessToken: %v", err)
+	}
+	if _, err := s.parseMFAChallenge(accessToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Fatalf("parseMFAChallenge with access token = %v, want unauthenticated", err)
+	}
+}
+
+func TestRequireAdminWithMFARequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity domain.Identity
+		wantErr  bool
+	}{
+		{"admin", domain.Identity{Role: domain.RoleAdmin}, false},
+		{"admin without mfa", domain.Identity{Role: domain.RoleAdmin, MFARequired: true}, true},
+		{"admin with mfa", domain.Identity{Role: domain.RoleAdmin, MFARequired: true, MFA: true}, false},
+		{"user with mfa", domain.Identity{Role: domain.RoleUser, MFA: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RequireAdmin(ContextWithIdentity(context.Background(), &tt.identity))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RequireAdmin = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, domain.ErrPermissionDenied) {
+				t.Errorf("RequireAdmin = %v, want permission denied", err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeUserWithMFARequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity domain.Identity
+		userID   int
+		wantErr  bool
+	}{
+		{"self", domain.Identity{UserID: 1, Role: domain.RoleUser}, 1, false},
+		{"other user", domain.Identity{UserID: 1, Role: domain.RoleUser}, 2, true},
+		{"admin without mfa on self", domain.Identity{UserID: 1, Role: domain.RoleAdmin, MFARequired: true}, 1, false},
+		{"admin without mfa on other user", domain.Identity{UserID: 1, Role: domain.RoleAdmin, MFARequired: true}, 2, true},
+		{"admin with mfa on other user", domain.Identity{UserID: 1, Role: domain.RoleAdmin, MFARequired: true, MFA: true}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AuthorizeUser(ContextWithIdentity(context.Background(), &tt.identity), tt.userID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthorizeUser = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, domain.ErrPermissionDenied) {
+				t.Errorf("AuthorizeUser = %v, want permission denied", err)
+			}
+		})
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatalf("newRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("duplicate recovery code %s", code)
+		}
+		seen[code] = true
+		if hashes[i] != hashToken(normalizeRecoveryCode(code)) {
+			t.Errorf("hash of %s does not match", code)
+		}
+		if hashToken(normalizeRecoveryCode(" "+code+" ")) != hashes[i] {
+			t.Errorf("normalized %s does not match", code)
+		}
+	}
+}
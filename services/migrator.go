@@ -7,6 +7,8 @@ import (
 	"log"
 	"time"
 	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
 
 	_ "github.com/lib/pq"
 	"github.com/pressly/goose/v3"
@@ -29,9 +31,10 @@ func (m *Migrator) RunMigrations() error {
 	return nil
 }
 
-func (m *Migrator) Seed() error {
-	var count int
-	err := m.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+// SeedUsers creates demo users through repo, so that they are stored like
+// any other user, encrypted where configured, until there are five.
+func SeedUsers(ctx context.Context, repo repositories.UserRepository) error {
+	_, count, err := repo.List(ctx, domain.UserQuery{Limit: 1})
 	if err != nil {
 		return fmt.Errorf("failed to count users: %v", err)
 	}
@@ -45,22 +48,31 @@ func (m *Migrator) Seed() error {
 		name     string
 		email    string
 		password string
+		role     string
 	}{
-		{"Alice", "alice@example.com", "pass123"},
-		{"Bob", "bob@example.com", "pass456"},
-		{"Charlie", "charlie@example.com", "pass789"},
-		{"Dave", "dave@example.com", "pass101"},
-		{"Eve", "eve@example.com", "pass202"},
+		{"Alice", "alice@example.com", "pass123", domain.RoleAdmin},
+		{"Bob", "bob@example.com", "pass456", domain.RoleUser},
+		{"Charlie", "charlie@example.com", "pass789", domain.RoleUser},
+		{"Dave", "dave@example.com", "pass101", domain.RoleUser},
+		{"Eve", "eve@example.com", "pass202", domain.RoleUser},
 	}
 
+	verifiedAt := time.Now()
 	for i := count; i < 5; i++ {
 		hashedPassword, err := hashPassword(users[i].password)
 		if err != nil {
 			return fmt.Errorf("failed to hash password for %s: %v", users[i].name, err)
 		}
-		query := "INSERT INTO users (name, email, password, created_at) VALUES ($1, $2, $3, $4)"
-		_, err = m.db.ExecContext(context.Background(), query, users[i].name, users[i].email, hashedPassword, time.Now())
-		if err != nil {
+		user := &domain.User{
+			Name:            users[i].name,
+			Email:           users[i].email,
+			Password:        hashedPassword,
+			Role:            users[i].role,
+			Status:          domain.StatusActive,
+			EmailVerifiedAt: &verifiedAt,
+			Metadata:        domain.Metadata{},
+		}
+		if err := repo.Create(ctx, user, nil); err != nil {
 			return fmt.Errorf("failed to seed user %s: %v", users[i].name, err)
 		}
 	}
@@ -70,9 +82,7 @@ func (m *Migrator) Seed() error {
 }
 
 func ConnectDB(cfg *config.Config) (*sql.DB, error) {
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", connectionString(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %v", err)
 	}
@@ -93,9 +103,11 @@ func InitDB() *sql.DB {
 	if err := migrator.RunMigrations(); err != nil {
 		log.Fatalf("Migration failed: %v", err)
 	}
-	if err := migrator.Seed(); err != nil {
-		log.Fatalf("Seeding failed: %v", err)
-	}
 
 	return db
 }
+
+func connectionString(cfg *config.Config) string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
+}
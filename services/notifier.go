@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Notifier delivers messages to users, e.g. by email or SMS.
+type Notifier interface {
+	Notify(ctx context.Context, email, subject, body string) error
+}
+
+type logNotifier struct {
+	logger *log.Logger
+}
+
+// NewLogNotifier returns a Notifier for local development that writes every
+// message to path, or to the standard logger when path is empty.
+func NewLogNotifier(path string) (Notifier, error) {
+	if path == "" {
+		return &logNotifier{logger: log.Default()}, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open notifier file: %v", err)
+	}
+	return &logNotifier{logger: log.New(file, "", log.LstdFlags)}, nil
+}
+
+func (n *logNotifier) Notify(_ context.Context, email, subject, body string) error {
+	n.logger.Printf("Notification to %s: %s\n%s", email, subject, body)
+	return nil
+}
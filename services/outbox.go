@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+	"user-srv/config"
+	"user-srv/repositories"
+)
+
+// outboxBatchSize is the number of messages a relay claims at a time.
+const outboxBatchSize = 20
+
+// OutboxRelay publishes the messages that user changes leave in the outbox.
+// Relays on several replicas can run side by side.
+type OutboxRelay struct {
+	repo repositories.OutboxRepository
+	sink OutboxSink
+	cfg  *config.Config
+}
+
+func NewOutboxRelay(repo repositories.OutboxRepository, sink OutboxSink) *OutboxRelay {
+	return &OutboxRelay{repo: repo, sink: sink, cfg: config.LoadConfig()}
+}
+
+// Run relays messages until ctx is cancelled, checking for new ones every
+// OutboxPollInterval once the outbox has been drained.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	for {
+		if r.relay(ctx) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.cfg.OutboxPollInterval):
+		}
+	}
+}
+
+// relay publishes one batch of messages and returns how many it claimed.
+func (r *OutboxRelay) relay(ctx context.Context) int {
+	// The lease covers publishing the whole batch one by one.
+	lease := r.cfg.OutboxPublishTimeout*outboxBatchSize + time.Minute
+	messages, err := r.repo.Claim(ctx, outboxBatchSize, lease)
+	if err != nil {
+		log.Printf("Failed to claim outbox messages: %v", err)
+		return 0
+	}
+
+	for i := range messages {
+		message := &messages[i]
+		publishCtx, cancel := context.WithTimeout(ctx, r.cfg.OutboxPublishTimeout)
+		err := r.sink.Publish(publishCtx, message)
+		cancel()
+
+		if err == nil {
+			err = r.repo.MarkPublished(ctx, message.ID)
+		} else if attempts := message.Attempts + 1; attempts >= r.cfg.OutboxMaxAttempts {
+			log.Printf("Giving up on outbox message %d after %d attempts: %v", message.ID, attempts, err)
+			err = r.repo.MarkDead(ctx, message.ID, err.Error())
+		} else {
+			err = r.repo.MarkFailed(ctx, message.ID, r.retryDelay(attempts), err.Error())
+		}
+		if err != nil {
+			log.Printf("Failed to update outbox message %d: %v", message.ID, err)
+		}
+	}
+	return len(messages)
+}
+
+// retryDelay doubles the wait after every failed attempt, up to
+// OutboxRetryMax.
+func (r *OutboxRelay) retryDelay(attempts int) time.Duration {
+	delay := r.cfg.OutboxRetryBase << (attempts - 1)
+	if delay > r.cfg.OutboxRetryMax || delay <= 0 {
+		delay = r.cfg.OutboxRetryMax
+	}
+	return delay
+}
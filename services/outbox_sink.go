@@ -0,0 +1,153 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"user-srv/config"
+	"user-srv/domain"
+)
+
+// OutboxSink publishes outbox messages to other services. Messages are
+// delivered at least once, so receivers should deduplicate by message id.
+type OutboxSink interface {
+	Publish(ctx context.Context, message *domain.OutboxMessage) error
+}
+
+// outboxEnvelope is the JSON form of a published outbox message.
+type outboxEnvelope struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	UserID     int             `json:"user_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+func encodeOutboxMessage(message *domain.OutboxMessage) ([]byte, error) {
+	return json.Marshal(outboxEnvelope{
+		ID:         message.ID,
+		Type:       message.Type,
+		UserID:     message.UserID,
+		OccurredAt: message.CreatedAt,
+		Data:       message.Payload,
+	})
+}
+
+// NewOutboxSink returns the sink selected by OUTBOX_SINK. There is no
+// default, as messages carry the users in plain text and must not end up
+// anywhere by accident.
+func NewOutboxSink(cfg *config.Config) (OutboxSink, error) {
+	switch cfg.OutboxSink {
+	case "":
+		return nil, fmt.Errorf("OUTBOX_SINK is required")
+	case "none":
+		return multiSink(nil), nil
+	case "file":
+		if cfg.OutboxFile == "" {
+			return nil, fmt.Errorf("OUTBOX_FILE is required for the file outbox sink")
+		}
+		return NewFileSink(cfg.OutboxFile)
+	case "webhook":
+		if cfg.OutboxWebhookURL == "" {
+			return nil, fmt.Errorf("OUTBOX_WEBHOOK_URL is required for the webhook outbox sink")
+		}
+		return NewWebhookSink(cfg.OutboxWebhookURL, cfg.OutboxPublishTimeout), nil
+	default:
+		return nil, fmt.Errorf("unknown outbox sink %q", cfg.OutboxSink)
+	}
+}
+
+type fileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink returns a sink for local development and tests that appends
+// every message as a JSON line to path.
+func NewFileSink(path string) (OutboxSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox file: %v", err)
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) Publish(_ context.Context, message *domain.OutboxMessage) error {
+	data, err := encodeOutboxMessage(message)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink that POSTs every message as JSON to url. Any
+// response other than 2xx counts as a failed attempt.
+func NewWebhookSink(url string, timeout time.Duration) OutboxSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *webhookSink) Publish(ctx context.Context, message *domain.OutboxMessage) error {
+	data, err := encodeOutboxMessage(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", strconv.FormatInt(message.ID, 10))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// BrokerPublisher is the part of a message broker client the broker sink
+// needs. NATS clients map topic to the subject and may ignore key; Kafka
+// clients should partition by key.
+type BrokerPublisher interface {
+	Publish(ctx context.Context, topic string, key, value []byte) error
+}
+
+type brokerSink struct {
+	publisher BrokerPublisher
+	prefix    string
+}
+
+// NewBrokerSink returns a sink that publishes every message to the topic
+// prefix + message type, e.g. "users.user.created", keyed by user id so that
+// partitioned brokers keep each user's messages in order.
+func NewBrokerSink(publisher BrokerPublisher, prefix string) OutboxSink {
+	return &brokerSink{publisher: publisher, prefix: prefix}
+}
+
+func (s *brokerSink) Publish(ctx context.Context, message *domain.OutboxMessage) error {
+	data, err := encodeOutboxMessage(message)
+	if err != nil {
+		return err
+	}
+	key := strconv.Itoa(message.UserID)
+	return s.publisher.Publish(ctx, s.prefix+message.Type, []byte(key), data)
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"user-srv/config"
+	"user-srv/domain"
+)
+
+func TestNewOutboxSinkRequiresExplicitSink(t *testing.T) {
+	for _, cfg := range []config.Config{{}, {OutboxSink: "file"}, {OutboxSink: "webhook"}, {OutboxSink: "log"}} {
+		if _, err := NewOutboxSink(&cfg); err == nil {
+			t.Errorf("NewOutboxSink(%+v) succeeded", cfg)
+		}
+	}
+	sink, err := NewOutboxSink(&config.Config{OutboxSink: "none"})
+	if err != nil {
+		t.Fatalf("NewOutboxSink(none): %v", err)
+	}
+	if err := sink.Publish(context.Background(), &domain.OutboxMessage{ID: 1}); err != nil {
+		t.Errorf("Publish to none: %v", err)
+	}
+}
+
+func TestFileSinkAppendsMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	sink, err := NewOutboxSink(&config.Config{OutboxSink: "file", OutboxFile: path})
+	if err != nil {
+		t.Fatalf("NewOutboxSink: %v", err)
+	}
+	message := &domain.OutboxMessage{ID: 3, Type: "user.created", UserID: 7, Payload: []byte(`{"id":7}`)}
+	if err := sink.Publish(context.Background(), message); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope outboxEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatalf("file holds %q: %v", data, err)
+	}
+	if envelope.ID != 3 || envelope.Type != "user.created" || envelope.UserID != 7 || string(envelope.Data) != `{"id":7}` {
+		t.Errorf("published %+v", envelope)
+	}
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+type outboxEntry struct {
+	message domain.OutboxMessage
+	status  string
+	retryIn time.Duration
+}
+
+// memOutbox claims messages like the repository does, the oldest pending
+// message of every user first, but ignores retry delays and leases.
+type memOutbox struct {
+	repositories.OutboxRepository
+	entries []*outboxEntry
+}
+
+func (r *memOutbox) add(userID int) {
+	id := int64(len(r.entries) + 1)
+	r.entries = append(r.entries, &outboxEntry{message: domain.OutboxMessage{ID: id, UserID: userID}, status: "pending"})
+}
+
+func (r *memOutbox) Claim(ctx context.Context, limit int, lease time.Duration) ([]domain.OutboxMessage, error) {
+	var messages []domain.OutboxMessage
+	blocked := map[int]bool{}
+	for _, entry := range r.entries {
+		if entry.status != "pending" || blocked[entry.message.UserID] {
+			continue
+		}
+		blocked[entry.message.UserID] = true
+		if len(messages) < limit {
+			messages = append(messages, entry.message)
+		}
+	}
+	return messages, nil
+}
+
+func (r *memOutbox) MarkPublished(ctx context.Context, id int64) error {
+	r.entries[id-1].status = "published"
+	r.entries[id-1].message.Attempts++
+	return nil
+}
+
+func (r *memOutbox) MarkFailed(ctx context.Context, id int64, retryIn time.Duration, reason string) error {
+	r.entries[id-1].retryIn = retryIn
+	r.entries[id-1].message.Attempts++
+	return nil
+}
+
+func (r *memOutbox) MarkDead(ctx context.Context, id int64, reason string) error {
+	r.entries[id-1].status = "dead"
+	r.entries[id-1].message.Attempts++
+	return nil
+}
+
+// flakySink fails to publish the messages in failures as many times as
+// given, and records the order of the others.
+type flakySink struct {
+	failures  map[int64]int
+	published []int64
+}
+
+func (s *flakySink) Publish(ctx context.Context, message *domain.OutboxMessage) error {
+	if s.failures[message.ID] > 0 {
+		s.failures[message.ID]--
+		return errors.New("subscriber unavailable")
+	}
+	s.published = append(s.published, message.ID)
+	return nil
+}
+
+func newTestRelay(repo repositories.OutboxRepository, sink OutboxSink) *OutboxRelay {
+	return &OutboxRelay{repo: repo, sink: sink, cfg: &config.Config{
+		OutboxPublishTimeout: time.Second,
+		OutboxMaxAttempts:    3,
+		OutboxRetryBase:      time.Second,
+		OutboxRetryMax:       3 * time.Second,
+	}}
+}
+
+// drain relays until the outbox has no more pending messages.
+func drain(t *testing.T, relay *OutboxRelay) {
+	t.Helper()
+	for i := 0; relay.relay(context.Background()) > 0; i++ {
+		if i == 100 {
+			t.Fatalf("outbox does not drain")
+		}
+	}
+}
+
+func TestOutboxRelayKeepsOrderPerUser(t *testing.T) {
+	repo := &memOutbox{}
+	for _, userID := range []int{7, 8, 7, 8} {
+		repo.add(userID)
+	}
+	sink := &flakySink{failures: map[int64]int{1: 2}}
+	drain(t, newTestRelay(repo, sink))
+
+	// Message 3 waits for message 1 of the same user, message 2 does not.
+	want := []int64{2, 4, 1, 3}
+	if len(sink.published) != len(want) {
+		t.Fatalf("published %v, want %v", sink.published, want)
+	}
+	for i := range want {
+		if sink.published[i] != want[i] {
+			t.Fatalf("published %v, want %v", sink.published, want)
+		}
+	}
+	if entry := repo.entries[0]; entry.status != "published" || entry.message.Attempts != 3 || entry.retryIn != 2*time.Second {
+		t.Errorf("message 1 = %s after %d attempts, retry in %s", entry.status, entry.message.Attempts, entry.retryIn)
+	}
+}
+
+func TestOutboxRelayDeadLetters(t *testing.T) {
+	repo := &memOutbox{}
+	repo.add(7)
+	repo.add(7)
+	sink := &flakySink{failures: map[int64]int{1: 5}}
+	drain(t, newTestRelay(repo, sink))
+
+	if entry := repo.entries[0]; entry.status != "dead" || entry.message.Attempts != 3 {
+		t.Errorf("message 1 = %s after %d attempts, want dead after 3", entry.status, entry.message.Attempts)
+	}
+	// A dead message no longer holds up the messages after it.
+	if len(sink.published) != 1 || sink.published[0] != 2 {
+		t.Errorf("published %v, want message 2", sink.published)
+	}
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	relay := newTestRelay(nil, nil)
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 70: 3 * time.Second} {
+		if got := relay.retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"user-srv/domain"
+)
+
+// ForgotPassword sends a single-use reset token to the user with the given
+// email. Unknown emails are ignored so the endpoint cannot be used to find
+// out which accounts exist.
+func (s *userService) ForgotPassword(ctx context.Context, email string) error {
+	if err := validateEmail(email); err != nil {
+		return err
+	}
+
+	user, err := s.repo.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	plain, err := randomToken(32)
+	if err != nil {
+		return domain.Internal("failed to generate token", err)
+	}
+	token := &domain.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(plain),
+		ExpiresAt: time.Now().Add(s.cfg.PasswordResetTTL),
+	}
+	if err := s.resets.Create(ctx, token); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Use this token to reset your password: %s\nIt expires in %s.", plain, s.cfg.PasswordResetTTL)
+	if err := s.notifier.Notify(ctx, user.Email, "Password reset", body); err != nil {
+		log.Printf("Failed to send password reset to user %d: %v", user.ID, err)
+		return domain.Internal("failed to send password reset", err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out of every existing session.
+func (s *userService) ResetPassword(ctx context.Context, token, password string) error {
+	if strings.TrimSpace(token) == "" {
+		return domain.Validation("token cannot be empty")
+	}
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return domain.Internal("failed to hash password", err)
+	}
+
+	audit := s.auditUser(ctx, domain.AuditUserPasswordReset, "", func(*domain.User) domain.AuditChanges {
+		return passwordChange(domain.AuditChanges{}, false)
+	})
+	userID, err := s.resets.Reset(ctx, hashToken(token), hashedPassword, audit)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			err = domain.Validation("invalid or expired reset token")
+		}
+		s.auditFailure(ctx, domain.AuditUserPasswordReset, 0, err)
+		return err
+	}
+	return s.revokeUserTokens(ctx, userID)
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+// sentNotifications records every message instead of delivering it.
+type sentNotifications struct {
+	messages []string
+}
+
+func (n *sentNotifications) Notify(ctx context.Context, email, subject, body string) error {
+	n.messages = append(n.messages, email+": "+body)
+	return nil
+}
+
+// sentToken returns the token quoted in a notification like "...: <token>\n".
+func sentToken(t *testing.T, message string) string {
+	t.Helper()
+	line, _, _ := strings.Cut(message, "\n")
+	i := strings.LastIndex(line, " ")
+	if i < 0 {
+		t.Fatalf("no token in %q", message)
+	}
+	return line[i+1:]
+}
+
+type emailUsers struct {
+	repositories.UserRepository
+	users []domain.User
+}
+
+func (r *emailUsers) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	for i := range r.users {
+		if r.users[i].Email == email {
+			return &r.users[i], nil
+		}
+	}
+	return nil, domain.NotFound("user with email %s not found", email)
+}
+
+// memResets stores reset tokens; none of them can be redeemed.
+type memResets struct {
+	repositories.PasswordResetRepository
+	tokens []*domain.PasswordResetToken
+}
+
+func (r *memResets) Create(ctx context.Context, token *domain.PasswordResetToken) error {
+	r.tokens = append(r.tokens, token)
+	return nil
+}
+
+func (r *memResets) Reset(ctx context.Context, tokenHash, passwordHash string, audit repositories.UserAudit) (int, error) {
+	return 0, domain.NotFound("reset token not found")
+}
+
+func newResetTestService() (*userService, *memResets, *sentNotifications) {
+	resets, notifier := &memResets{}, &sentNotifications{}
+	s := &userService{
+		repo:     &emailUsers{users: []domain.User{{ID: 7, Email: "ada@example.com"}}},
+		resets:   resets,
+		notifier: notifier,
+		audits:   &appendAuditRepository{},
+		cfg:      &config.Config{PasswordResetTTL: time.Hour},
+	}
+	return s, resets, notifier
+}
+
+func TestForgotPasswordSendsSingleToken(t *testing.T) {
+	s, resets, notifier := newResetTestService()
+
+	for i := 0; i < 2; i++ {
+		if err := s.ForgotPassword(context.Background(), "ada@example.com"); err != nil {
+			t.Fatalf("ForgotPassword: %v", err)
+		}
+	}
+	if len(resets.tokens) != 2 || len(notifier.messages) != 2 {
+		t.Fatalf("stored %d tokens and sent %d messages, want 2", len(resets.tokens), len(notifier.messages))
+	}
+	first, second := sentToken(t, notifier.messages[0]), sentToken(t, notifier.messages[1])
+	if first == second {
+		t.Errorf("requests got the same token")
+	}
+	stored := resets.tokens[0]
+	if stored.UserID != 7 || stored.TokenHash != hashToken(first) || stored.TokenHash == first {
+		t.Errorf("stored token = %+v, want the hash of %q for user 7", stored, first)
+	}
+	if until := time.Until(stored.ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %s, want an hour", until)
+	}
+}
+
+func TestForgotPasswordOfUnknownEmail(t *testing.T) {
+	s, resets, notifier := newResetTestService()
+
+	// Unknown emails answer like known ones, so accounts cannot be probed.
+	if err := s.ForgotPassword(context.Background(), "bob@example.com"); err != nil {
+		t.Fatalf("ForgotPassword = %v, want nil", err)
+	}
+	if len(resets.tokens) != 0 || len(notifier.messages) != 0 {
+		t.Errorf("stored %d tokens and sent %d messages for an unknown email", len(resets.tokens), len(notifier.messages))
+	}
+}
+
+func TestResetPasswordWithUnknownToken(t *testing.T) {
+	s, _, _ := newResetTestService()
+
+	err := s.ResetPassword(context.Background(), "used-or-expired", "correct horse battery")
+	if !errors.Is(err, domain.ErrValidation) {
+		t.Errorf("ResetPassword = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-srv/domain"
+)
+
+// ExportUser collects everything stored about the user for a data subject
+// access request. Soft-deleted users can be exported until they are purged.
+func (s *userService) ExportUser(ctx context.Context, id int) (export *domain.UserExport, err error) {
+	defer func() {
+		err = s.audit(ctx, domain.AuditUserExport, id, "", nil, err)
+		if err != nil {
+			export = nil
+		}
+	}()
+
+	if id <= 0 {
+		return nil, domain.Validation("id must be positive")
+	}
+	user, err := s.repo.GetIncludingDeleted(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+
+	export = &domain.UserExport{User: *user, ExportedAt: time.Now().UTC()}
+	mfa, err := s.mfa.Get(ctx, id)
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		return nil, err
+	}
+	if mfa != nil {
+		export.MFAEnabledAt = mfa.EnabledAt
+	}
+	if export.Sessions, err = s.sessions.ListByUser(ctx, id); err != nil {
+		return nil, err
+	}
+	if export.AuditEvents, err = s.audits.ListByUser(ctx, id); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// EraseUser anonymises the user and deletes their personal data across all
+// tables, signing them out everywhere. It cannot be undone. The audit log is
+// append-only and keeps its events as the record of what happened to the
+// account, which is why it only ever holds hashes of personal data; a
+// user.erase event is added as the tombstone.
+func (s *userService) EraseUser(ctx context.Context, id int) (err error) {
+	defer func() {
+		if err != nil {
+			s.auditFailure(ctx, domain.AuditUserErase, id, err)
+		}
+	}()
+
+	if id <= 0 {
+		return domain.Validation("id must be positive")
+	}
+	if callerID(ctx) == id {
+		return domain.PermissionDenied("cannot erase your own account")
+	}
+	if _, err := s.repo.Erase(ctx, id, s.auditUser(ctx, domain.AuditUserErase, "", nil)); err != nil {
+		return err
+	}
+	return s.revokeUserTokens(ctx, id)
+}
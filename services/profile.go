@@ -0,0 +1,123 @@
+package services
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+	"unicode/utf8"
+	"user-srv/domain"
+
+	"golang.org/x/text/language"
+)
+
+const maxProfileNameLength = 100
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// validateProfile checks every profile field and normalizes the locale.
+func validateProfile(profile *domain.Profile) error {
+	if profile.DateOfBirth != nil && *profile.DateOfBirth == "" {
+		profile.DateOfBirth = nil
+	}
+	return validateProfileFields(profileFields{
+		FirstName:   &profile.FirstName,
+		LastName:    &profile.LastName,
+		DisplayName: &profile.DisplayName,
+		PhoneNumber: &profile.PhoneNumber,
+		Locale:      &profile.Locale,
+		Timezone:    &profile.Timezone,
+		AvatarURL:   &profile.AvatarURL,
+		DateOfBirth: profile.DateOfBirth,
+	})
+}
+
+// validateProfilePatch checks the profile fields set in patch and
+// normalizes the locale.
+func validateProfilePatch(patch *domain.UserPatch) error {
+	return validateProfileFields(profileFields{
+		FirstName:   patch.FirstName,
+		LastName:    patch.LastName,
+		DisplayName: patch.DisplayName,
+		PhoneNumber: patch.PhoneNumber,
+		Locale:      patch.Locale,
+		Timezone:    patch.Timezone,
+		AvatarURL:   patch.AvatarURL,
+		DateOfBirth: patch.DateOfBirth,
+	})
+}
+
+// profileFields points at the profile values to validate. Nil fields are
+// skipped and empty values are always valid, they clear the field.
+type profileFields struct {
+	FirstName, LastName, DisplayName *string
+	PhoneNumber, Locale, Timezone    *string
+	AvatarURL, DateOfBirth           *string
+}
+
+func validateProfileFields(fields profileFields) error {
+	names := []struct {
+		field string
+		value *string
+	}{
+		{"first_name", fields.FirstName},
+		{"last_name", fields.LastName},
+		{"display_name", fields.DisplayName},
+	}
+	for _, name := range names {
+		if name.value != nil && utf8.RuneCountInString(*name.value) > maxProfileNameLength {
+			return domain.Validation("%s must be at most %d characters", name.field, maxProfileNameLength)
+		}
+	}
+
+	if v := fields.PhoneNumber; v != nil && *v != "" && !e164Pattern.MatchString(*v) {
+		return domain.Validation("phone_number must be in E.164 format, e.g. +14155552671")
+	}
+	if v := fields.Locale; v != nil && *v != "" {
+		tag, err := language.Parse(*v)
+		if err != nil {
+			return domain.Validation("locale must be a BCP 47 language tag, e.g. en-US")
+		}
+		*v = tag.String()
+	}
+	if v := fields.Timezone; v != nil && *v != "" {
+		if *v == "Local" {
+			return domain.Validation("timezone must be an IANA time zone, e.g. Europe/Berlin")
+		}
+		if _, err := time.LoadLocation(*v); err != nil {
+			return domain.Validation("timezone must be an IANA time zone, e.g. Europe/Berlin")
+		}
+	}
+	if v := fields.AvatarURL; v != nil && *v != "" {
+		if err := validateAvatarURL(*v); err != nil {
+			return err
+		}
+	}
+	if v := fields.DateOfBirth; v != nil && *v != "" {
+		if err := validateDateOfBirth(*v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateAvatarURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return domain.Validation("avatar_url must be an absolute http(s) URL")
+	}
+	if len(value) > 2048 {
+		return domain.Validation("avatar_url must be at most 2048 characters")
+	}
+	return nil
+}
+
+func validateDateOfBirth(value string) error {
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return domain.Validation("date_of_birth must be formatted as YYYY-MM-DD")
+	}
+	if date.After(time.Now()) || date.Year() < 1900 {
+		return domain.Validation("date_of_birth is out of range")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+	"user-srv/config"
+	"user-srv/repositories"
+)
+
+// UserPurger permanently deletes users once their soft delete is older than
+// the configured retention window, and user events, published outbox
+// messages and successful webhook deliveries older than theirs.
+type UserPurger struct {
+	repo     repositories.UserRepository
+	events   repositories.UserEventRepository
+	outbox   repositories.OutboxRepository
+	webhooks repositories.WebhookRepository
+	cfg      *config.Config
+}
+
+func NewUserPurger(
+	repo repositories.UserRepository,
+	events repositories.UserEventRepository,
+	outbox repositories.OutboxRepository,
+	webhooks repositories.WebhookRepository,
+) *UserPurger {
+	return &UserPurger{repo: repo, events: events, outbox: outbox, webhooks: webhooks, cfg: config.LoadConfig()}
+}
+
+// Run purges once right away and then every UserPurgeInterval until ctx is
+// cancelled.
+func (p *UserPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.UserPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		p.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *UserPurger) purge(ctx context.Context) {
+	purged, err := p.repo.Purge(ctx, time.Now().Add(-p.cfg.UserRetention))
+	if err != nil {
+		log.Printf("Failed to purge deleted users: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d deleted users", purged)
+	}
+
+	purged, err = p.events.Purge(ctx, time.Now().Add(-p.cfg.UserEventRetention))
+	if err != nil {
+		log.Printf("Failed to purge user events: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d user events", purged)
+	}
+
+	purged, err = p.outbox.Purge(ctx, time.Now().Add(-p.cfg.OutboxRetention))
+	if err != nil {
+		log.Printf("Failed to purge outbox: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d published outbox messages", purged)
+	}
+
+	purged, err = p.webhooks.PurgeDeliveries(ctx, time.Now().Add(-p.cfg.OutboxRetention))
+	if err != nil {
+		log.Printf("Failed to purge webhook deliveries: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d webhook deliveries", purged)
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"user-srv/domain"
+)
+
+// Logout ends the caller's session, revoking its access token and, if
+// given, the refresh token family it belongs to.
+func (s *userService) Logout(ctx context.Context, refreshToken string) (err error) {
+	defer func() {
+		err = s.audit(ctx, domain.AuditLogout, callerID(ctx), "", nil, err)
+	}()
+
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return domain.Unauthenticated("authentication required")
+	}
+
+	if err := s.revocations.RevokeToken(ctx, identity.TokenID, identity.UserID, identity.ExpiresAt.UTC()); err != nil {
+		return err
+	}
+	s.revocationCache.setToken(identity.TokenID, true)
+	if err := s.revokeSession(ctx, identity.SessionID); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+	stored, err := s.tokens.GetByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if stored.UserID != identity.UserID {
+		return nil
+	}
+	return s.tokens.RevokeFamily(ctx, stored.FamilyID)
+}
+
+// LogoutAll revokes every access and refresh token of the caller.
+func (s *userService) LogoutAll(ctx context.Context) error {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok {
+		return domain.Unauthenticated("authentication required")
+	}
+	err := s.revokeUserTokens(ctx, identity.UserID)
+	return s.audit(ctx, domain.AuditLogoutAll, identity.UserID, "", nil, err)
+}
+
+// revokeUserTokens invalidates every token issued to the user so far.
+func (s *userService) revokeUserTokens(ctx context.Context, userID int) error {
+	before := time.Now().UTC()
+	if err := s.revocations.RevokeUserTokens(ctx, userID, before); err != nil {
+		return err
+	}
+	s.revocationCache.setUser(userID, &before)
+
+	// Sessions go first: a refresh token rotated meanwhile either sees its
+	// session revoked or is revoked below, see RefreshTokenRepository.Rotate.
+	if err := s.sessions.RevokeUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.tokens.RevokeUser(ctx, userID); err != nil {
+		return err
+	}
+	log.Printf("Revoked all tokens of user %d", userID)
+	return nil
+}
+
+// checkRevoked rejects an access token that was revoked on its own, belongs
+// to a revoked session or was issued before the user's tokens were revoked
+// in bulk.
+func (s *userService) checkRevoked(ctx context.Context, identity *domain.Identity, issuedAt time.Time) error {
+	revoked, ok := s.revocationCache.token(identity.TokenID)
+	if !ok {
+		var err error
+		if revoked, err = s.revocations.IsTokenRevoked(ctx, identity.TokenID); err != nil {
+			return err
+		}
+		s.revocationCache.setToken(identity.TokenID, revoked)
+	}
+	if revoked {
+		return domain.Unauthenticated("token has been revoked")
+	}
+
+	revoked, ok = s.revocationCache.session(identity.SessionID)
+	if !ok {
+		session, err := s.sessions.Get(ctx, identity.SessionID)
+		if err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return err
+		}
+		revoked = session == nil || session.RevokedAt != nil
+		s.revocationCache.setSession(identity.SessionID, revoked)
+	}
+	if revoked {
+		return domain.Unauthenticated("session has been revoked")
+	}
+
+	before, ok := s.revocationCache.user(identity.UserID)
+	if !ok {
+		var err error
+		if before, err = s.revocations.GetUserRevokedBefore(ctx, identity.UserID); err != nil {
+			return err
+		}
+		s.revocationCache.setUser(identity.UserID, before)
+	}
+	if before != nil && issuedAt.Before(*before) {
+		return domain.Unauthenticated("token has been revoked")
+	}
+	return nil
+}
+
+// revocationCache keeps recent revocation and user status lookups in memory
+// so that not every authenticated request hits the database. Changes made
+// by other instances become visible once the cached entry is older than ttl.
+type revocationCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	tokens    map[string]cachedValue[bool]
+	sessions  map[string]cachedValue[bool]
+	users     map[int]cachedValue[*time.Time]
+	statuses  map[int]cachedValue[string]
+	lastSweep time.Time
+}
+
+type cachedValue[T any] struct {
+	value    T
+	cachedAt time.Time
+}
+
+func newRevocationCache(ttl time.Duration) *revocationCache {
+	return &revocationCache{
+		ttl:      ttl,
+		tokens:   map[string]cachedValue[bool]{},
+		sessions: map[string]cachedValue[bool]{},
+		users:    map[int]cachedValue[*time.Time]{},
+		statuses: map[int]cachedValue[string]{},
+	}
+}
+
+func (c *revocationCache) token(jti string) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.tokens[jti]
+	if !ok || time.Since(entry.cachedAt) > c.ttl {
+		return false, false
+	}
+	return entry.value, true
+}
+
+func (c *revocationCache) setToken(jti string, revoked bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep()
+	c.tokens[jti] = cachedValue[bool]{value: revoked, cachedAt: time.Now()}
+}
+
+func (c *revocationCache) session(id string) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.sessions[id]
+	if !ok || time.Since(entry.cachedAt) > c.ttl {
+		return false, false
+	}
+	return entry.value, true
+}
+
+func (c *revocationCache) setSession(id string, revoked bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep()
+	c.sessions[id] = cachedValue[bool]{value: revoked, cachedAt: time.Now()}
+}
+
+func (c *revocationCache) user(userID int) (*time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.users[userID]
+	if !ok || time.Since(entry.cachedAt) > c.ttl {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *revocationCache) setUser(userID int, before *time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep()
+	c.users[userID] = cachedValue[*time.Time]{value: before, cachedAt: time.Now()}
+}
+
+func (c *revocationCache) status(userID int) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.statuses[userID]
+	if !ok || time.Since(entry.cachedAt) > c.ttl {
+		return "", false
+	}
+	return entry.value, true
+}
+
+func (c *revocationCache) setStatus(userID int, status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep()
+	c.statuses[userID] = cachedValue[string]{value: status, cachedAt: time.Now()}
+}
+
+// sweep drops stale entries at most once per ttl. The caller holds mu.
+func (c *revocationCache) sweep() {
+	now := time.Now()
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for jti, entry := range c.tokens {
+		if now.Sub(entry.cachedAt) > c.ttl {
+			delete(c.tokens, jti)
+		}
+	}
+	for id, entry := range c.sessions {
+		if now.Sub(entry.cachedAt) > c.ttl {
+			delete(c.sessions, id)
+		}
+	}
+	for userID, entry := range c.users {
+		if now.Sub(entry.cachedAt) > c.ttl {
+			delete(c.users, userID)
+		}
+	}
+	for userID, entry := range c.statuses {
+		if now.Sub(entry.cachedAt) > c.ttl {
+			delete(c.statuses, userID)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"user-srv/domain"
+)
+
+// memRevocations keeps revoked tokens and users in memory.
+type memRevocations struct {
+	tokens map[string]bool
+	users  map[int]time.Time
+}
+
+func (r *memRevocations) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	r.tokens[jti] = true
+	return nil
+}
+
+func (r *memRevocations) RevokeUserTokens(ctx context.Context, userID int, before time.Time) error {
+	r.users[userID] = before
+	return nil
+}
+
+func (r *memRevocations) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return r.tokens[jti], nil
+}
+
+func (r *memRevocations) GetUserRevokedBefore(ctx context.Context, userID int) (*time.Time, error) {
+	before, ok := r.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &before, nil
+}
+
+func (r *memRefreshTokens) RevokeUser(ctx context.Context, userID int) error {
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// newRevocationTestService returns a service that signs user 7 in and
+// checks revocations with a fresh cache.
+func newRevocationTestService(t *testing.T) (*userService, *memRevocations) {
+	t.Helper()
+	s, _, _ := newRefreshTestService(t)
+	revocations := &memRevocations{tokens: map[string]bool{}, users: map[int]time.Time{}}
+	s.revocations = revocations
+	s.revocationCache = newRevocationCache(time.Minute)
+	s.audits = &appendAuditRepository{}
+	return s, revocations
+}
+
+// signIn issues tokens for user 7 and returns them with the identity of the
+// access token.
+func signIn(t *testing.T, s *userService) (*domain.TokenPair, *domain.Identity) {
+	t.Helper()
+	pair, err := s.issueTokens(context.Background(), &domain.User{ID: 7, Role: domain.RoleUser}, false)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+	identity, err := s.Authenticate(context.Background(), pair.AccessToken)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	return pair, identity
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	s, revocations := newRevocationTestService(t)
+	pair, identity := signIn(t, s)
+	other, _ := signIn(t, s)
+
+	if err := s.Logout(ContextWithIdentity(context.Background(), identity), pair.RefreshToken); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if !revocations.tokens[identity.TokenID] {
+		t.Errorf("access token was not revoked")
+	}
+	if _, err := s.Authenticate(context.Background(), pair.AccessToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Errorf("Authenticate after logout = %v, want unauthenticated", err)
+	}
+	if _, err := s.RefreshToken(context.Background(), pair.RefreshToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Errorf("RefreshToken after logout = %v, want unauthenticated", err)
+	}
+
+	// Another sign-in of the same user is not affected.
+	if _, err := s.Authenticate(context.Background(), other.AccessToken); err != nil {
+		t.Errorf("Authenticate of another session: %v", err)
+	}
+	if _, err := s.RefreshToken(context.Background(), other.RefreshToken); err != nil {
+		t.Errorf("RefreshToken of another session: %v", err)
+	}
+}
+
+func TestLogoutAllRevokesEveryToken(t *testing.T) {
+	s, _ := newRevocationTestService(t)
+	pair, identity := signIn(t, s)
+	other, _ := signIn(t, s)
+
+	if err := s.LogoutAll(ContextWithIdentity(context.Background(), identity)); err != nil {
+		t.Fatalf("LogoutAll: %v", err)
+	}
+	for _, issued := range []*domain.TokenPair{pair, other} {
+		if _, err := s.Authenticate(context.Background(), issued.AccessToken); !errors.Is(err, domain.ErrUnauthenticated) {
+			t.Errorf("Authenticate after logging out everywhere = %v, want unauthenticated", err)
+		}
+		if _, err := s.RefreshToken(context.Background(), issued.RefreshToken); !errors.Is(err, domain.ErrUnauthenticated) {
+			t.Errorf("RefreshToken after logging out everywhere = %v, want unauthenticated", err)
+		}
+	}
+}
+
+func TestRevocationCacheExpires(t *testing.T) {
+	cache := newRevocationCache(time.Minute)
+	if _, ok := cache.token("jti"); ok {
+		t.Errorf("empty cache has a token")
+	}
+	cache.setToken("jti", true)
+	if revoked, ok := cache.token("jti"); !ok || !revoked {
+		t.Errorf("token = %v, %v, want revoked", revoked, ok)
+	}
+
+	cache.tokens["jti"] = cachedValue[bool]{value: true, cachedAt: time.Now().Add(-2 * time.Minute)}
+	if _, ok := cache.token("jti"); ok {
+		t.Errorf("stale entry is still cached")
+	}
+}
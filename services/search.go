@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+	maxSearchQueryLength  = 200
+)
+
+// searchPageToken is the opaque cursor of search results. Results are
+// ranked, so pages are addressed by offset and tied to the query.
+type searchPageToken struct {
+	Query  string `json:"q"`
+	Offset int    `json:"o"`
+}
+
+// SearchUsers finds users by words or fragments of their name, email or
+// display name, best matches first. While any of the searched columns is
+// encrypted, only words starting with the terms are found, in id order.
+func (s *userService) SearchUsers(ctx context.Context, search domain.UserSearch) (*domain.UserSearchPage, error) {
+	search.Query = strings.TrimSpace(search.Query)
+	if search.Query == "" {
+		return nil, domain.Validation("search query cannot be empty")
+	}
+	if len(search.Query) > maxSearchQueryLength {
+		return nil, domain.Validation("search query must be at most %d characters", maxSearchQueryLength)
+	}
+	search.Terms = domain.SearchTerms(search.Query)
+	if len(search.Terms) == 0 {
+		return nil, domain.Validation("search query must contain letters or digits")
+	}
+	if s.enc.BlindSearch() {
+		for _, term := range search.Terms {
+			if utf8.RuneCountInString(term) > repositories.MaxTokenLength {
+				return nil, domain.Validation("search terms must be at most %d characters, searched fields are encrypted", repositories.MaxTokenLength)
+			}
+		}
+	}
+
+	if search.Limit < 0 {
+		return nil, domain.Validation("limit cannot be negative")
+	}
+	if search.Limit == 0 {
+		search.Limit = defaultSearchPageSize
+	}
+	if search.Limit > maxSearchPageSize {
+		search.Limit = maxSearchPageSize
+	}
+
+	if search.PageToken != "" {
+		offset, err := decodeSearchPageToken(search.PageToken, search.Query)
+		if err != nil {
+			return nil, err
+		}
+		search.Offset = offset
+	}
+
+	hits, total, err := s.repo.Search(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].Highlights = highlightUser(&hits[i].User, search.Terms)
+	}
+
+	page := &domain.UserSearchPage{Hits: hits, TotalCount: total}
+	if next := search.Offset + len(hits); next < total {
+		data, _ := json.Marshal(searchPageToken{Query: search.Query, Offset: next})
+		page.NextPageToken = base64.RawURLEncoding.EncodeToString(data)
+	}
+	return page, nil
+}
+
+func decodeSearchPageToken(raw, query string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return 0, domain.Validation("invalid page token")
+	}
+	var token searchPageToken
+	if err := json.Unmarshal(data, &token); err != nil || token.Offset < 0 {
+		return 0, domain.Validation("invalid page token")
+	}
+	if token.Query != query {
+		return 0, domain.Validation("page token does not match search query")
+	}
+	return token.Offset, nil
+}
+
+// highlightUser returns the searchable fields of user that contain any of
+// the terms, with every occurrence wrapped in <em>.
+func highlightUser(user *domain.User, terms []string) map[string]string {
+	fields := map[string]string{
+		"name":         user.Name,
+		"email":        user.Email,
+		"first_name":   user.FirstName,
+		"last_name":    user.LastName,
+		"display_name": user.DisplayName,
+	}
+	highlights := map[string]string{}
+	for field, value := range fields {
+		if highlighted, ok := highlight(value, terms); ok {
+			highlights[field] = highlighted
+		}
+	}
+	return highlights
+}
+
+// highlight wraps case-insensitive occurrences of terms in value with <em>
+// and HTML-escapes the rest. It reports whether anything matched.
+func highlight(value string, terms []string) (string, bool) {
+	runes := []rune(value)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	marked := make([]bool, len(runes))
+	matched := false
+	for _, term := range terms {
+		needle := []rune(term)
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) == term {
+				for j := i; j < i+len(needle); j++ {
+					marked[j] = true
+				}
+				matched = true
+			}
+		}
+	}
+	if !matched {
+		return "", false
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && marked[j] == marked[i] {
+			j++
+		}
+		segment := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			b.WriteString("<em>" + segment + "</em>")
+		} else {
+			b.WriteString(segment)
+		}
+		i = j
+	}
+	return b.String(), true
+}
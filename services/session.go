@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+	"user-srv/domain"
+)
+
+// ListSessions returns the active sessions of the user.
+func (s *userService) ListSessions(ctx context.Context, userID int) ([]domain.Session, error) {
+	return s.sessions.ListActive(ctx, userID)
+}
+
+// RevokeSession signs the user out of one session. Its access tokens stop
+// working and its refresh tokens can no longer be exchanged.
+func (s *userService) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	session, err := s.sessions.Get(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return domain.NotFound("session %s not found", sessionID)
+	}
+	return s.revokeSession(ctx, sessionID)
+}
+
+func (s *userService) revokeSession(ctx context.Context, sessionID string) error {
+	if err := s.sessions.Revoke(ctx, sessionID); err != nil {
+		return err
+	}
+	s.revocationCache.setSession(sessionID, true)
+	return nil
+}
+
+// createSession records a new sign-in of the user from the client of ctx.
+func (s *userService) createSession(ctx context.Context, userID int) (*domain.Session, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, domain.Internal("failed to generate session id", err)
+	}
+	client := ClientInfoFromContext(ctx)
+	session := &domain.Session{
+		ID:        id,
+		UserID:    userID,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+	}
+	if err := s.sessions.Create(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// touchSession updates when the session was last used. Failures are only
+// logged, the session keeps working.
+func (s *userService) touchSession(ctx context.Context, sessionID string) {
+	if err := s.sessions.Touch(ctx, sessionID, time.Now().UTC()); err != nil {
+		log.Printf("Failed to update session %s: %v", sessionID, err)
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"user-srv/domain"
+)
+
+func (r *memSessions) Revoke(ctx context.Context, id string) error {
+	if session, ok := r.sessions[id]; ok && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (r *memSessions) RevokeUser(ctx context.Context, userID int) error {
+	for id, session := range r.sessions {
+		if session.UserID == userID {
+			r.Revoke(ctx, id)
+		}
+	}
+	return nil
+}
+
+func TestRevokeSession(t *testing.T) {
+	s, _ := newRevocationTestService(t)
+	pair, identity := signIn(t, s)
+	other, _ := signIn(t, s)
+
+	if identity.SessionID == "" {
+		t.Fatalf("access token is not bound to a session")
+	}
+	if err := s.RevokeSession(context.Background(), 8, identity.SessionID); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("RevokeSession of another user's session = %v, want not found", err)
+	}
+	if err := s.RevokeSession(context.Background(), 7, identity.SessionID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+
+	if _, err := s.Authenticate(context.Background(), pair.AccessToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Errorf("Authenticate in a revoked session = %v, want unauthenticated", err)
+	}
+	if _, err := s.RefreshToken(context.Background(), pair.RefreshToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Errorf("RefreshToken of a revoked session = %v, want unauthenticated", err)
+	}
+	if _, err := s.Authenticate(context.Background(), other.AccessToken); err != nil {
+		t.Errorf("Authenticate in another session: %v", err)
+	}
+}
+
+func TestRevokedSessionOutlivesCache(t *testing.T) {
+	s, _ := newRevocationTestService(t)
+	pair, identity := signIn(t, s)
+
+	// Another instance revoked the session; this one reads it once its cache
+	// entry is gone.
+	if err := s.sessions.Revoke(context.Background(), identity.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	s.revocationCache = newRevocationCache(s.revocationCache.ttl)
+	if _, err := s.Authenticate(context.Background(), pair.AccessToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Errorf("Authenticate in a session revoked elsewhere = %v, want unauthenticated", err)
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"user-srv/domain"
+)
+
+// SetStatus moves the user to a new status. Suspending or disabling a user
+// revokes all of their tokens, so access ends right away; Login, token
+// refresh and Authenticate refuse non-active users from then on.
+func (s *userService) SetStatus(ctx context.Context, id int, status, reason string) (user *domain.User, err error) {
+	defer func() {
+		if err != nil {
+			s.auditFailure(ctx, domain.AuditUserStatus, id, err)
+		}
+	}()
+
+	if id <= 0 {
+		return nil, domain.Validation("id must be positive")
+	}
+	if !domain.IsValidStatus(status) {
+		return nil, domain.Validation("unknown status %q", status)
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, domain.Validation("reason cannot be empty")
+	}
+	if identity, ok := IdentityFromContext(ctx); ok && identity.UserID == id {
+		return nil, domain.PermissionDenied("cannot change your own status")
+	}
+
+	current, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status == status {
+		return current, nil
+	}
+	if !domain.CanTransition(current.Status, status) {
+		return nil, domain.Validation("cannot change status from %s to %s", current.Status, status)
+	}
+
+	audit := s.auditUser(ctx, domain.AuditUserStatus, reason, func(user *domain.User) domain.AuditChanges {
+		return userDiff(current, user)
+	})
+	user, err = s.repo.SetStatus(ctx, id, current.Status, status, reason, audit)
+	if err != nil {
+		return nil, err
+	}
+	s.revocationCache.setStatus(id, status)
+	if status != domain.StatusActive {
+		if err := s.revokeUserTokens(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// checkActive refuses to sign in users whose account is not active.
+func checkActive(user *domain.User) error {
+	return checkStatus(user.Status)
+}
+
+func checkStatus(status string) error {
+	switch status {
+	case domain.StatusActive:
+		return nil
+	case domain.StatusPending:
+		return domain.PermissionDenied("account is not activated yet")
+	default:
+		return domain.PermissionDenied("account is %s", status)
+	}
+}
+
+// checkUserActive rejects requests of users that are no longer active or
+// were deleted, so that access does not outlive the status change on other
+// instances by more than the cache ttl.
+func (s *userService) checkUserActive(ctx context.Context, userID int) error {
+	status, ok := s.revocationCache.status(userID)
+	if !ok {
+		var err error
+		if status, err = s.repo.GetStatus(ctx, userID); err != nil {
+			if errors.Is(err, domain.ErrNotFound) {
+				return domain.Unauthenticated("user no longer exists")
+			}
+			return err
+		}
+		s.revocationCache.setStatus(userID, status)
+	}
+	return checkStatus(status)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+func (r *staticUsers) SetStatus(ctx context.Context, id int, from, to, reason string, audit repositories.UserAudit) (*domain.User, error) {
+	user, ok := r.users[id]
+	if !ok || user.Status != from {
+		return nil, domain.NotFound("user with id %d not found", id)
+	}
+	user.Status = to
+	found := *user
+	return &found, nil
+}
+
+func (r *staticUsers) GetStatus(ctx context.Context, id int) (string, error) {
+	user, err := r.GetByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return user.Status, nil
+}
+
+func TestSetStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{domain.StatusPending, domain.StatusActive, true},
+		{domain.StatusPending, domain.StatusSuspended, false},
+		{domain.StatusActive, domain.StatusSuspended, true},
+		{domain.StatusActive, domain.StatusPending, false},
+		{domain.StatusSuspended, domain.StatusActive, true},
+		{domain.StatusSuspended, domain.StatusDisabled, true},
+		{domain.StatusDisabled, domain.StatusActive, true},
+		{domain.StatusDisabled, domain.StatusSuspended, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			s, _ := newRevocationTestService(t)
+			s.repo.(*staticUsers).users[8] = &domain.User{ID: 8, Status: tt.from}
+
+			user, err := s.SetStatus(context.Background(), 8, tt.to, "testing")
+			if !tt.allowed {
+				if !errors.Is(err, domain.ErrValidation) {
+					t.Errorf("SetStatus = %v, want a validation error", err)
+				}
+				return
+			}
+			if err != nil || user.Status != tt.to {
+				t.Errorf("SetStatus = %+v, %v, want status %s", user, err, tt.to)
+			}
+		})
+	}
+}
+
+func TestSetStatusValidation(t *testing.T) {
+	s, _ := newRevocationTestService(t)
+	self := ContextWithIdentity(context.Background(), &domain.Identity{UserID: 7, Role: domain.RoleAdmin})
+
+	if _, err := s.SetStatus(context.Background(), 7, "banned", "testing"); !errors.Is(err, domain.ErrValidation) {
+		t.Errorf("SetStatus to an unknown status = %v, want a validation error", err)
+	}
+	if _, err := s.SetStatus(context.Background(), 7, domain.StatusSuspended, " "); !errors.Is(err, domain.ErrValidation) {
+		t.Errorf("SetStatus without a reason = %v, want a validation error", err)
+	}
+	if _, err := s.SetStatus(self, 7, domain.StatusSuspended, "testing"); !errors.Is(err, domain.ErrPermissionDenied) {
+		t.Errorf("SetStatus of the caller = %v, want permission denied", err)
+	}
+}
+
+func TestSuspendingEndsAccess(t *testing.T) {
+	s, _ := newRevocationTestService(t)
+	pair, _ := signIn(t, s)
+
+	if _, err := s.SetStatus(context.Background(), 7, domain.StatusSuspended, "testing"); err != nil {
+		t.Fatalf("SetStatus: %v", err)
+	}
+	if _, err := s.Authenticate(context.Background(), pair.AccessToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Errorf("Authenticate of a suspended user = %v, want unauthenticated", err)
+	}
+	if _, err := s.RefreshToken(context.Background(), pair.RefreshToken); err == nil {
+		t.Errorf("RefreshToken of a suspended user succeeded")
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	for status, allowed := range map[string]bool{
+		domain.StatusActive:    true,
+		domain.StatusPending:   false,
+		domain.StatusSuspended: false,
+		domain.StatusDisabled:  false,
+	} {
+		if err := checkStatus(status); (err == nil) != allowed {
+			t.Errorf("checkStatus(%s) = %v", status, err)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+	"user-srv/domain"
+	"user-srv/repositories"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func (s *userService) RefreshToken(ctx context.Context, refreshToken string) (*domain.TokenPair, error) {
+	if strings.TrimSpace(refreshToken) == "" {
+		return nil, domain.Validation("refresh token cannot be empty")
+	}
+
+	stored, err := s.tokens.GetByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.Unauthenticated("invalid refresh token")
+		}
+		return nil, err
+	}
+
+	if stored.UsedAt != nil || stored.RevokedAt != nil {
+		s.revokeFamily(ctx, stored)
+		return nil, domain.Unauthenticated("invalid refresh token")
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, domain.Unauthenticated("refresh token expired")
+	}
+
+	user, err := s.repo.GetByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkActive(user); err != nil {
+		return nil, err
+	}
+
+	var session *domain.Session
+	if stored.SessionID != nil {
+		session, err = s.sessions.Get(ctx, *stored.SessionID)
+	} else {
+		// Issued before sessions existed: move the family into a new session.
+		session, err = s.createSession(ctx, user.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt != nil {
+		return nil, domain.Unauthenticated("session has been revoked")
+	}
+	sessionID := session.ID
+
+	// The family remembers whether its sign-in used a second factor.
+	accessToken, err := s.generateAccessToken(user, sessionID, stored.MFA)
+	if err != nil {
+		return nil, err
+	}
+	plain, next, err := s.newRefreshToken(stored.UserID, stored.FamilyID, sessionID, stored.MFA)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.tokens.Rotate(ctx, stored.ID, next); err != nil {
+		if errors.Is(err, repositories.ErrRefreshTokenUsed) {
+			// Lost a race with another exchange of the same token: treat as reuse.
+			s.revokeFamily(ctx, stored)
+			return nil, domain.Unauthenticated("invalid refresh token")
+		}
+		if errors.Is(err, repositories.ErrSessionRevoked) {
+			return nil, domain.Unauthenticated("session has been revoked")
+		}
+		return nil, domain.Internal("failed to rotate refresh token", err)
+	}
+	s.touchSession(ctx, sessionID)
+
+	return &domain.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: plain,
+		ExpiresIn:    int64(s.cfg.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Authenticate validates an access token and returns the identity it was issued for.
+func (s *userService) Authenticate(ctx context.Context, accessToken string) (*domain.Identity, error) {
+	claims, err := s.keys.Parse(accessToken)
+	if err != nil {
+		return nil, domain.Unauthenticated("%s", err.Error())
+	}
+	if _, ok := claims["typ"]; ok {
+		// Only access tokens carry no type; anything else, e.g. an MFA
+		// challenge, must not grant access.
+		return nil, domain.Unauthenticated("invalid token")
+	}
+
+	userID, ok := claims["id"].(float64)
+	if !ok {
+		return nil, domain.Unauthenticated("invalid token payload")
+	}
+	role, ok := claims["role"].(string)
+	if !ok {
+		return nil, domain.Unauthenticated("invalid token payload")
+	}
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return nil, domain.Unauthenticated("invalid token payload")
+	}
+	issuedAt, ok := claims["iat"].(float64)
+	if !ok {
+		return nil, domain.Unauthenticated("invalid token payload")
+	}
+	expiresAt, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, domain.Unauthenticated("invalid token payload")
+	}
+	sessionID, ok := claims["sid"].(string)
+	if !ok {
+		return nil, domain.Unauthenticated("invalid token payload")
+	}
+	mfa, _ := claims["mfa"].(bool)
+
+	identity := &domain.Identity{
+		UserID:      int(userID),
+		Role:        role,
+		SessionID:   sessionID,
+		TokenID:     jti,
+		ExpiresAt:   fromNumericDate(expiresAt),
+		MFA:         mfa,
+		MFARequired: role == domain.RoleAdmin && s.cfg.MFARequiredForAdmins,
+	}
+	if err := s.checkRevoked(ctx, identity, fromNumericDate(issuedAt)); err != nil {
+		return nil, err
+	}
+	if err := s.checkUserActive(ctx, identity.UserID); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// JWKS returns the public keys clients can use to verify access tokens.
+func (s *userService) JWKS() []domain.JWK {
+	return s.keys.JWKS()
+}
+
+// issueTokens starts a new session with a new refresh token family for the
+// user; mfa tells whether they signed in with a second factor.
+func (s *userService) issueTokens(ctx context.Context, user *domain.User, mfa bool) (*domain.TokenPair, error) {
+	session, err := s.createSession(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.generateAccessToken(user, session.ID, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, domain.Internal("failed to generate token", err)
+	}
+	plain, refresh, err := s.newRefreshToken(user.ID, familyID, session.ID, mfa)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokens.Create(ctx, refresh); err != nil {
+		return nil, domain.Internal("failed to generate token", err)
+	}
+
+	return &domain.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: plain,
+		ExpiresIn:    int64(s.cfg.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *userService) generateAccessToken(user *domain.User, sessionID string, mfa bool) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", domain.Internal("failed to generate token", err)
+	}
+	now := time.Now()
+	tokenString, err := s.keys.Sign(jwt.MapClaims{
+		"id":   user.ID,
+		"role": user.Role,
+		"sid":  sessionID,
+		"mfa":  mfa,
+		"jti":  jti,
+		"iat":  toNumericDate(now),
+		"exp":  now.Add(s.cfg.AccessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", domain.Internal("failed to generate token", err)
+	}
+	return tokenString, nil
+}
+
+func (s *userService) newRefreshToken(userID int, familyID, sessionID string, mfa bool) (string, *domain.RefreshToken, error) {
+	plain, err := randomToken(32)
+	if err != nil {
+		return "", nil, domain.Internal("failed to generate token", err)
+	}
+	return plain, &domain.RefreshToken{
+		UserID:    userID,
+		TokenHash: hashToken(plain),
+		FamilyID:  familyID,
+		SessionID: &sessionID,
+		MFA:       mfa,
+		ExpiresAt: time.Now().Add(s.cfg.RefreshTokenTTL),
+	}, nil
+}
+
+func (s *userService) revokeFamily(ctx context.Context, token *domain.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %d, revoking family %s", token.UserID, token.FamilyID)
+	if err := s.tokens.RevokeFamily(ctx, token.FamilyID); err != nil {
+		log.Printf("Failed to revoke refresh token family %s: %v", token.FamilyID, err)
+	}
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// toNumericDate keeps millisecond precision in "iat", so that tokens issued
+// right after a bulk revocation are not mistaken for revoked ones.
+func toNumericDate(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+func fromNumericDate(value float64) time.Time {
+	return time.UnixMilli(int64(math.Round(value * 1000)))
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+// memRefreshTokens keeps refresh tokens in memory and rotates them like the
+// repository does.
+type memRefreshTokens struct {
+	repositories.RefreshTokenRepository
+	tokens   []*domain.RefreshToken
+	sessions *memSessions
+}
+
+func (r *memRefreshTokens) Create(ctx context.Context, token *domain.RefreshToken) error {
+	token.ID = len(r.tokens) + 1
+	stored := *token
+	r.tokens = append(r.tokens, &stored)
+	return nil
+}
+
+func (r *memRefreshTokens) GetByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	for _, token := range r.tokens {
+		if token.TokenHash == hash {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, domain.NotFound("refresh token not found")
+}
+
+func (r *memRefreshTokens) Rotate(ctx context.Context, usedID int, next *domain.RefreshToken) error {
+	used := r.tokens[usedID-1]
+	if used.UsedAt != nil || used.RevokedAt != nil {
+		return repositories.ErrRefreshTokenUsed
+	}
+	if next.SessionID != nil && r.sessions.sessions[*next.SessionID].RevokedAt != nil {
+		return repositories.ErrSessionRevoked
+	}
+	now := time.Now()
+	used.UsedAt = &now
+	return r.Create(ctx, next)
+}
+
+func (r *memRefreshTokens) RevokeFamily(ctx context.Context, familyID string) error {
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// memSessions keeps sessions in memory.
+type memSessions struct {
+	repositories.SessionRepository
+	sessions map[string]*domain.Session
+}
+
+func (r *memSessions) Create(ctx context.Context, session *domain.Session) error {
+	stored := *session
+	r.sessions[session.ID] = &stored
+	return nil
+}
+
+func (r *memSessions) Get(ctx context.Context, id string) (*domain.Session, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, domain.NotFound("session %s not found", id)
+	}
+	found := *session
+	return &found, nil
+}
+
+func (r *memSessions) Touch(ctx context.Context, id string, seenAt time.Time) error {
+	return nil
+}
+
+// staticUsers serves a fixed set of users by id.
+type staticUsers struct {
+	repositories.UserRepository
+	users map[int]*domain.User
+}
+
+func (r *staticUsers) GetByID(ctx context.Context, id int) (*domain.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return nil, domain.NotFound("user with id %d not found", id)
+	}
+	found := *user
+	return &found, nil
+}
+
+func newRefreshTestService(t *testing.T) (*userService, *memRefreshTokens, *memSessions) {
+	t.Helper()
+	cfg := &config.Config{AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour}
+	keys, err := LoadKeySet(cfg)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	sessions := &memSessions{sessions: map[string]*domain.Session{}}
+	tokens := &memRefreshTokens{sessions: sessions}
+	users := &staticUsers{users: map[int]*domain.User{
+		7: {ID: 7, Role: domain.RoleUser, Status: domain.StatusActive},
+	}}
+	s := &userService{repo: users, tokens: tokens, sessions: sessions, keys: keys, cfg: cfg}
+	return s, tokens, sessions
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	s, tokens, _ := newRefreshTestService(t)
+	ctx := context.Background()
+	issued, err := s.issueTokens(ctx, &domain.User{ID: 7, Role: domain.RoleUser}, false)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+
+	refreshed, err := s.RefreshToken(ctx, issued.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if refreshed.RefreshToken == issued.RefreshToken || refreshed.AccessToken == "" {
+		t.Errorf("RefreshToken = %+v, want a new pair", refreshed)
+	}
+	if len(tokens.tokens) != 2 || tokens.tokens[0].UsedAt == nil || tokens.tokens[1].FamilyID != tokens.tokens[0].FamilyID {
+		t.Errorf("tokens = %+v, want the first used and a second of the same family", tokens.tokens)
+	}
+	if _, err := s.RefreshToken(ctx, refreshed.RefreshToken); err != nil {
+		t.Errorf("RefreshToken with the rotated token: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	s, _, _ := newRefreshTestService(t)
+	ctx := context.Background()
+	issued, err := s.issueTokens(ctx, &domain.User{ID: 7, Role: domain.RoleUser}, false)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+	refreshed, err := s.RefreshToken(ctx, issued.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+
+	if _, err := s.RefreshToken(ctx, issued.RefreshToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Fatalf("RefreshToken with a used token = %v, want unauthenticated", err)
+	}
+	// The thief may hold the rotated token instead, so it dies as well.
+	if _, err := s.RefreshToken(ctx, refreshed.RefreshToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Errorf("RefreshToken after reuse = %v, want unauthenticated", err)
+	}
+	if _, err := s.RefreshToken(ctx, "unknown"); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Errorf("RefreshToken with an unknown token = %v, want unauthenticated", err)
+	}
+}
+
+func TestRefreshTokenOfRevokedSession(t *testing.T) {
+	s, tokens, sessions := newRefreshTestService(t)
+	ctx := context.Background()
+	issued, err := s.issueTokens(ctx, &domain.User{ID: 7, Role: domain.RoleUser}, false)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+
+	// The session is revoked but its refresh tokens are not yet, as while
+	// racing sessions.Revoke.
+	now := time.Now()
+	sessions.sessions[*tokens.tokens[0].SessionID].RevokedAt = &now
+	if _, err := s.RefreshToken(ctx, issued.RefreshToken); !errors.Is(err, domain.ErrUnauthenticated) {
+		t.Fatalf("RefreshToken of a revoked session = %v, want unauthenticated", err)
+	}
+	if len(tokens.tokens) != 1 {
+		t.Errorf("a token was rotated into the revoked session")
+	}
+}
+
+func TestRefreshTokenKeepsMFA(t *testing.T) {
+	s, _ := newRevocationTestService(t)
+	ctx := context.Background()
+	issued, err := s.issueTokens(ctx, &domain.User{ID: 7, Role: domain.RoleUser}, true)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+	refreshed, err := s.RefreshToken(ctx, issued.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+
+	identity, err := s.Authenticate(ctx, refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !identity.MFA {
+		t.Error("refreshed access token lost the second factor of the sign-in")
+	}
+}
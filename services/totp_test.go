@@ -0,0 +1,70 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B lists 8-digit codes; the last 6 digits are the
+	// 6-digit codes for the same step.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0))); got != tt.code {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+
+	tests := []struct {
+		name   string
+		step   int64
+		code   string
+		wantOK bool
+	}{
+		{"current step", current, totpCode(rfc6238Secret, current), true},
+		{"previous step", current - 1, totpCode(rfc6238Secret, current-1), true},
+		{"next step", current + 1, totpCode(rfc6238Secret, current+1), true},
+		{"surrounding spaces", current, " " + totpCode(rfc6238Secret, current) + " ", true},
+		{"too old", current - 2, totpCode(rfc6238Secret, current-2), false},
+		{"too new", current + 2, totpCode(rfc6238Secret, current+2), false},
+		{"wrong code", 0, "000000", false},
+		{"empty", 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := matchTOTP(rfc6238Secret, tt.code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("matchTOTP ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != tt.step {
+				t.Errorf("matchTOTP step = %d, want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestMatchTOTPRejectsOtherSecret(t *testing.T) {
+	now := time.Unix(59, 0)
+	code := totpCode(rfc6238Secret, totpStep(now))
+	if _, ok := matchTOTP([]byte("00000000000000000000"), code, now); ok {
+		t.Error("matchTOTP accepted a code of another secret")
+	}
+}
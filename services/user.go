@@ -4,130 +4,463 @@ import (
 	"context"
 	"errors"
 	"golang.org/x/crypto/bcrypt"
+	"strconv"
 	"strings"
-	"time"
+	"unicode/utf8"
 	"user-srv/config"
 	"user-srv/domain"
 	"user-srv/repositories"
+)
 
-	"github.com/golang-jwt/jwt/v5"
+// Encrypted names and emails are stored as TEXT, so their lengths are
+// checked here.
+const (
+	maxNameLength  = 255
+	maxEmailLength = 255
 )
 
 type UserService interface {
 	Create(ctx context.Context, user *domain.User) error
 	GetByID(ctx context.Context, id int) (*domain.User, error)
-	GetAll(ctx context.Context) ([]domain.User, error)
+	List(ctx context.Context, query domain.UserQuery) (*domain.UserPage, error)
+	StreamUsers(ctx context.Context, query domain.UserQuery, send func(*domain.User) error) error
+	WatchUsers(ctx context.Context, fromRevision int64, send func(*domain.UserEvent) error) error
 	Update(ctx context.Context, user *domain.User) error
+	Patch(ctx context.Context, id int, patch domain.UserPatch) (*domain.User, error)
 	Delete(ctx context.Context, id int) error
-	Login(ctx context.Context, email, password string) (string, error)
+	Restore(ctx context.Context, id int) (*domain.User, error)
+	ExportUser(ctx context.Context, id int) (*domain.UserExport, error)
+	EraseUser(ctx context.Context, id int) error
+	SetStatus(ctx context.Context, id int, status, reason string) (*domain.User, error)
+	SearchUsers(ctx context.Context, search domain.UserSearch) (*domain.UserSearchPage, error)
+	GetMetadata(ctx context.Context, id int) (domain.Metadata, error)
+	UpdateMetadata(ctx context.Context, id int, changes domain.Metadata) (domain.Metadata, error)
+	DeleteMetadataKey(ctx context.Context, id int, key string) error
+	Login(ctx context.Context, email, password string) (*domain.LoginResult, error)
+	CompleteMFALogin(ctx context.Context, challenge, code string) (*domain.TokenPair, error)
+	EnrollTOTP(ctx context.Context, userID int) (*domain.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error)
+	RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, userID int, code string) error
+	ResetMFA(ctx context.Context, userID int) error
+	GetLoginLockout(ctx context.Context, userID int) (*domain.LoginThrottle, error)
+	UnlockUser(ctx context.Context, userID int) error
+	RefreshToken(ctx context.Context, refreshToken string) (*domain.TokenPair, error)
+	Authenticate(ctx context.Context, accessToken string) (*domain.Identity, error)
+	JWKS() []domain.JWK
+	Logout(ctx context.Context, refreshToken string) error
+	LogoutAll(ctx context.Context) error
+	ListSessions(ctx context.Context, userID int) ([]domain.Session, error)
+	RevokeSession(ctx context.Context, userID int, sessionID string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, password string) error
+	VerifyEmail(ctx context.Context, token string) error
+	CreateWebhook(ctx context.Context, webhook *domain.Webhook) (*domain.Webhook, error)
+	GetWebhook(ctx context.Context, id int) (*domain.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]domain.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook *domain.Webhook) (*domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	ListWebhookDeliveries(ctx context.Context, query domain.WebhookDeliveryQuery) (*domain.WebhookDeliveryPage, error)
+	ReplayWebhookDelivery(ctx context.Context, id int64) (*domain.WebhookDelivery, error)
+	ListAuditEvents(ctx context.Context, query domain.AuditQuery) (*domain.AuditPage, error)
+	VerifyAuditLog(ctx context.Context) (*domain.AuditVerification, error)
 }
 
 type userService struct {
-	repo repositories.UserRepository
-	cfg  *config.Config
+	repo            repositories.UserRepository
+	tokens          repositories.RefreshTokenRepository
+	resets          repositories.PasswordResetRepository
+	verifications   repositories.EmailVerificationRepository
+	mfa             repositories.MFARepository
+	throttles       repositories.LoginThrottleRepository
+	revocations     repositories.TokenRevocationRepository
+	sessions        repositories.SessionRepository
+	events          repositories.UserEventRepository
+	listener        *UserEventListener
+	webhooks        repositories.WebhookRepository
+	audits          repositories.AuditRepository
+	enc             *repositories.FieldEncryption
+	revocationCache *revocationCache
+	notifier        Notifier
+	keys            *KeySet
+	auditKey        []byte
+	cfg             *config.Config
 }
 
-func NewUserService(repo repositories.UserRepository) UserService {
+func NewUserService(
+	repo repositories.UserRepository,
+	tokens repositories.RefreshTokenRepository,
+	resets repositories.PasswordResetRepository,
+	verifications repositories.EmailVerificationRepository,
+	mfa repositories.MFARepository,
+	throttles repositories.LoginThrottleRepository,
+	revocations repositories.TokenRevocationRepository,
+	sessions repositories.SessionRepository,
+	events repositories.UserEventRepository,
+	listener *UserEventListener,
+	webhooks repositories.WebhookRepository,
+	audits repositories.AuditRepository,
+	enc *repositories.FieldEncryption,
+	notifier Notifier,
+	keys *KeySet,
+	auditKey []byte,
+) UserService {
+	cfg := config.LoadConfig()
 	return &userService{
-		repo: repo,
-		cfg:  config.LoadConfig(),
+		repo:            repo,
+		tokens:          tokens,
+		resets:          resets,
+		verifications:   verifications,
+		mfa:             mfa,
+		throttles:       throttles,
+		revocations:     revocations,
+		sessions:        sessions,
+		events:          events,
+		listener:        listener,
+		webhooks:        webhooks,
+		audits:          audits,
+		enc:             enc,
+		revocationCache: newRevocationCache(cfg.RevocationCacheTTL),
+		notifier:        notifier,
+		keys:            keys,
+		auditKey:        auditKey,
+		cfg:             cfg,
 	}
 }
 
-func (s *userService) Create(ctx context.Context, user *domain.User) error {
-	if strings.TrimSpace(user.Name) == "" {
-		return errors.New("name cannot be empty")
+func (s *userService) Create(ctx context.Context, user *domain.User) (err error) {
+	defer func() {
+		if err != nil {
+			s.auditFailure(ctx, domain.AuditUserCreate, user.ID, err)
+		}
+	}()
+
+	if err := validateName(user.Name); err != nil {
+		return err
 	}
-	if strings.TrimSpace(user.Email) == "" {
-		return errors.New("email cannot be empty")
+	if err := validateEmail(user.Email); err != nil {
+		return err
 	}
-	if !strings.Contains(user.Email, "@") {
-		return errors.New("invalid email format")
+	if err := validatePassword(user.Password); err != nil {
+		return err
 	}
-	if strings.TrimSpace(user.Password) == "" {
-		return errors.New("password cannot be empty")
+	if err := validateProfile(&user.Profile); err != nil {
+		return err
 	}
 
 	hashedPassword, err := hashPassword(user.Password)
 	if err != nil {
-		return errors.New("failed to hash password")
+		return domain.Internal("failed to hash password", err)
 	}
 	user.Password = hashedPassword
+	user.Role = domain.RoleUser
+	user.Metadata = domain.Metadata{}
+	user.EmailVerifiedAt = nil
+	user.Status = domain.StatusActive
+	if s.cfg.RequireVerifiedEmail {
+		// Activated by VerifyEmail.
+		user.Status = domain.StatusPending
+	}
 
-	return s.repo.Create(ctx, user)
+	audit := s.auditUser(ctx, domain.AuditUserCreate, "", func(created *domain.User) domain.AuditChanges {
+		return passwordChange(userDiff(nil, created), true)
+	})
+	if err := s.repo.Create(ctx, user, audit); err != nil {
+		return err
+	}
+	s.sendEmailVerification(ctx, user)
+	return nil
 }
 
 func (s *userService) GetByID(ctx context.Context, id int) (*domain.User, error) {
 	if id <= 0 {
-		return nil, errors.New("id must be positive")
+		return nil, domain.Validation("id must be positive")
 	}
-	return s.repo.GetByID(ctx, id)
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	redactPrivate(ctx, user)
+	return user, nil
 }
 
-func (s *userService) GetAll(ctx context.Context) ([]domain.User, error) {
-	return s.repo.GetAll(ctx)
+// redactPrivate clears the personal profile fields and the metadata of user
+// unless the caller is the user or an admin.
+func redactPrivate(ctx context.Context, user *domain.User) {
+	if canViewPrivate(ctx, user.ID) {
+		return
+	}
+	user.FirstName = ""
+	user.LastName = ""
+	user.PhoneNumber = ""
+	user.DateOfBirth = nil
+	user.Metadata = nil
 }
 
-func (s *userService) Update(ctx context.Context, user *domain.User) error {
+func (s *userService) Update(ctx context.Context, user *domain.User) (err error) {
+	defer func() {
+		if err != nil {
+			s.auditFailure(ctx, domain.AuditUserUpdate, user.ID, err)
+		}
+	}()
+
 	if user.ID <= 0 {
-		return errors.New("id must be positive")
+		return domain.Validation("id must be positive")
 	}
-	if strings.TrimSpace(user.Name) == "" {
-		return errors.New("name cannot be empty")
+	if err := validateName(user.Name); err != nil {
+		return err
 	}
-	if strings.TrimSpace(user.Email) == "" {
-		return errors.New("email cannot be empty")
+	if err := validateEmail(user.Email); err != nil {
+		return err
 	}
-	if !strings.Contains(user.Email, "@") {
-		return errors.New("invalid email format")
+	if err := validatePassword(user.Password); err != nil {
+		return err
 	}
-	if strings.TrimSpace(user.Password) == "" {
-		return errors.New("password cannot be empty")
+	if err := validateProfile(&user.Profile); err != nil {
+		return err
 	}
 
+	current, err := s.repo.GetByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	passwordChanged := bcrypt.CompareHashAndPassword([]byte(current.Password), []byte(user.Password)) != nil
+
 	hashedPassword, err := hashPassword(user.Password)
 	if err != nil {
-		return errors.New("failed to hash password")
+		return domain.Internal("failed to hash password", err)
 	}
 	user.Password = hashedPassword
 
-	return s.repo.Update(ctx, user)
+	audit := s.auditUser(ctx, domain.AuditUserUpdate, "", func(updated *domain.User) domain.AuditChanges {
+		changes := userDiff(current, updated)
+		if passwordChanged {
+			passwordChange(changes, false)
+		}
+		return changes
+	})
+	if err := s.repo.Update(ctx, user, audit); err != nil {
+		return err
+	}
+	if user.Email != current.Email {
+		s.sendEmailVerification(ctx, user)
+	}
+	if passwordChanged {
+		return s.revokeUserTokens(ctx, user.ID)
+	}
+	return nil
 }
 
-func (s *userService) Delete(ctx context.Context, id int) error {
+func (s *userService) Patch(ctx context.Context, id int, patch domain.UserPatch) (user *domain.User, err error) {
+	defer func() {
+		if err != nil {
+			s.auditFailure(ctx, domain.AuditUserUpdate, id, err)
+		}
+	}()
+
 	if id <= 0 {
-		return errors.New("id must be positive")
+		return nil, domain.Validation("id must be positive")
+	}
+	if patch.Name != nil {
+		if err := validateName(*patch.Name); err != nil {
+			return nil, err
+		}
 	}
-	return s.repo.Delete(ctx, id)
+	if patch.Email != nil {
+		if err := validateEmail(*patch.Email); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Password != nil {
+		if err := validatePassword(*patch.Password); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateProfilePatch(&patch); err != nil {
+		return nil, err
+	}
+
+	current, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Password != nil {
+		if bcrypt.CompareHashAndPassword([]byte(current.Password), []byte(*patch.Password)) == nil {
+			// Same password as before: keep the existing hash.
+			patch.Password = nil
+		} else {
+			hashedPassword, err := hashPassword(*patch.Password)
+			if err != nil {
+				return nil, domain.Internal("failed to hash password", err)
+			}
+			patch.Password = &hashedPassword
+		}
+	}
+
+	audit := s.auditUser(ctx, domain.AuditUserUpdate, "", func(updated *domain.User) domain.AuditChanges {
+		changes := userDiff(current, updated)
+		if patch.Password != nil {
+			passwordChange(changes, false)
+		}
+		return changes
+	})
+	user, err = s.repo.Patch(ctx, id, patch, audit)
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != current.Email {
+		s.sendEmailVerification(ctx, user)
+	}
+	if patch.Password != nil {
+		if err := s.revokeUserTokens(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
 }
 
-func (s *userService) Login(ctx context.Context, email, password string) (string, error) {
+func (s *userService) Delete(ctx context.Context, id int) (err error) {
+	defer func() {
+		if err != nil {
+			s.auditFailure(ctx, domain.AuditUserDelete, id, err)
+		}
+	}()
+
+	if id <= 0 {
+		return domain.Validation("id must be positive")
+	}
+	current, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	audit := s.auditUser(ctx, domain.AuditUserDelete, "", func(*domain.User) domain.AuditChanges {
+		return userDiff(current, nil)
+	})
+	if err := s.repo.Delete(ctx, id, audit); err != nil {
+		return err
+	}
+	return s.revokeUserTokens(ctx, id)
+}
+
+// Restore brings back a soft-deleted user. Tokens revoked on deletion stay
+// revoked; the user has to sign in again.
+func (s *userService) Restore(ctx context.Context, id int) (*domain.User, error) {
+	if id <= 0 {
+		return nil, domain.Validation("id must be positive")
+	}
+	audit := s.auditUser(ctx, domain.AuditUserRestore, "", func(*domain.User) domain.AuditChanges {
+		return domain.AuditChanges{"deleted": {Before: true, After: false}}
+	})
+	user, err := s.repo.Restore(ctx, id, audit)
+	if err != nil {
+		s.auditFailure(ctx, domain.AuditUserRestore, id, err)
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *userService) Login(ctx context.Context, email, password string) (result *domain.LoginResult, err error) {
+	var user *domain.User
+	defer func() {
+		var userID int
+		if user != nil {
+			userID = user.ID
+		}
+		var reason string
+		if result != nil && result.MFAChallenge != "" {
+			reason = "mfa required"
+		}
+		if err = s.audit(ctx, domain.AuditLogin, userID, reason, nil, err); err != nil {
+			result = nil
+		}
+	}()
+
 	if strings.TrimSpace(email) == "" {
-		return "", errors.New("email cannot be empty")
+		return nil, domain.Validation("email cannot be empty")
 	}
 	if strings.TrimSpace(password) == "" {
-		return "", errors.New("password cannot be empty")
+		return nil, domain.Validation("password cannot be empty")
+	}
+
+	if err := s.checkLoginThrottle(ctx, domain.ThrottleIP, ClientInfoFromContext(ctx).IP); err != nil {
+		return nil, err
 	}
 
-	user, err := s.repo.GetByEmail(ctx, email)
+	user, err = s.repo.GetByEmail(ctx, email)
 	if err != nil {
-		return "", errors.New("invalid email or password")
+		if errors.Is(err, domain.ErrNotFound) {
+			s.recordLoginFailure(ctx, 0)
+			return nil, domain.Unauthenticated("invalid email or password")
+		}
+		return nil, err
 	}
 
+	if err := s.checkLoginThrottle(ctx, domain.ThrottleAccount, strconv.Itoa(user.ID)); err != nil {
+		return nil, err
+	}
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", errors.New("invalid email or password")
+		s.recordLoginFailure(ctx, user.ID)
+		return nil, domain.Unauthenticated("invalid email or password")
+	}
+	if s.cfg.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return nil, domain.PermissionDenied("email address is not verified")
+	}
+	if err := checkActive(user); err != nil {
+		return nil, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  user.ID,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(s.cfg.JWTSecret))
+	enabled, err := s.mfaEnabled(ctx, user.ID)
 	if err != nil {
-		return "", errors.New("failed to generate token")
+		return nil, err
 	}
+	if enabled {
+		// Failures are cleared by CompleteMFALogin, otherwise the password
+		// alone would lift the limit on guessing second factors.
+		challenge, err := s.generateMFAChallenge(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &domain.LoginResult{MFAChallenge: challenge}, nil
+	}
+
+	// Only a login that passed every check clears the failures, so a known
+	// password of a blocked account cannot be used to lift a lockout.
+	s.resetLoginFailures(ctx, user.ID)
+	tokens, err := s.issueTokens(ctx, user, false)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.LoginResult{Tokens: tokens}, nil
+}
+
+func validateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return domain.Validation("name cannot be empty")
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return domain.Validation("name must be at most %d characters", maxNameLength)
+	}
+	return nil
+}
 
-	return tokenString, nil
+func validateEmail(email string) error {
+	if strings.TrimSpace(email) == "" {
+		return domain.Validation("email cannot be empty")
+	}
+	if !strings.Contains(email, "@") {
+		return domain.Validation("invalid email format")
+	}
+	if utf8.RuneCountInString(email) > maxEmailLength {
+		return domain.Validation("email must be at most %d characters", maxEmailLength)
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if strings.TrimSpace(password) == "" {
+		return domain.Validation("password cannot be empty")
+	}
+	return nil
 }
 
 func hashPassword(password string) (string, error) {
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"user-srv/domain"
+)
+
+func TestRedactPrivate(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity *domain.Identity
+		private  bool
+	}{
+		{"anonymous", nil, false},
+		{"other user", &domain.Identity{UserID: 2, Role: domain.RoleUser}, false},
+		{"self", &domain.Identity{UserID: 1, Role: domain.RoleUser}, true},
+		{"admin", &domain.Identity{UserID: 2, Role: domain.RoleAdmin}, true},
+		{"admin without mfa", &domain.Identity{UserID: 2, Role: domain.RoleAdmin, MFARequired: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dateOfBirth := "1990-01-31"
+			user := &domain.User{
+				ID:   1,
+				Name: "Ada",
+				Profile: domain.Profile{
+					FirstName:   "Ada",
+					LastName:    "Lovelace",
+					DisplayName: "ada",
+					PhoneNumber: "+14155552671",
+					DateOfBirth: &dateOfBirth,
+				},
+				Metadata: domain.Metadata{"app/plan": json.RawMessage(`"pro"`)},
+			}
+			ctx := context.Background()
+			if tt.identity != nil {
+				ctx = ContextWithIdentity(ctx, tt.identity)
+			}
+
+			redactPrivate(ctx, user)
+
+			hidden := user.FirstName == "" && user.LastName == "" && user.PhoneNumber == "" &&
+				user.DateOfBirth == nil && user.Metadata == nil
+			if hidden == tt.private {
+				t.Fatalf("private fields hidden = %v, want %v", hidden, !tt.private)
+			}
+			if user.Name != "Ada" || user.DisplayName != "ada" {
+				t.Errorf("public fields were cleared: %+v", user)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"user-srv/domain"
+)
+
+func (s *userService) VerifyEmail(ctx context.Context, token string) error {
+	if strings.TrimSpace(token) == "" {
+		return domain.Validation("token cannot be empty")
+	}
+
+	// Verifying the email activates a pending account.
+	activation := domain.StatusChange{From: domain.StatusPending, To: domain.StatusActive, Reason: "email verified"}
+	if !domain.CanTransition(activation.From, activation.To) {
+		return domain.Internal("cannot activate users on email verification", nil)
+	}
+
+	audit := s.auditUser(ctx, domain.AuditUserEmailVerify, "", nil)
+	activationAudit := s.auditUser(ctx, domain.AuditUserStatus, activation.Reason, func(*domain.User) domain.AuditChanges {
+		return domain.AuditChanges{"status": {Before: activation.From, After: activation.To}}
+	})
+	userID, activated, err := s.verifications.Verify(ctx, hashToken(token), activation, audit, activationAudit)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			err = domain.Validation("invalid or expired verification token")
+		}
+		s.auditFailure(ctx, domain.AuditUserEmailVerify, 0, err)
+		return err
+	}
+	if activated {
+		s.revocationCache.setStatus(userID, activation.To)
+	}
+	return nil
+}
+
+// sendEmailVerification mails a verification token for the user's current
+// address. Failures are logged rather than returned so that they do not undo
+// the change that triggered the email.
+func (s *userService) sendEmailVerification(ctx context.Context, user *domain.User) {
+	plain, err := randomToken(32)
+	if err != nil {
+		log.Printf("Failed to generate email verification token for user %d: %v", user.ID, err)
+		return
+	}
+	token := &domain.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: hashToken(plain),
+		ExpiresAt: time.Now().Add(s.cfg.EmailVerificationTTL),
+	}
+	if err := s.verifications.Create(ctx, token); err != nil {
+		log.Printf("Failed to store email verification token for user %d: %v", user.ID, err)
+		return
+	}
+
+	body := fmt.Sprintf("Use this token to verify your email address: %s\nIt expires in %s.", plain, s.cfg.EmailVerificationTTL)
+	if err := s.notifier.Notify(ctx, user.Email, "Verify your email", body); err != nil {
+		log.Printf("Failed to send email verification to user %d: %v", user.ID, err)
+	}
+}
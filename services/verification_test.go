@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+// memVerifications redeems every stored token of an unactivated user.
+type memVerifications struct {
+	repositories.EmailVerificationRepository
+	tokens []*domain.EmailVerificationToken
+}
+
+func (r *memVerifications) Create(ctx context.Context, token *domain.EmailVerificationToken) error {
+	r.tokens = append(r.tokens, token)
+	return nil
+}
+
+func (r *memVerifications) Verify(ctx context.Context, tokenHash string, activation domain.StatusChange, audit, activationAudit repositories.UserAudit) (int, bool, error) {
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash {
+			return token.UserID, false, nil
+		}
+	}
+	return 0, false, domain.NotFound("verification token not found")
+}
+
+func TestEmailVerification(t *testing.T) {
+	verifications, notifier := &memVerifications{}, &sentNotifications{}
+	s := &userService{
+		verifications:   verifications,
+		notifier:        notifier,
+		audits:          &appendAuditRepository{},
+		revocationCache: newRevocationCache(time.Minute),
+		cfg:             &config.Config{EmailVerificationTTL: time.Hour},
+	}
+
+	s.sendEmailVerification(context.Background(), &domain.User{ID: 7, Email: "ada@example.com"})
+	if len(verifications.tokens) != 1 || len(notifier.messages) != 1 {
+		t.Fatalf("stored %d tokens and sent %d messages, want 1", len(verifications.tokens), len(notifier.messages))
+	}
+	token := sentToken(t, notifier.messages[0])
+	stored := verifications.tokens[0]
+	if stored.UserID != 7 || stored.Email != "ada@example.com" || stored.TokenHash != hashToken(token) {
+		t.Errorf("stored token = %+v, want the hash of %q for ada@example.com", stored, token)
+	}
+	if until := time.Until(stored.ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %s, want an hour", until)
+	}
+
+	if err := s.VerifyEmail(context.Background(), token); err != nil {
+		t.Errorf("VerifyEmail: %v", err)
+	}
+	if err := s.VerifyEmail(context.Background(), "unknown"); !errors.Is(err, domain.ErrValidation) {
+		t.Errorf("VerifyEmail of an unknown token = %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+
+	"github.com/lib/pq"
+)
+
+// watchBatchSize is the number of events a watcher reads at a time.
+const watchBatchSize = 100
+
+// userEventPollInterval bounds how long a watcher can miss a notification,
+// e.g. one sent while the listener was reconnecting.
+const userEventPollInterval = time.Minute
+
+// UserEventListener listens for notifications about new user events from any
+// replica and wakes up the watchers of this one.
+type UserEventListener struct {
+	listener *pq.Listener
+
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+func NewUserEventListener(cfg *config.Config) *UserEventListener {
+	report := func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("User event listener: %v", err)
+		}
+	}
+	return &UserEventListener{
+		listener: pq.NewListener(connectionString(cfg), time.Second, time.Minute, report),
+		changed:  make(chan struct{}),
+	}
+}
+
+// Run listens on repositories.UserEventsChannel until ctx is cancelled.
+func (l *UserEventListener) Run(ctx context.Context) {
+	defer l.listener.Close()
+	if err := l.listener.Listen(repositories.UserEventsChannel); err != nil {
+		log.Printf("Failed to listen for user events, falling back to polling: %v", err)
+	}
+
+	ticker := time.NewTicker(userEventPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.listener.Notify:
+			// A nil notification after a reconnect also means events may
+			// have been missed, so it wakes watchers up as well.
+		case <-ticker.C:
+			go l.listener.Ping()
+		}
+		l.broadcast()
+	}
+}
+
+// Changed returns a channel that is closed on the next notification.
+func (l *UserEventListener) Changed() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+func (l *UserEventListener) broadcast() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// WatchUsers calls send for every user event after fromRevision, oldest
+// first, and then for every new one until ctx is done or send fails. A
+// fromRevision of 0 starts with the next change.
+func (s *userService) WatchUsers(ctx context.Context, fromRevision int64, send func(*domain.UserEvent) error) error {
+	if fromRevision < 0 {
+		return domain.Validation("revision cannot be negative")
+	}
+	oldest, latest, err := s.events.Revisions(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fromRevision == 0:
+		fromRevision = latest
+	case fromRevision > latest:
+		return domain.Validation("revision %d is ahead of the latest revision %d", fromRevision, latest)
+	case fromRevision < oldest-1:
+		return domain.Validation("revision %d is no longer available, the oldest is %d", fromRevision, oldest)
+	}
+
+	for {
+		// Taken before reading, so a notification for an event committed
+		// after the read is not lost.
+		changed := s.listener.Changed()
+
+		events, err := s.events.ListAfter(ctx, fromRevision, watchBatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range events {
+			if err := send(&events[i]); err != nil {
+				return err
+			}
+			fromRevision = events[i].Revision
+		}
+		if len(events) == watchBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"user-srv/aesgcm"
+	"user-srv/domain"
+)
+
+const (
+	minWebhookSecretLength = 16
+
+	defaultDeliveryPageSize = 50
+	maxDeliveryPageSize     = 500
+)
+
+// CreateWebhook subscribes a URL to user changes. Without a secret, one is
+// generated. The returned webhook carries the plain secret, which is the only
+// time it is shown.
+func (s *userService) CreateWebhook(ctx context.Context, webhook *domain.Webhook) (*domain.Webhook, error) {
+	if err := validateWebhook(webhook); err != nil {
+		return nil, err
+	}
+	secret, err := webhookSecret(webhook.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := *webhook
+	if stored.Secret, err = s.encryptWebhookSecret(secret); err != nil {
+		return nil, err
+	}
+	if err := s.webhooks.Create(ctx, &stored); err != nil {
+		return nil, err
+	}
+	stored.Secret = secret
+	return &stored, nil
+}
+
+func (s *userService) GetWebhook(ctx context.Context, id int) (*domain.Webhook, error) {
+	webhook, err := s.webhooks.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (s *userService) ListWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	webhooks, err := s.webhooks.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the URL, event types and active flag of a webhook.
+// A non-empty secret replaces the current one and is returned like on
+// creation.
+func (s *userService) UpdateWebhook(ctx context.Context, webhook *domain.Webhook) (*domain.Webhook, error) {
+	if err := validateWebhook(webhook); err != nil {
+		return nil, err
+	}
+	current, err := s.webhooks.Get(ctx, webhook.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := *webhook
+	stored.Secret = current.Secret
+	if webhook.Secret != "" {
+		secret, err := webhookSecret(webhook.Secret)
+		if err != nil {
+			return nil, err
+		}
+		if stored.Secret, err = s.encryptWebhookSecret(secret); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.webhooks.Update(ctx, &stored); err != nil {
+		return nil, err
+	}
+	stored.Secret = webhook.Secret
+	return &stored, nil
+}
+
+func (s *userService) DeleteWebhook(ctx context.Context, id int) error {
+	return s.webhooks.Delete(ctx, id)
+}
+
+func (s *userService) ListWebhookDeliveries(ctx context.Context, query domain.WebhookDeliveryQuery) (*domain.WebhookDeliveryPage, error) {
+	if _, err := s.webhooks.Get(ctx, query.WebhookID); err != nil {
+		return nil, err
+	}
+	switch query.Status {
+	case "", domain.WebhookDeliveryPending, domain.WebhookDeliverySucceeded, domain.WebhookDeliveryFailed:
+	default:
+		return nil, domain.Validation("unknown delivery status %q", query.Status)
+	}
+	if query.Limit < 0 {
+		return nil, domain.Validation("limit cannot be negative")
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultDeliveryPageSize
+	}
+	if query.Limit > maxDeliveryPageSize {
+		query.Limit = maxDeliveryPageSize
+	}
+	if query.PageToken != "" {
+		before, err := decodeIDPageToken(query.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		query.Before = before
+	}
+
+	deliveries, err := s.webhooks.ListDeliveries(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	page := &domain.WebhookDeliveryPage{Deliveries: deliveries}
+	if len(deliveries) == query.Limit {
+		page.NextPageToken = encodeIDPageToken(deliveries[len(deliveries)-1].ID)
+	}
+	return page, nil
+}
+
+// ReplayWebhookDelivery sends a failed delivery again.
+func (s *userService) ReplayWebhookDelivery(ctx context.Context, id int64) (*domain.WebhookDelivery, error) {
+	return s.webhooks.ReplayDelivery(ctx, id)
+}
+
+func validateWebhook(webhook *domain.Webhook) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return domain.Validation("url must be an absolute http(s) URL")
+	}
+	if len(webhook.URL) > 2048 {
+		return domain.Validation("url must be at most 2048 characters")
+	}
+	seen := map[string]bool{}
+	for _, eventType := range webhook.EventTypes {
+		if !domain.IsValidWebhookEventType(eventType) {
+			return domain.Validation("unknown event type %q", eventType)
+		}
+		if seen[eventType] {
+			return domain.Validation("duplicate event type %q", eventType)
+		}
+		seen[eventType] = true
+	}
+	return nil
+}
+
+// webhookSecret returns secret, or a new random one when it is empty.
+func webhookSecret(secret string) (string, error) {
+	if secret == "" {
+		generated, err := randomToken(32)
+		if err != nil {
+			return "", domain.Internal("failed to generate webhook secret", err)
+		}
+		return "whsec_" + generated, nil
+	}
+	if len(secret) < minWebhookSecretLength {
+		return "", domain.Validation("secret must be at least %d characters", minWebhookSecretLength)
+	}
+	return secret, nil
+}
+
+func (s *userService) encryptWebhookSecret(secret string) (string, error) {
+	key, err := aesgcm.DecodeKey(s.cfg.WebhookEncryptionKey)
+	if err != nil {
+		return "", domain.Internal("webhooks are not configured", err)
+	}
+	encrypted, err := aesgcm.Seal(key, []byte(secret), nil)
+	if err != nil {
+		return "", domain.Internal("failed to encrypt webhook secret", err)
+	}
+	return encrypted, nil
+}
+
+// encodeIDPageToken returns a page token for the page after the row with
+// the given id, for lists ordered newest first.
+func encodeIDPageToken(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeIDPageToken(raw string) (int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return 0, domain.Validation("invalid page token")
+	}
+	before, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil || before <= 0 {
+		return 0, domain.Validation("invalid page token")
+	}
+	return before, nil
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"user-srv/aesgcm"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+// Headers of webhook requests. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" with the webhook's secret, so receivers can reject
+// forged and replayed requests.
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookBatchSize is the number of deliveries a deliverer claims at a time.
+const webhookBatchSize = 20
+
+// webhookSubscriptionSink turns outbox messages into deliveries for the
+// webhooks subscribed to them.
+type webhookSubscriptionSink struct {
+	webhooks repositories.WebhookRepository
+}
+
+func NewWebhookSubscriptionSink(webhooks repositories.WebhookRepository) OutboxSink {
+	return &webhookSubscriptionSink{webhooks: webhooks}
+}
+
+func (s *webhookSubscriptionSink) Publish(ctx context.Context, message *domain.OutboxMessage) error {
+	data, err := encodeOutboxMessage(message)
+	if err != nil {
+		return err
+	}
+	return s.webhooks.Enqueue(ctx, message.ID, message.UserID, message.Type, data)
+}
+
+type multiSink []OutboxSink
+
+// NewMultiSink returns a sink that publishes every message to all sinks. A
+// message that fails on one of them is published to all of them again, so
+// every sink has to tolerate duplicates.
+func NewMultiSink(sinks ...OutboxSink) OutboxSink {
+	return multiSink(sinks)
+}
+
+func (s multiSink) Publish(ctx context.Context, message *domain.OutboxMessage) error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.Publish(ctx, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WebhookDeliverer sends pending webhook deliveries, retrying failed ones
+// with exponential backoff. Deliverers on several replicas can run side by
+// side.
+type WebhookDeliverer struct {
+	webhooks repositories.WebhookRepository
+	client   *http.Client
+	cfg      *config.Config
+}
+
+func NewWebhookDeliverer(webhooks repositories.WebhookRepository) *WebhookDeliverer {
+	cfg := config.LoadConfig()
+	return &WebhookDeliverer{
+		webhooks: webhooks,
+		client: &http.Client{
+			Timeout: cfg.WebhookTimeout,
+			// A redirect is an answer of its own, not a delivery.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cfg: cfg,
+	}
+}
+
+// Run delivers webhooks until ctx is cancelled, checking for due deliveries
+// every WebhookPollInterval once none are left.
+func (d *WebhookDeliverer) Run(ctx context.Context) {
+	for {
+		if d.deliverBatch(ctx) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.cfg.WebhookPollInterval):
+		}
+	}
+}
+
+// deliverBatch sends one batch of deliveries and returns how many it claimed.
+func (d *WebhookDeliverer) deliverBatch(ctx context.Context) int {
+	lease := d.cfg.WebhookTimeout*webhookBatchSize + time.Minute
+	deliveries, err := d.webhooks.ClaimDeliveries(ctx, webhookBatchSize, lease)
+	if err != nil {
+		log.Printf("Failed to claim webhook deliveries: %v", err)
+		return 0
+	}
+
+	webhooks := map[int]*domain.Webhook{}
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		webhook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			if webhook, err = d.webhooks.Get(ctx, delivery.WebhookID); err != nil {
+				log.Printf("Failed to get webhook %d: %v", delivery.WebhookID, err)
+				continue
+			}
+			webhooks[delivery.WebhookID] = webhook
+		}
+		d.deliver(ctx, webhook, delivery)
+	}
+	return len(deliveries)
+}
+
+func (d *WebhookDeliverer) deliver(ctx context.Context, webhook *domain.Webhook, delivery *domain.WebhookDelivery) {
+	attempt := &domain.WebhookAttempt{DeliveryID: delivery.ID}
+	start := time.Now()
+	statusCode, err := d.send(ctx, webhook, delivery)
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+	}
+
+	status, retryIn := domain.WebhookDeliverySucceeded, time.Duration(0)
+	if err != nil {
+		attempt.Error = err.Error()
+		if attempts := delivery.Attempts + 1; attempts >= d.cfg.WebhookMaxAttempts {
+			log.Printf("Giving up on webhook delivery %d after %d attempts: %v", delivery.ID, attempts, err)
+			status = domain.WebhookDeliveryFailed
+		} else {
+			status, retryIn = domain.WebhookDeliveryPending, d.retryDelay(attempts)
+		}
+	}
+	if err := d.webhooks.FinishAttempt(ctx, attempt, status, retryIn); err != nil {
+		log.Printf("Failed to update webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// send posts the delivery and returns the response status code, 0 if there
+// was no response.
+func (d *WebhookDeliverer) send(ctx context.Context, webhook *domain.Webhook, delivery *domain.WebhookDelivery) (int, error) {
+	secret, err := d.decryptSecret(webhook.Secret)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	// The outbox message id stays the same across retries and replays, so
+	// receivers can deduplicate by it.
+	req.Header.Set(WebhookIDHeader, strconv.FormatInt(delivery.MessageID, 10))
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *WebhookDeliverer) decryptSecret(encrypted string) ([]byte, error) {
+	key, err := aesgcm.DecodeKey(d.cfg.WebhookEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("webhooks are not configured: %v", err)
+	}
+	secret, err := aesgcm.Open(key, encrypted, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt webhook secret: %v", err)
+	}
+	return secret, nil
+}
+
+// retryDelay doubles the wait after every failed attempt, up to
+// WebhookRetryMax.
+func (d *WebhookDeliverer) retryDelay(attempts int) time.Duration {
+	delay := d.cfg.WebhookRetryBase << (attempts - 1)
+	if delay > d.cfg.WebhookRetryMax || delay <= 0 {
+		delay = d.cfg.WebhookRetryMax
+	}
+	return delay
+}
+
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+	"user-srv/aesgcm"
+	"user-srv/config"
+	"user-srv/domain"
+	"user-srv/repositories"
+)
+
+// finishedAttempts records the outcome of every delivery attempt.
+type finishedAttempts struct {
+	repositories.WebhookRepository
+	attempts []*domain.WebhookAttempt
+	statuses []string
+	retries  []time.Duration
+}
+
+func (r *finishedAttempts) FinishAttempt(ctx context.Context, attempt *domain.WebhookAttempt, status string, retryIn time.Duration) error {
+	r.attempts = append(r.attempts, attempt)
+	r.statuses = append(r.statuses, status)
+	r.retries = append(r.retries, retryIn)
+	return nil
+}
+
+func newTestDeliverer(t *testing.T, url string) (*WebhookDeliverer, *finishedAttempts, *domain.Webhook) {
+	t.Helper()
+	encoded := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	key, err := aesgcm.DecodeKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := aesgcm.Seal(key, []byte("whsec"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &finishedAttempts{}
+	d := NewWebhookDeliverer(repo)
+	d.cfg = &config.Config{
+		WebhookEncryptionKey: encoded,
+		WebhookMaxAttempts:   3,
+		WebhookRetryBase:     time.Minute,
+		WebhookRetryMax:      time.Hour,
+	}
+	return d, repo, &domain.Webhook{ID: 1, URL: url, Secret: secret, Active: true}
+}
+
+func TestWebhookDeliveryIsSigned(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	d, repo, webhook := newTestDeliverer(t, server.URL)
+	delivery := &domain.WebhookDelivery{ID: 5, MessageID: 42, EventType: "user.created", Payload: []byte(`{"id":7}`)}
+	d.deliver(context.Background(), webhook, delivery)
+
+	if len(repo.statuses) != 1 || repo.statuses[0] != domain.WebhookDeliverySucceeded {
+		t.Fatalf("statuses = %v, want succeeded", repo.statuses)
+	}
+	if code := repo.attempts[0].StatusCode; code == nil || *code != http.StatusOK {
+		t.Errorf("attempt status code = %v, want 200", code)
+	}
+	if string(body) != `{"id":7}` || header.Get(WebhookIDHeader) != "42" || header.Get(WebhookEventHeader) != "user.created" {
+		t.Errorf("request = %s %v", body, header)
+	}
+	timestamp := header.Get(WebhookTimestampHeader)
+	if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("timestamp = %q", timestamp)
+	}
+	want := "sha256=" + signWebhook([]byte("whsec"), timestamp, body)
+	if got := header.Get(WebhookSignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if signWebhook([]byte("whsec"), timestamp, []byte(`{"id":8}`)) == signWebhook([]byte("whsec"), timestamp, body) {
+		t.Errorf("signature does not depend on the body")
+	}
+	if signWebhook([]byte("other"), timestamp, body) == signWebhook([]byte("whsec"), timestamp, body) {
+		t.Errorf("signature does not depend on the secret")
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status == http.StatusFound {
+			w.Header().Set("Location", "/elsewhere")
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	d, repo, webhook := newTestDeliverer(t, server.URL)
+	delivery := &domain.WebhookDelivery{ID: 5, MessageID: 42, Payload: []byte(`{}`)}
+	d.deliver(context.Background(), webhook, delivery)
+	delivery.Attempts = 1
+	status = http.StatusFound
+	d.deliver(context.Background(), webhook, delivery)
+	delivery.Attempts = 2
+	d.deliver(context.Background(), webhook, delivery)
+
+	want := []string{domain.WebhookDeliveryPending, domain.WebhookDeliveryPending, domain.WebhookDeliveryFailed}
+	for i := range want {
+		if repo.statuses[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", repo.statuses, want)
+		}
+	}
+	if repo.retries[0] != time.Minute || repo.retries[1] != 2*time.Minute {
+		t.Errorf("retries = %v, want 1m and 2m", repo.retries)
+	}
+	// Redirects are not followed.
+	if code := repo.attempts[1].StatusCode; code == nil || *code != http.StatusFound || repo.attempts[1].Error == "" {
+		t.Errorf("attempt after a redirect = %+v, want a failed 302", repo.attempts[1])
+	}
+}
+
+func TestWebhookDeliveryWithoutResponse(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	d, repo, webhook := newTestDeliverer(t, server.URL)
+	d.deliver(context.Background(), webhook, &domain.WebhookDelivery{ID: 5, Payload: []byte(`{}`)})
+
+	if repo.statuses[0] != domain.WebhookDeliveryPending || repo.attempts[0].StatusCode != nil || repo.attempts[0].Error == "" {
+		t.Errorf("attempt = %+v, %s, want a pending retry without status code", repo.attempts[0], repo.statuses[0])
+	}
+}